@@ -94,17 +94,21 @@ func (ch ChunkHeader) String() string {
 // Chunk structure definition
 type Chunk struct {
 	Offset        int64
+	Index         int64 // index in the file, -1 if unknown
 	Header        ChunkHeader
 	StringTable   ChunkStringTable
 	TemplateTable TemplateTable
 	EventOffsets  []int32
 	Data          []byte
+	// TypedValues keeps the Go type of the values of the events (see
+	// File.SetTypedValues)
+	TypedValues bool
 }
 
 // NewChunk initialize and returns a new Chunk structure
 // return Chunk
 func NewChunk() Chunk {
-	return Chunk{StringTable: make(ChunkStringTable, 0), TemplateTable: make(TemplateTable, 0)}
+	return Chunk{Index: -1, StringTable: make(ChunkStringTable, 0), TemplateTable: make(TemplateTable, 0)}
 }
 
 // ParseChunkHeader parses a chunk header at offset
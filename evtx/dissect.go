@@ -0,0 +1,698 @@
+package evtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/encoding"
+)
+
+const (
+	// maximum nesting of templates and BinXML values walked by the dissector
+	maxDissectDepth = 32
+)
+
+var (
+	valueTypeNames = map[ValueType]string{
+		NullType:       "Null",
+		StringType:     "String",
+		AnsiStringType: "AnsiString",
+		Int8Type:       "Int8",
+		UInt8Type:      "UInt8",
+		Int16Type:      "Int16",
+		UInt16Type:     "UInt16",
+		Int32Type:      "Int32",
+		UInt32Type:     "UInt32",
+		Int64Type:      "Int64",
+		UInt64Type:     "UInt64",
+		Real32Type:     "Real32",
+		Real64Type:     "Real64",
+		BoolType:       "Bool",
+		BinaryType:     "Binary",
+		GuidType:       "GUID",
+		SizeTType:      "SizeT",
+		FileTimeType:   "FileTime",
+		SysTimeType:    "SysTime",
+		SidType:        "SID",
+		HexInt32Type:   "HexInt32",
+		HexInt64Type:   "HexInt64",
+		EvtHandle:      "EvtHandle",
+		BinXmlType:     "BinXml",
+		EvtXml:         "EvtXml",
+	}
+)
+
+// ValueTypeName returns the name of a BinXML value type
+func ValueTypeName(t ValueType) string {
+	name, ok := valueTypeNames[t&^ArrayType]
+	if !ok {
+		name = fmt.Sprintf("Unknown(0x%02x)", uint8(t&^ArrayType))
+	}
+	if t.IsArray() {
+		return "ArrayOf" + name
+	}
+	return name
+}
+
+// DissectedToken is a BinXML token, or a structure of the record, annotated by
+// the dissector
+type DissectedToken struct {
+	// Offset of the token relative to the chunk
+	Offset int64
+	// Length of the token in bytes, names and strings stored inline included
+	Length int64
+	// Depth is the nesting level of the token (elements, templates, values)
+	Depth int
+	// Type is the name of the token (ex: OpenStartElement)
+	Type string
+	// Value is the decoded value of the token
+	Value string
+	// Err is the reason why the token cannot be decoded
+	Err error
+}
+
+// Dissection is the list of the tokens of a record annotated by the dissector
+type Dissection struct {
+	// ChunkOffset is the absolute offset of the chunk in the file and Offset
+	// the offset of the record relative to the chunk
+	ChunkOffset int64
+	Offset      int64
+	Header      EventHeader
+	Tokens      []*DissectedToken
+	// Err is the first error met by the dissector, the token it belongs to
+	// is where decoding failed
+	Err error
+	// DecodeErr is the error (or panic) of the regular decoder on the record
+	DecodeErr error
+
+	data []byte
+}
+
+// dissector walks the BinXML of a record token by token, its state is local so
+// that dissections do not depend on the global parser settings (ex: Debug)
+type dissector struct {
+	c *Chunk
+	d *Dissection
+}
+
+// template is the state of a template instance being dissected
+type template struct {
+	subs []*DissectedToken
+	ids  []uint16
+}
+
+func (ds *dissector) add(offset, length int64, depth int, typ, value string) *DissectedToken {
+	t := &DissectedToken{Offset: offset, Length: length, Depth: depth, Type: typ, Value: value}
+	ds.d.Tokens = append(ds.d.Tokens, t)
+	return t
+}
+
+// fail marks the token where decoding failed
+func (ds *dissector) fail(t *DissectedToken, err error) error {
+	t.Err = err
+	if ds.d.Err == nil {
+		ds.d.Err = err
+	}
+	return err
+}
+
+// bytes returns n bytes of the chunk at offset
+func (ds *dissector) bytes(offset, n int64) ([]byte, error) {
+	if offset < 0 || n < 0 || offset+n > int64(len(ds.c.Data)) {
+		return nil, fmt.Errorf("Reading %d bytes at 0x%08x out of chunk", n, offset)
+	}
+	return ds.c.Data[offset : offset+n], nil
+}
+
+func (ds *dissector) uint8(offset int64) (uint8, error) {
+	b, err := ds.bytes(offset, 1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (ds *dissector) uint16(offset int64) (uint16, error) {
+	b, err := ds.bytes(offset, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (ds *dissector) uint32(offset int64) (uint32, error) {
+	b, err := ds.bytes(offset, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// name returns the name at offset and its size
+func (ds *dissector) name(offset int64) (string, int64, error) {
+	if _, err := ds.bytes(offset, 8); err != nil {
+		return "", 0, err
+	}
+	n := Name{}
+	if err := n.Parse(bytes.NewReader(ds.c.Data[offset:])); err != nil {
+		return "", 0, fmt.Errorf("Bad name at 0x%08x: %s", offset, err)
+	}
+	return n.String(), 8 + (int64(n.Size)+1)*2, nil
+}
+
+// text returns the UnicodeTextString at offset and its size
+func (ds *dissector) text(offset int64) (string, int64, error) {
+	if _, err := ds.bytes(offset, 2); err != nil {
+		return "", 0, err
+	}
+	uts := UnicodeTextString{}
+	if err := uts.Parse(bytes.NewReader(ds.c.Data[offset:])); err != nil {
+		return "", 0, fmt.Errorf("Bad string at 0x%08x: %s", offset, err)
+	}
+	return uts.String.ToString(), int64(uts.GetSize()), nil
+}
+
+// nameRef returns the name referenced at offset, the name is stored inline
+// when it directly follows the reference
+func (ds *dissector) nameRef(offset int64) (name string, inline int64, err error) {
+	ref, err := ds.uint32(offset)
+	if err != nil {
+		return
+	}
+	name, size, err := ds.name(int64(ref))
+	if err == nil && int64(ref) == offset+4 {
+		inline = size
+	}
+	return
+}
+
+// walk dissects the tokens from offset until EOF or end, it returns the offset
+// following the last token
+// @ti : true if in a template definition or a BinXML value (elements have a
+// dependency ID)
+// @tpl : template whose substitutions are walked, nil if none
+func (ds *dissector) walk(offset, end int64, depth int, ti bool, tpl *template) (int64, error) {
+	if depth > maxDissectDepth {
+		t := ds.add(offset, 0, depth, "Nesting", "")
+		return offset, ds.fail(t, fmt.Errorf("Too deep nesting"))
+	}
+	elements := make([]string, 0)
+	level := 0
+	for offset < end {
+		token, err := ds.uint8(offset)
+		if err != nil {
+			return offset, ds.fail(ds.add(offset, 0, depth+level, "Token", ""), err)
+		}
+		indent := depth + level
+		switch token {
+		case TokenEOF:
+			ds.add(offset, 1, indent, "EOF", "")
+			return offset + 1, nil
+
+		case FragmentHeaderToken:
+			b, err := ds.bytes(offset, 4)
+			t := ds.add(offset, 4, indent, "FragmentHeader", "")
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			t.Value = fmt.Sprintf("version %d.%d flags 0x%02x", b[1], b[2], b[3])
+			offset += 4
+
+		case TokenOpenStartElementTag1, TokenOpenStartElementTag2:
+			t := ds.add(offset, 0, indent, "OpenStartElement", "")
+			cur := offset + 1
+			depID := ""
+			if ti {
+				id, err := ds.uint16(cur)
+				if err != nil {
+					return offset, ds.fail(t, err)
+				}
+				depID = fmt.Sprintf(" depid %d", int16(id))
+				cur += 2
+			}
+			size, err := ds.uint32(cur)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			cur += 4
+			name, inline, err := ds.nameRef(cur)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			cur += 4 + inline
+			attrs := ""
+			if token == TokenOpenStartElementTag2 {
+				n, err := ds.uint32(cur)
+				if err != nil {
+					return offset, ds.fail(t, err)
+				}
+				attrs = fmt.Sprintf(" attributes %d bytes", n)
+				cur += 4
+			}
+			t.Length = cur - offset
+			t.Value = fmt.Sprintf("<%s size %d%s%s", name, size, depID, attrs)
+			elements = append(elements, name)
+			level++
+			offset = cur
+
+		case TokenCloseStartElementTag:
+			ds.add(offset, 1, indent-1, "CloseStartElement", ">")
+			offset++
+
+		case TokenCloseEmptyElementTag, TokenEndElementTag:
+			typ, value := "CloseEmptyElement", "/>"
+			if token == TokenEndElementTag {
+				typ, value = "EndElement", "</>"
+			}
+			t := ds.add(offset, 1, indent-1, typ, value)
+			if len(elements) == 0 {
+				return offset, ds.fail(t, fmt.Errorf("No element to close"))
+			}
+			if token == TokenEndElementTag {
+				t.Value = fmt.Sprintf("</%s>", elements[len(elements)-1])
+			}
+			elements = elements[:len(elements)-1]
+			level--
+			offset++
+
+		case TokenValue1, TokenValue2:
+			t := ds.add(offset, 0, indent, "Value", "")
+			vt, err := ds.uint8(offset + 1)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			if vt != StringType {
+				return offset, ds.fail(t, fmt.Errorf("Bad value type %s, must be String", ValueTypeName(ValueType(vt))))
+			}
+			s, size, err := ds.text(offset + 2)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			t.Length = 2 + size
+			t.Value = fmt.Sprintf("%q", s)
+			offset += t.Length
+
+		case TokenAttribute1, TokenAttribute2:
+			t := ds.add(offset, 0, indent, "Attribute", "")
+			name, inline, err := ds.nameRef(offset + 1)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			t.Length = 5 + inline
+			t.Value = name
+			offset += t.Length
+
+		case TokenCDataSection1, TokenCDataSection2, TokenPIData:
+			typ := "CDataSection"
+			if token == TokenPIData {
+				typ = "PIData"
+			}
+			t := ds.add(offset, 0, indent, typ, "")
+			s, size, err := ds.text(offset + 1)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			t.Length = 1 + size
+			t.Value = fmt.Sprintf("%q", s)
+			// the token is walked but the parser does not know it
+			ds.fail(t, ErrUnknownToken{token})
+			offset += t.Length
+
+		case TokenCharRef1, TokenCharRef2:
+			t := ds.add(offset, 3, indent, "CharRef", "")
+			v, err := ds.uint16(offset + 1)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			t.Value = fmt.Sprintf("&#%d;", v)
+			offset += 3
+
+		case TokenEntityRef1, TokenEntityRef2, TokenPITarget:
+			typ, format := "EntityRef", "&%s;"
+			if token == TokenPITarget {
+				typ, format = "PITarget", "<?%s"
+			}
+			t := ds.add(offset, 0, indent, typ, "")
+			name, inline, err := ds.nameRef(offset + 1)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			t.Length = 5 + inline
+			t.Value = fmt.Sprintf(format, name)
+			if token == TokenPITarget {
+				ds.fail(t, ErrUnknownToken{token})
+			}
+			offset += t.Length
+
+		case TokenNormalSubstitution, TokenOptionalSubstitution:
+			typ := "NormalSubstitution"
+			if token == TokenOptionalSubstitution {
+				typ = "OptionalSubstitution"
+			}
+			t := ds.add(offset, 4, indent, typ, "")
+			id, err := ds.uint16(offset + 1)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			vt, err := ds.uint8(offset + 3)
+			if err != nil {
+				return offset, ds.fail(t, err)
+			}
+			t.Value = fmt.Sprintf("#%d %s", id, ValueTypeName(ValueType(vt)))
+			if tpl == nil {
+				ds.fail(t, fmt.Errorf("Substitution out of a template"))
+			} else {
+				tpl.subs = append(tpl.subs, t)
+				tpl.ids = append(tpl.ids, id)
+			}
+			offset += 4
+
+		case TokenTemplateInstance:
+			if offset, err = ds.templateInstance(offset, depth+level); err != nil {
+				return offset, err
+			}
+
+		default:
+			t := ds.add(offset, 1, indent, "Unknown", fmt.Sprintf("0x%02x", token))
+			return offset, ds.fail(t, ErrUnknownToken{token})
+		}
+	}
+	return offset, nil
+}
+
+// templateInstance dissects a template instance, its definition (inline or
+// looked up elsewhere in the chunk) and its substitution values
+func (ds *dissector) templateInstance(offset int64, depth int) (int64, error) {
+	t := ds.add(offset, 10, depth, "TemplateInstance", "")
+	id, err := ds.uint32(offset + 2)
+	if err != nil {
+		return offset, ds.fail(t, err)
+	}
+	defOffset, err := ds.uint32(offset + 6)
+	if err != nil {
+		return offset, ds.fail(t, err)
+	}
+	t.Value = fmt.Sprintf("template id 0x%08x definition @ 0x%08x", id, defOffset)
+	offset += 10
+
+	inline := int64(defOffset) == offset
+	if !inline {
+		lookup := "not in chunk template table, parsed by the decoder"
+		if _, ok := ds.c.TemplateTable[int32(defOffset)]; ok {
+			lookup = "found in chunk template table"
+		}
+		ds.add(offset, 0, depth+1, "TemplateLookup", fmt.Sprintf("0x%08x %s", defOffset, lookup))
+	}
+
+	// definition: next definition offset, GUID, size and BinXML
+	def := int64(defOffset)
+	dt := ds.add(def, 24, depth+1, "TemplateDefinition", "")
+	b, err := ds.bytes(def, 24)
+	if err != nil {
+		return offset, ds.fail(dt, err)
+	}
+	size := int64(binary.LittleEndian.Uint32(b[20:24]))
+	guid := ValueGUID{}
+	guid.Parse(bytes.NewReader(b[4:20]))
+	dt.Value = fmt.Sprintf("GUID %s size %d next @ 0x%08x", guid.String(), size, binary.LittleEndian.Uint32(b[0:4]))
+	tpl := &template{}
+	if _, err := ds.walk(def+24, def+24+size, depth+2, true, tpl); err != nil {
+		return offset, err
+	}
+	if inline {
+		offset = def + 24 + size
+	}
+
+	// instance data: descriptors then values
+	n, err := ds.uint32(offset)
+	dat := ds.add(offset, 4, depth+1, "TemplateInstanceData", "")
+	if err != nil {
+		return offset, ds.fail(dat, err)
+	}
+	if n > MaxSliceSize {
+		return offset, ds.fail(dat, fmt.Errorf("Too many values in TemplateInstanceData: %d", n))
+	}
+	dat.Value = fmt.Sprintf("%d values", n)
+	offset += 4
+	descs := make([]ValueDescriptor, n)
+	dt = ds.add(offset, int64(n)*4, depth+1, "ValueDescriptors", "")
+	if n > 0 {
+		if _, err := ds.bytes(offset, int64(n)*4); err != nil {
+			return offset, ds.fail(dt, err)
+		}
+		encoding.UnmarshaInitSlice(bytes.NewReader(ds.c.Data[offset:]), &descs, Endianness)
+		for i, vd := range descs {
+			if i > 0 {
+				dt.Value += ", "
+			}
+			dt.Value += fmt.Sprintf("%s(%d)", ValueTypeName(vd.ValType), vd.Size)
+		}
+	}
+	offset += int64(n) * 4
+
+	values := make([]string, n)
+	for i, vd := range descs {
+		vt := ds.add(offset, int64(vd.Size), depth+2, "SubstitutionValue", "")
+		vt.Value = fmt.Sprintf("#%d %s", i, ValueTypeName(vd.ValType))
+		if _, err := ds.bytes(offset, int64(vd.Size)); err != nil {
+			return offset, ds.fail(vt, err)
+		}
+		if vd.ValType.IsType(BinXmlType) {
+			values[i] = "BinXml"
+			if _, err := ds.walk(offset, offset+int64(vd.Size), depth+3, true, nil); err != nil {
+				return offset, err
+			}
+		} else {
+			v, err := dissectValue(vd, ds.c.Data[offset:offset+int64(vd.Size)])
+			if err != nil {
+				ds.fail(vt, err)
+			}
+			values[i] = v
+			vt.Value += " = " + v
+		}
+		offset += int64(vd.Size)
+	}
+
+	// substitutions are resolved once the values are known
+	for i, s := range tpl.subs {
+		id := tpl.ids[i]
+		if int(id) >= len(values) {
+			ds.fail(s, fmt.Errorf("Substitution index %d out of range (%d values)", id, len(values)))
+			continue
+		}
+		s.Value += " -> " + values[id]
+	}
+	return offset, nil
+}
+
+// dissectValue decodes a substitution value, the value parsers may panic on
+// corrupted data
+func dissectValue(vd ValueDescriptor, data []byte) (s string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Value parser panic: %v", r)
+		}
+	}()
+	elt, err := ParseValueReader(vd, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	switch v := elt.(type) {
+	case *UnkVal:
+		return "", fmt.Errorf("Unknown value type 0x%02x", uint8(vd.ValType))
+	case *ValueNull:
+		return "NULL", nil
+	case Value:
+		if vd.ValType.IsType(StringType) || vd.ValType.IsType(AnsiStringType) {
+			return fmt.Sprintf("%q", v.Repr()), nil
+		}
+		return fmt.Sprintf("%v", v.Repr()), nil
+	}
+	return fmt.Sprintf("%T", elt), nil
+}
+
+// decode runs the regular decoder on the record
+func (d *Dissection) decode(c *Chunk) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	e := Event{Offset: d.Offset, Header: d.Header}
+	_, err = e.GoEvtxMap(c)
+	return
+}
+
+// Dissect dissects the record located at offset (relative to the chunk) of a
+// chunk. The dissection goes on after errors which do not prevent walking the
+// BinXML (ex: substitution out of range) but stops at the first token it
+// cannot walk.
+// @c : chunk containing the record, its template table is used for the
+// lookups
+// @offset : offset of the record relative to the chunk
+// return *Dissection
+func Dissect(c *Chunk, offset int64) *Dissection {
+	d := &Dissection{ChunkOffset: c.Offset, Offset: offset, data: c.Data}
+	ds := &dissector{c, d}
+	ht := ds.add(offset, EventHeaderSize, 0, "RecordHeader", "")
+	b, err := ds.bytes(offset, EventHeaderSize)
+	if err != nil {
+		ds.fail(ht, err)
+		return d
+	}
+	encoding.Unmarshal(bytes.NewReader(b), &d.Header, Endianness)
+	ht.Value = fmt.Sprintf("ID %d written %s size %d", d.Header.ID, d.Header.Timestamp.String(), d.Header.Size)
+	if err := d.Header.Validate(); err != nil {
+		ds.fail(ht, err)
+		return d
+	}
+
+	// the record ends with a copy of its size
+	end := offset + int64(d.Header.Size) - 4
+	if _, err := ds.walk(offset+EventHeaderSize, end, 0, false, nil); err != nil {
+		return d
+	}
+	st := ds.add(end, 4, 0, "SizeCopy", "")
+	if size, err := ds.uint32(end); err != nil {
+		ds.fail(st, err)
+	} else if st.Value = fmt.Sprintf("%d", size); int32(size) != d.Header.Size {
+		st.Value += " (differs from header)"
+	}
+	d.DecodeErr = d.decode(c)
+	return d
+}
+
+// hexdump writes the bytes around offset, the byte at offset is marked
+func (d *Dissection) hexdump(w io.Writer, offset int64) {
+	start := offset &^ 0xf
+	if start >= 0x20 {
+		start -= 0x20
+	} else {
+		start = 0
+	}
+	for line := start; line < offset+0x30 && line < int64(len(d.data)); line += 16 {
+		out := fmt.Sprintf("  0x%08x ", d.ChunkOffset+line)
+		for i := line; i < line+16 && i < int64(len(d.data)); i++ {
+			if i == offset {
+				out += fmt.Sprintf(">%02x<", d.data[i])
+			} else {
+				out += fmt.Sprintf(" %02x ", d.data[i])
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(out, " "))
+	}
+}
+
+// Print writes the annotated tokens of the dissection, tokens which failed to
+// decode are marked with !! followed by the error and the bytes around them
+func (d *Dissection) Print(w io.Writer) error {
+	fmt.Fprintf(w, "Record %d @ 0x%08x (chunk @ 0x%08x + 0x%04x)\n", d.Header.ID, d.ChunkOffset+d.Offset, d.ChunkOffset, d.Offset)
+	if d.Err != nil {
+		fmt.Fprintf(w, "Dissector: %s\n", d.Err)
+	}
+	if d.DecodeErr != nil {
+		fmt.Fprintf(w, "Decoder: %s\n", d.DecodeErr)
+	} else if d.Err == nil {
+		fmt.Fprintln(w, "Decoder: OK")
+	}
+	fmt.Fprintln(w)
+
+	// tokens are indented after their depth
+	types := make([]string, len(d.Tokens))
+	width := len("TOKEN")
+	for i, t := range d.Tokens {
+		types[i] = strings.Repeat("  ", t.Depth) + t.Type
+		if len(types[i]) > width {
+			width = len(types[i])
+		}
+	}
+	fmt.Fprintf(w, "  %-10s  %-6s  %6s  %-*s  %s\n", "FILE", "CHUNK", "LENGTH", width, "TOKEN", "VALUE")
+	for i, t := range d.Tokens {
+		mark := "  "
+		if t.Err != nil {
+			mark = "!!"
+		}
+		line := fmt.Sprintf("%s0x%08x  0x%04x  %6d  %-*s  %s", mark, d.ChunkOffset+t.Offset, t.Offset, t.Length, width, types[i], t.Value)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+		if t.Err != nil {
+			fmt.Fprintf(w, "!! %s\n", t.Err)
+			d.hexdump(w, t.Offset)
+		}
+	}
+	return nil
+}
+
+// fetchChunkData fetches a chunk without validating it, so that broken chunks
+// can be dissected, the template table is parsed if possible
+func (ef *File) fetchChunkData(offset int64) (c Chunk, err error) {
+	ef.Lock()
+	defer ef.Unlock()
+	c = NewChunk()
+	c.Offset = offset
+	c.Index = ef.chunkIndex(offset)
+	c.Data = make([]byte, ChunkSize)
+	GoToSeeker(ef.file, offset)
+	if _, err = io.ReadFull(ef.file, c.Data); err != nil {
+		return
+	}
+	if err = encoding.Unmarshal(bytes.NewReader(c.Data), &c.Header, Endianness); err != nil {
+		return
+	}
+	func() {
+		// template table is only used to show the lookups
+		defer func() { recover() }()
+		reader := bytes.NewReader(c.Data)
+		GoToSeeker(reader, ChunkHeaderSize+sizeStringBucket*4)
+		c.ParseTemplateTable(reader)
+	}()
+	return
+}
+
+// DissectAt dissects the record located at offset in the file
+// @offset : absolute offset of the record
+// return (*Dissection, error)
+func (ef *File) DissectAt(offset int64) (*Dissection, error) {
+	if offset < int64(ef.Header.ChunkDataOffset) {
+		return nil, fmt.Errorf("Offset 0x%08x before the first chunk", offset)
+	}
+	chunkOffset := int64(ef.Header.ChunkDataOffset) + ef.chunkIndex(offset)*ChunkSize
+	c, err := ef.fetchChunkData(chunkOffset)
+	if err != nil {
+		return nil, err
+	}
+	return Dissect(&c, offset-chunkOffset), nil
+}
+
+// DissectID dissects the record with the given record ID
+// @id : ID of the record (EventRecordID)
+// return (*Dissection, error)
+func (ef *File) DissectID(id int64) (*Dissection, error) {
+	for i := uint16(0); i < ef.Header.ChunkCount; i++ {
+		c, err := ef.fetchChunkData(int64(ef.Header.ChunkDataOffset) + int64(ChunkSize)*int64(i))
+		if err != nil {
+			return nil, err
+		}
+		if c.Header.Validate() != nil || id < c.Header.FirstEventRecID || id > c.Header.LastEventRecID {
+			continue
+		}
+		// records follow the string and template tables
+		for offset := int64(ChunkHeaderSize + (sizeStringBucket+sizeTemplateBucket)*4); offset <= int64(c.Header.OffsetLastRec); {
+			h := EventHeader{}
+			if offset+EventHeaderSize > ChunkSize {
+				break
+			}
+			encoding.Unmarshal(bytes.NewReader(c.Data[offset:]), &h, Endianness)
+			if err := h.Validate(); err != nil {
+				return nil, fmt.Errorf("Bad record header in chunk @ 0x%08x + 0x%04x: %s", c.Offset, offset, err)
+			}
+			if h.ID == id {
+				return Dissect(&c, offset), nil
+			}
+			offset += int64(h.Size)
+		}
+	}
+	return nil, fmt.Errorf("Record %d not found", id)
+}
@@ -76,6 +76,9 @@ func (e Event) GoEvtxMap(c *Chunk) (pge *GoEvtxMap, err error) {
 		// Way to raise panic
 		_ = element.(*Fragment)
 	}
+	if ti, ok := fragment.BinXMLElement.(*TemplateInstance); ok {
+		ti.typed = c.TypedValues
+	}
 	return fragment.GoEvtxMap(), err
 }
 
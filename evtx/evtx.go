@@ -106,6 +106,7 @@ type File struct {
 	Header          FileHeader
 	file            io.ReadSeeker
 	monitorExisting bool
+	typedValues     bool
 }
 
 // New EvtxFile structure initialized from an open buffer
@@ -152,6 +153,13 @@ func (ef *File) SetMonitorExisting(value bool) {
 	ef.monitorExisting = value
 }
 
+// SetTypedValues changes the way values are converted into GoEvtxMap, if true
+// numeric, boolean and binary values keep their Go type (int32, uint64, bool,
+// []byte ...) instead of being converted to strings
+func (ef *File) SetTypedValues(value bool) {
+	ef.typedValues = value
+}
+
 // ParseFileHeader parses a the file header of the file structure and modifies
 // the Header of the current structure
 func (ef *File) ParseFileHeader() {
@@ -192,6 +200,11 @@ func (fh FileHeader) String() string {
 
 }
 
+// chunkIndex returns the index of the chunk at offset
+func (ef *File) chunkIndex(offset int64) int64 {
+	return (offset - int64(ef.Header.ChunkDataOffset)) / ChunkSize
+}
+
 // FetchRawChunk fetches a raw Chunk (without parsing String and Template tables)
 // @offset : offset in the current file where to find the Chunk
 // return Chunk : Chunk (raw) parsed
@@ -201,6 +214,8 @@ func (ef *File) FetchRawChunk(offset int64) (Chunk, error) {
 	c := NewChunk()
 	GoToSeeker(ef.file, offset)
 	c.Offset = offset
+	c.Index = ef.chunkIndex(offset)
+	c.TypedValues = ef.typedValues
 	c.Data = make([]byte, ChunkHeaderSize)
 	if _, err := ef.file.Read(c.Data); err != nil {
 		return c, err
@@ -219,6 +234,8 @@ func (ef *File) FetchChunk(offset int64) (Chunk, error) {
 	c := NewChunk()
 	GoToSeeker(ef.file, offset)
 	c.Offset = offset
+	c.Index = ef.chunkIndex(offset)
+	c.TypedValues = ef.typedValues
 	c.Data = make([]byte, ChunkSize)
 	if _, err := ef.file.Read(c.Data); err != nil {
 		return c, err
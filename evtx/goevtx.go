@@ -187,10 +187,21 @@ func (pg *GoEvtxMap) GetBoolStrict(path *GoEvtxPath) bool {
 // @path : path to search for
 // return int64, error
 func (pg *GoEvtxMap) GetInt(path *GoEvtxPath) (int64, error) {
-	s, err := pg.GetString(path)
+	pE, err := pg.Get(path)
 	if err != nil {
 		return 0, &ErrEvtxEltNotFound{*path}
 	}
+	// typed values (see File.SetTypedValues)
+	switch v := (*pE).(type) {
+	case int8, int16, int32, int64, uint8, uint16, uint32:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(int64(0))).Int(), nil
+	case uint64:
+		return int64(v), nil
+	}
+	s, err := pg.GetString(path)
+	if err != nil {
+		return 0, err
+	}
 	i, err := strconv.ParseInt(s, 0, 64)
 	if err != nil {
 		return 0, err
@@ -210,10 +221,21 @@ func (pg *GoEvtxMap) GetIntStrict(path *GoEvtxPath) int64 {
 // @path : path to search for
 // return uint64
 func (pg *GoEvtxMap) GetUint(path *GoEvtxPath) (uint64, error) {
-	s, err := pg.GetString(path)
+	pE, err := pg.Get(path)
 	if err != nil {
 		return 0, &ErrEvtxEltNotFound{*path}
 	}
+	// typed values (see File.SetTypedValues)
+	switch v := (*pE).(type) {
+	case uint8, uint16, uint32, uint64:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(uint64(0))).Uint(), nil
+	case int8, int16, int32, int64:
+		return uint64(reflect.ValueOf(v).Int()), nil
+	}
+	s, err := pg.GetString(path)
+	if err != nil {
+		return 0, err
+	}
 	u, err := strconv.ParseUint(s, 0, 64)
 	if err != nil {
 		return 0, err
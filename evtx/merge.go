@@ -0,0 +1,168 @@
+package evtx
+
+import (
+	"container/heap"
+	"time"
+)
+
+/////////////////////////////// Merge cursors //////////////////////////////////
+
+// mergeCursor holds the next record to be emitted out of a given record stream
+type mergeCursor struct {
+	index    int
+	records  chan *Record
+	current  *Record
+	time     time.Time
+	recordID int64
+}
+
+// next fetches the next record out of the stream, it returns false when the
+// stream is exhausted
+func (mc *mergeCursor) next() bool {
+	for r := range mc.records {
+		if r == nil || r.Event == nil {
+			continue
+		}
+		mc.current = r
+		// events without time or record id are sorted first
+		mc.time, _ = r.Event.GetTime(&SystemTimePath)
+		mc.recordID, _ = r.Event.GetInt(&EventRecordIDPath)
+		return true
+	}
+	mc.current = nil
+	return false
+}
+
+// less orders cursors by time, then by EventRecordID and finally by input index
+// so that the merge is deterministic
+func (mc *mergeCursor) less(other *mergeCursor) bool {
+	if !mc.time.Equal(other.time) {
+		return mc.time.Before(other.time)
+	}
+	if mc.recordID != other.recordID {
+		return mc.recordID < other.recordID
+	}
+	return mc.index < other.index
+}
+
+// mergeHeap implements heap.Interface
+type mergeHeap []*mergeCursor
+
+func (mh mergeHeap) Len() int {
+	return len(mh)
+}
+
+func (mh mergeHeap) Less(i, j int) bool {
+	return mh[i].less(mh[j])
+}
+
+func (mh mergeHeap) Swap(i, j int) {
+	mh[i], mh[j] = mh[j], mh[i]
+}
+
+func (mh *mergeHeap) Push(x interface{}) {
+	*mh = append(*mh, x.(*mergeCursor))
+}
+
+func (mh *mergeHeap) Pop() interface{} {
+	old := *mh
+	n := len(old)
+	mc := old[n-1]
+	old[n-1] = nil
+	*mh = old[:n-1]
+	return mc
+}
+
+// MergedRecord is a record out of a merge along with the index of the input
+// it comes from
+type MergedRecord struct {
+	*Record
+	Input int
+}
+
+// merge does a k-way merge of several record streams
+func merge(streams []chan *Record) (cmr chan *MergedRecord) {
+	cmr = make(chan *MergedRecord, 42)
+	go func() {
+		defer close(cmr)
+		mh := make(mergeHeap, 0, len(streams))
+		for i, s := range streams {
+			mc := &mergeCursor{index: i, records: s}
+			if mc.next() {
+				mh = append(mh, mc)
+			}
+		}
+		heap.Init(&mh)
+		for mh.Len() > 0 {
+			mc := mh[0]
+			cmr <- &MergedRecord{mc.current, mc.index}
+			if mc.next() {
+				heap.Fix(&mh, 0)
+			} else {
+				heap.Pop(&mh)
+			}
+		}
+	}()
+	return
+}
+
+// eventRecords wraps the events of a stream into records
+func eventRecords(events chan *GoEvtxMap) (cr chan *Record) {
+	cr = make(chan *Record, 42)
+	go func() {
+		defer close(cr)
+		for e := range events {
+			cr <- &Record{Chunk: -1, Event: e}
+		}
+	}()
+	return
+}
+
+// MergeStreams does a k-way merge of several event streams into a single one
+// ordered by TimeCreated/SystemTime with EventRecordID used as tie-breaker.
+// Every input stream is expected to be ordered by itself. Only one event per
+// stream is kept in memory at any time.
+// @streams : event streams to merge
+// return (chan *GoEvtxMap)
+func MergeStreams(streams ...chan *GoEvtxMap) (cgem chan *GoEvtxMap) {
+	records := make([]chan *Record, 0, len(streams))
+	for _, s := range streams {
+		records = append(records, eventRecords(s))
+	}
+	cgem = make(chan *GoEvtxMap, 42)
+	go func() {
+		defer close(cgem)
+		for mr := range merge(records) {
+			cgem <- mr.Event
+		}
+	}()
+	return
+}
+
+// MergeEvents returns a chan pointers to all the GoEvtxMap found in several
+// files, merged into a single stream ordered by TimeCreated/SystemTime. Events
+// having the same creation time are ordered by EventRecordID. Files are read
+// through FastEvents so the memory used is bounded by the number of files.
+// @files : files to merge events from
+// return (chan *GoEvtxMap)
+func MergeEvents(files ...*File) (cgem chan *GoEvtxMap) {
+	streams := make([]chan *GoEvtxMap, 0, len(files))
+	for _, ef := range files {
+		streams = append(streams, ef.FastEvents())
+	}
+	return MergeStreams(streams...)
+}
+
+// MergeRecords is the same as MergeEvents but returns the records of the
+// events, along with the index of the file they come from, so that merged
+// events can be traced back to their file
+// @raw : copies the raw bytes of the records if true
+// @files : files to merge records from
+// return (chan *MergedRecord)
+func MergeRecords(raw bool, files ...*File) chan *MergedRecord {
+	streams := make([]chan *Record, 0, len(files))
+	for _, ef := range files {
+		streams = append(streams, ef.Records(raw))
+	}
+	return merge(streams)
+}
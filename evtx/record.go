@@ -0,0 +1,139 @@
+package evtx
+
+import (
+	"io"
+	"time"
+)
+
+// Record is an event record along with the fields of its header and its
+// location in the file, so that the evidence can be traced back to the exact
+// bytes it was decoded from
+type Record struct {
+	// ID, Timestamp (time the record was written) and Size (including the
+	// header) come from the record header
+	ID        int64
+	Timestamp FileTime
+	Size      int32
+	// Offset and ChunkOffset are absolute offsets of the record and of its
+	// chunk in the file
+	Offset      int64
+	ChunkOffset int64
+	// Chunk is the index of the chunk in the file, -1 if unknown (ex: carved
+	// chunks)
+	Chunk int64
+	// Raw is a copy of the bytes of the record, only set when requested
+	Raw []byte
+	// Event is the decoded event
+	Event *GoEvtxMap
+}
+
+// record builds the Record of the event located at offset in the chunk
+func (c *Chunk) record(offset int64, raw bool) (*Record, error) {
+	event := c.ParseEvent(offset)
+	gem, err := event.GoEvtxMap(c)
+	if err != nil {
+		return nil, err
+	}
+	if gem == nil {
+		// failed to parse in carving mode
+		return nil, ErrInvalidEvent
+	}
+	r := &Record{
+		ID:          event.Header.ID,
+		Timestamp:   event.Header.Timestamp,
+		Size:        event.Header.Size,
+		Offset:      c.Offset + offset,
+		ChunkOffset: c.Offset,
+		Chunk:       c.Index,
+		Event:       gem,
+	}
+	if raw {
+		end := offset + int64(event.Header.Size)
+		if end > int64(len(c.Data)) {
+			end = int64(len(c.Data))
+		}
+		r.Raw = make([]byte, end-offset)
+		copy(r.Raw, c.Data[offset:end])
+	}
+	return r, nil
+}
+
+// Records returns a channel of the records of the chunk
+// @raw : copies the raw bytes of the records if true
+// return (chan *Record)
+func (c *Chunk) Records(raw bool) (cr chan *Record) {
+	cr = make(chan *Record, len(c.EventOffsets))
+	go func() {
+		defer close(cr)
+		for _, eo := range c.EventOffsets {
+			if r, err := c.record(int64(eo), raw); err == nil {
+				cr <- r
+			}
+		}
+	}()
+	return
+}
+
+// records returns the records of the chunks, chunks are parsed in parallel
+// but the records are returned in the order of the chunks
+// @fetch : fetches (parses) the chunks first if true
+func (ef *File) records(cc chan Chunk, fetch bool, raw bool) (cr chan *Record) {
+	cr = make(chan *Record, 42)
+	go func() {
+		defer close(cr)
+		chanQueue := make(chan (chan *Record), MaxJobs)
+		go func() {
+			defer close(chanQueue)
+			for pc := range cc {
+				cpc := pc
+				if fetch {
+					var err error
+					cpc, err = ef.FetchChunk(pc.Offset)
+					switch {
+					case err != nil && err != io.EOF:
+						panic(err)
+					case err != nil:
+						continue
+					}
+				}
+				chanQueue <- cpc.Records(raw)
+			}
+		}()
+		for rc := range chanQueue {
+			for r := range rc {
+				cr <- r
+			}
+		}
+	}()
+	return
+}
+
+// Records returns a chan of the records found in the current file, ordered
+// as FastEvents
+// @raw : copies the raw bytes of the records if true
+// return (chan *Record)
+func (ef *File) Records(raw bool) chan *Record {
+	return ef.records(ef.Chunks(), true, raw)
+}
+
+// UnorderedRecords returns a chan of the records found in the current file,
+// same as UnorderedEvents the order by time is not guaranteed
+// @raw : copies the raw bytes of the records if true
+// return (chan *Record)
+func (ef *File) UnorderedRecords(raw bool) chan *Record {
+	return ef.records(ef.UnorderedChunks(), true, raw)
+}
+
+// MonitorRecords returns a chan of the records found in the File under
+// monitoring (see MonitorEvents)
+// @stop: a channel used to stop the monitoring if needed
+// @raw : copies the raw bytes of the records if true
+// return (chan *Record)
+func (ef *File) MonitorRecords(stop chan bool, raw bool, sleep ...time.Duration) chan *Record {
+	sleepTime := DefaultMonitorSleep
+	if len(sleep) > 0 {
+		sleepTime = sleep[0]
+	}
+	// monitored chunks are already parsed
+	return ef.records(ef.monitorChunks(stop, sleepTime), false, raw)
+}
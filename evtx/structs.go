@@ -502,10 +502,12 @@ func (ti *TemplateInstance) ElementToGoEvtx(elt Element) GoEvtxElement {
 		}
 	case *Fragment:
 		temp := elt.(*Fragment).BinXMLElement.(*TemplateInstance)
+		temp.typed = ti.typed
 		root := temp.Root()
 		return temp.NodeToGoEvtx(&root)
 	case *TemplateInstance:
 		temp := elt.(*TemplateInstance)
+		temp.typed = ti.typed
 		root := temp.Root()
 		return temp.NodeToGoEvtx(&root)
 	case Value:
@@ -513,6 +515,9 @@ func (ti *TemplateInstance) ElementToGoEvtx(elt Element) GoEvtxElement {
 			// We return nil if is ValueNull
 			return nil
 		}
+		if ti.typed {
+			return typedValue(elt.(Value))
+		}
 		return elt.(Value).Repr()
 	case *BinXMLEntityReference:
 		ers := elt.(*BinXMLEntityReference).String()
@@ -634,6 +639,8 @@ type TemplateInstance struct {
 	Token      int8
 	Definition TemplateDefinition
 	Data       TemplateInstanceData
+	// keeps the Go type of the values (see File.SetTypedValues)
+	typed bool
 }
 
 func (ti *TemplateInstance) DataOffset(reader io.ReadSeeker) (offset int32, err error) {
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// binxml builds BinXML, names are stored inline
+type binxml struct {
+	bytes.Buffer
+	base int64 // offset of the buffer in the chunk
+}
+
+func (b *binxml) put(values ...interface{}) {
+	for _, v := range values {
+		binary.Write(b, binary.LittleEndian, v)
+	}
+}
+
+func (b *binxml) name(s string) {
+	u := utf16.Encode([]rune(s))
+	b.put(uint32(b.base+int64(b.Len())+4), uint32(0), uint16(0), uint16(len(u)), u, uint16(0))
+}
+
+func (b *binxml) element(s string) {
+	// token, dependency ID, size
+	b.put(uint8(evtx.TokenOpenStartElementTag1), int16(-1), uint32(0))
+	b.name(s)
+}
+
+// chunk builds a chunk holding a single record with ID 42 whose template
+// defines <Event><EventID>%sub</EventID></Event>
+func chunk(sub uint16) *evtx.Chunk {
+	const offset = 0x200
+	b := &binxml{base: offset + evtx.EventHeaderSize}
+	b.put(uint8(evtx.FragmentHeaderToken), uint8(1), uint8(1), uint8(0))
+	// template instance with an inline definition
+	b.put(uint8(evtx.TokenTemplateInstance), uint8(1), uint32(1), uint32(b.base+int64(b.Len())+10))
+	def := &binxml{base: b.base + int64(b.Len()) + 24}
+	def.put(uint8(evtx.FragmentHeaderToken), uint8(1), uint8(1), uint8(0))
+	def.element("Event")
+	def.put(uint8(evtx.TokenCloseStartElementTag))
+	def.element("EventID")
+	def.put(uint8(evtx.TokenCloseStartElementTag))
+	def.put(uint8(evtx.TokenOptionalSubstitution), sub, uint8(evtx.UInt16Type))
+	def.put(uint8(evtx.TokenEndElementTag), uint8(evtx.TokenEndElementTag), uint8(evtx.TokenEOF))
+	b.put(uint32(0), [16]byte{}, uint32(def.Len()))
+	b.Write(def.Bytes())
+	// instance data: a single UInt16 value
+	b.put(uint32(1), uint16(2), uint8(evtx.UInt16Type), uint8(0), uint16(4624))
+	b.put(uint8(evtx.TokenEOF))
+
+	size := uint32(evtx.EventHeaderSize + b.Len() + 4)
+	c := evtx.NewChunk()
+	c.Offset = 0x1000
+	c.Data = make([]byte, evtx.ChunkSize)
+	copy(c.Header.Magic[:], evtx.ChunkMagic)
+	c.Header.FirstEventRecID, c.Header.LastEventRecID = 42, 42
+	c.Header.SizeHeader = evtx.ChunkHeaderSize
+	c.Header.OffsetLastRec = offset
+	header := &binxml{}
+	header.put(c.Header)
+	copy(c.Data, header.Bytes())
+	record := &binxml{}
+	record.put([]byte(evtx.EventMagic), size, int64(42), int64(0))
+	record.Write(b.Bytes())
+	record.put(size)
+	copy(c.Data[offset:], record.Bytes())
+	return &c
+}
+
+func TestDissect(t *testing.T) {
+	d := evtx.Dissect(chunk(0), 0x200)
+	if d.Err != nil || d.DecodeErr != nil {
+		t.Fatalf("Unexpected errors: %v, %v", d.Err, d.DecodeErr)
+	}
+	out := new(bytes.Buffer)
+	d.Print(out)
+	t.Log(out.String())
+	types := make([]string, 0, len(d.Tokens))
+	for _, tok := range d.Tokens {
+		types = append(types, tok.Type)
+		if tok.Type == "OptionalSubstitution" && !strings.HasSuffix(tok.Value, "-> 4624") {
+			t.Errorf("Substitution not resolved: %s", tok.Value)
+		}
+	}
+	expected := "RecordHeader FragmentHeader TemplateInstance TemplateDefinition FragmentHeader " +
+		"OpenStartElement CloseStartElement OpenStartElement CloseStartElement OptionalSubstitution " +
+		"EndElement EndElement EOF TemplateInstanceData ValueDescriptors SubstitutionValue EOF SizeCopy"
+	if strings.Join(types, " ") != expected {
+		t.Errorf("Unexpected tokens: %s", strings.Join(types, " "))
+	}
+
+	// substitution out of range is where decoding fails
+	d = evtx.Dissect(chunk(1), 0x200)
+	if d.Err == nil || d.DecodeErr == nil {
+		t.Fatal("Substitution out of range not reported")
+	}
+	for _, tok := range d.Tokens {
+		if (tok.Err != nil) != (tok.Type == "OptionalSubstitution") {
+			t.Errorf("Error reported on the wrong token: %s %v", tok.Type, tok.Err)
+		}
+	}
+
+	// the dissection stops at unknown tokens
+	c := chunk(0)
+	c.Data[0x200+evtx.EventHeaderSize+4] = 0x42
+	d = evtx.Dissect(c, 0x200)
+	last := d.Tokens[len(d.Tokens)-1]
+	if last.Type != "Unknown" || last.Offset != 0x200+evtx.EventHeaderSize+4 || d.Err == nil {
+		t.Errorf("Unknown token not reported: %+v", last)
+	}
+}
+
+// file builds a file holding a single chunk (see chunk)
+func file(t *testing.T) *evtx.File {
+	fh := evtx.FileHeader{ChunkDataOffset: 0x1000, ChunkCount: 1}
+	copy(fh.Magic[:], evtx.EvtxMagic)
+	b := &binxml{}
+	b.put(fh)
+	data := make([]byte, 0x1000+evtx.ChunkSize)
+	copy(data, b.Bytes())
+	copy(data[0x1000:], chunk(0).Data)
+	ef, err := evtx.New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ef
+}
+
+func TestDissectFile(t *testing.T) {
+	ef := file(t)
+
+	d, err := ef.DissectID(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ChunkOffset != 0x1000 || d.Offset != 0x200 || d.Err != nil {
+		t.Errorf("Unexpected dissection of record 42: %+v", d)
+	}
+	if d, err = ef.DissectAt(0x1200); err != nil || d.Header.ID != 42 {
+		t.Errorf("Record 42 not found at offset: %v", err)
+	}
+	if _, err := ef.DissectID(43); err == nil {
+		t.Error("Missing record found")
+	}
+}
+
+func TestMergeRecords(t *testing.T) {
+	inputs := make([]int, 0)
+	for r := range evtx.MergeRecords(false, file(t), file(t)) {
+		if r.Offset != 0x1200 || r.ChunkOffset != 0x1000 || r.Chunk != 0 || r.ID != 42 {
+			t.Errorf("Unexpected record: %+v", r.Record)
+		}
+		inputs = append(inputs, r.Input)
+	}
+	// same time and record ID, ordered by input
+	if len(inputs) != 2 || inputs[0] != 0 || inputs[1] != 1 {
+		t.Errorf("Unexpected merge: %v", inputs)
+	}
+}
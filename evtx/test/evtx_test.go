@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
 	"testing"
@@ -227,6 +228,37 @@ func TestRightOrderFastEvents(t *testing.T) {
 	t.Logf("Order is guaranteed accross events, %d events parsed", i)
 }
 
+func TestRecords(t *testing.T) {
+	ef, _ := evtx.Open(sysmonFile)
+	fd, err := os.Open(sysmonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	i := 0
+	for r := range ef.Records(true) {
+		// header record ID is the one of the event
+		if r.ID != r.Event.EventRecordID() {
+			t.Fatalf("Bad record ID %d instead of %d", r.ID, r.Event.EventRecordID())
+		}
+		if r.Chunk != (r.ChunkOffset-int64(ef.Header.ChunkDataOffset))/evtx.ChunkSize {
+			t.Fatalf("Bad chunk index %d for chunk at offset %d", r.Chunk, r.ChunkOffset)
+		}
+		// raw bytes must be the ones found in the file at the record offset
+		buf := make([]byte, r.Size)
+		if _, err := fd.ReadAt(buf, r.Offset); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, r.Raw) || string(buf[:4]) != evtx.EventMagic {
+			t.Fatalf("Bad raw bytes for record %d at offset %d", r.ID, r.Offset)
+		}
+		i++
+	}
+	if sysmonEventCount != i {
+		t.Fatalf("Bad record count %d instead of %d", i, sysmonEventCount)
+	}
+}
+
 func TestFilter(t *testing.T) {
 	ef, _ := evtx.Open(sysmonFile)
 	p := evtx.Path("/Event/EventData/Data1/Value")
@@ -58,6 +58,18 @@ func (u *UnkVal) Value() interface{} {
 	return u.Token
 }
 
+// typedValue returns the Go value of numeric, boolean and binary values and
+// the representation of the others
+func typedValue(v Value) interface{} {
+	switch v.(type) {
+	case *ValueInt8, *ValueUInt8, *ValueInt16, *ValueUInt16, *ValueInt32, *ValueUInt32,
+		*ValueHexInt32, *ValueInt64, *ValueUInt64, *ValueHexInt64, *ValueReal32,
+		*ValueReal64, *ValueBool, *ValueBinary:
+		return v.Value()
+	}
+	return v.Repr()
+}
+
 ////////////////////////////////// NullType ////////////////////////////////////
 
 type ValueNull struct {
@@ -223,7 +235,7 @@ func (i *ValueHexInt32) String() string {
 }
 
 func (i *ValueHexInt32) Value() interface{} {
-	return i.Value()
+	return i.ValueUInt32.Value()
 }
 
 func (i *ValueHexInt32) Repr() interface{} {
@@ -285,7 +297,7 @@ func (i *ValueHexInt64) String() string {
 }
 
 func (i *ValueHexInt64) Value() interface{} {
-	return i.Value()
+	return i.ValueUInt64.Value()
 }
 
 func (i *ValueHexInt64) Repr() interface{} {
@@ -0,0 +1,163 @@
+package normalize
+
+import (
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+var (
+	sysmonChannel         = "microsoft-windows-sysmon/operational"
+	powershellChannel     = "microsoft-windows-powershell/operational"
+	powershellClassicChan = "windows powershell"
+
+	// ecsLogLevels maps Windows levels to ECS log.level
+	ecsLogLevels = map[string]string{
+		"0": "information",
+		"1": "critical",
+		"2": "error",
+		"3": "warning",
+		"4": "information",
+		"5": "verbose",
+	}
+
+	// ecsSecurityProcess maps the process reporting a Security event
+	ecsSecurityProcess = []Field{
+		{"EventData/ProcessName", "process.executable", Dash(nil)},
+		{"EventData/ProcessId", "process.pid", ToInt},
+	}
+
+	// ECS Elastic Common Schema, unmapped fields are stored under winlog
+	ECS = &Schema{
+		Name: "ecs",
+		Raw:  "winlog",
+		Common: []Field{
+			{"System/TimeCreated/SystemTime", "@timestamp", ToRFC3339},
+			{"System/EventID", "event.code", ToString},
+			{"System/Provider/Name", "event.provider", nil},
+			{"System/Channel", "winlog.channel", nil},
+			{"System/Computer", "host.name", nil},
+			{"System/EventRecordID", "winlog.record_id", ToInt},
+			{"System/Execution/ProcessID", "winlog.process.pid", ToInt},
+			{"System/Execution/ThreadID", "winlog.process.thread.id", ToInt},
+			{"System/Security/UserID", "winlog.user.identifier", nil},
+			{"System/Task", "winlog.task", nil},
+			{"System/Opcode", "winlog.opcode", nil},
+			{"System/Keywords", "winlog.keywords", nil},
+			{"System/Level", "log.level", func(v interface{}) (interface{}, bool) {
+				l, ok := ecsLogLevels[fmtString(v)]
+				return l, ok
+			}},
+		},
+		Channels: map[string][]Field{
+			"security": {
+				{"EventData/SubjectUserSid", "user.id", Dash(nil)},
+				{"EventData/SubjectUserName", "user.name", Dash(nil)},
+				{"EventData/SubjectDomainName", "user.domain", Dash(nil)},
+				{"EventData/TargetUserSid", "user.target.id", Dash(nil)},
+				{"EventData/TargetUserName", "user.target.name", Dash(nil)},
+				{"EventData/TargetDomainName", "user.target.domain", Dash(nil)},
+				{"EventData/LogonType", "winlog.logon.type", ToInt},
+				{"EventData/TargetLogonId", "winlog.logon.id", Dash(nil)},
+				{"EventData/IpAddress", "source.ip", Dash(nil)},
+				{"EventData/IpPort", "source.port", Dash(ToInt)},
+				{"EventData/WorkstationName", "source.domain", Dash(nil)},
+				{"EventData/AuthenticationPackageName", "winlog.logon.authentication_package", Dash(nil)},
+				{"EventData/ServiceName", "service.name", Dash(nil)},
+				{"EventData/ShareName", "file.share_name", Dash(nil)},
+				{"EventData/ObjectName", "file.path", Dash(nil)},
+			},
+			sysmonChannel: {
+				{"EventData/ProcessGuid", "process.entity_id", nil},
+				{"EventData/ProcessId", "process.pid", ToInt},
+				{"EventData/Image", "process.executable", nil},
+				{"EventData/CommandLine", "process.command_line", nil},
+				{"EventData/CurrentDirectory", "process.working_directory", nil},
+				{"EventData/IntegrityLevel", "winlog.event_data.IntegrityLevel", nil},
+				{"EventData/Hashes", "process.hash.raw", nil},
+				{"EventData/ParentProcessGuid", "process.parent.entity_id", nil},
+				{"EventData/ParentProcessId", "process.parent.pid", ToInt},
+				{"EventData/ParentImage", "process.parent.executable", nil},
+				{"EventData/ParentCommandLine", "process.parent.command_line", nil},
+				{"EventData/User", "user.name", nil},
+				{"EventData/Protocol", "network.transport", nil},
+				{"EventData/SourceIp", "source.ip", nil},
+				{"EventData/SourceHostname", "source.domain", nil},
+				{"EventData/SourcePort", "source.port", ToInt},
+				{"EventData/DestinationIp", "destination.ip", nil},
+				{"EventData/DestinationHostname", "destination.domain", nil},
+				{"EventData/DestinationPort", "destination.port", ToInt},
+				{"EventData/TargetFilename", "file.path", nil},
+				{"EventData/ImageLoaded", "dll.path", nil},
+				{"EventData/Signature", "file.code_signature.subject_name", nil},
+				{"EventData/TargetObject", "registry.path", nil},
+				{"EventData/Details", "registry.data.strings", nil},
+				{"EventData/QueryName", "dns.question.name", nil},
+				{"EventData/QueryResults", "dns.answers_raw", nil},
+				{"EventData/PipeName", "file.name", nil},
+				{"EventData/SourceImage", "winlog.event_data.SourceImage", nil},
+				{"EventData/TargetImage", "winlog.event_data.TargetImage", nil},
+				{"EventData/RuleName", "rule.name", Dash(nil)},
+			},
+			powershellChannel: {
+				{"EventData/ScriptBlockText", "powershell.file.script_block_text", nil},
+				{"EventData/ScriptBlockId", "powershell.file.script_block_id", nil},
+				{"EventData/MessageNumber", "powershell.sequence", ToInt},
+				{"EventData/MessageTotal", "powershell.total", ToInt},
+				{"EventData/Path", "file.path", Dash(nil)},
+				{"EventData/Payload", "powershell.command.invocation_details", nil},
+				{"EventData/ContextInfo", "powershell.context_info", nil},
+			},
+			powershellClassicChan: {
+				{"EventData/Data", "powershell.event_data", nil},
+			},
+			"system": {
+				{"EventData/ServiceName", "service.name", nil},
+				{"EventData/ImagePath", "service.image_path", nil},
+				{"EventData/ServiceType", "service.type", nil},
+				{"EventData/StartType", "service.start_type", nil},
+				{"EventData/AccountName", "user.name", nil},
+				{"EventData/param1", "winlog.event_data.param1", nil},
+			},
+		},
+		Events: map[string]map[int64][]Field{
+			"security": {
+				// ProcessId and ProcessName are the ones of the creator process
+				4688: {
+					{"EventData/NewProcessName", "process.executable", Dash(nil)},
+					{"EventData/NewProcessId", "process.pid", ToInt},
+					{"EventData/CommandLine", "process.command_line", Dash(nil)},
+					{"EventData/ParentProcessName", "process.parent.executable", Dash(nil)},
+					{"EventData/ProcessId", "process.parent.pid", ToInt},
+				},
+				4689: ecsSecurityProcess,
+				4624: ecsSecurityProcess,
+				4625: ecsSecurityProcess,
+				4648: ecsSecurityProcess,
+			},
+			// UtcTime is the time of the event in the other Sysmon events
+			sysmonChannel: {
+				1: {
+					{"EventData/UtcTime", "process.start", nil},
+				},
+			},
+		},
+		Static: func(e *evtx.GoEvtxMap) map[string]interface{} {
+			return map[string]interface{}{
+				"event.kind":  "event",
+				"ecs.version": "1.12.0",
+				"os.family":   "windows",
+			}
+		},
+	}
+)
+
+func fmtString(v interface{}) string {
+	s, ok := ToString(v)
+	if !ok {
+		return ""
+	}
+	return s.(string)
+}
+
+func init() {
+	Register(ECS)
+}
@@ -0,0 +1,318 @@
+/*
+Package normalize converts events into documents following a common schema
+such as Elastic Common Schema (ECS) or OCSF.
+
+A Schema is made of mapping tables: fields common to all the events (mostly
+System fields), fields specific to a channel (Security, Sysmon, PowerShell
+...) and fields specific to an event ID of a channel, for the fields whose
+meaning depends on the event (ex: ProcessId in Security 4688 is the pid of the
+creator process). The mappings of an event table replace the channel mappings
+of the same source. Within the tables applied to an event a target is never
+mapped twice. Fields of the event not mapped by any table are kept, with their original
+structure, under the raw namespace of the schema so that no information is
+lost. Target field names are dotted names expanded into nested objects.
+*/
+package normalize
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// Converter converts a value of the event into the type expected by the schema
+type Converter func(v interface{}) (interface{}, bool)
+
+// Field maps a field of the event to a field of the schema
+type Field struct {
+	// Source path relative to the Event node (ex: EventData/TargetUserName)
+	Source string
+	// Target dotted name in the normalized document (ex: user.name)
+	Target string
+	// Convert is optional
+	Convert Converter
+}
+
+// Schema structure definition
+type Schema struct {
+	Name string
+	// Raw namespace where unmapped fields are stored
+	Raw string
+	// Common fields mapped for all the events
+	Common []Field
+	// Channels specific field mappings, indexed by lower case channel name
+	Channels map[string][]Field
+	// Events specific field mappings, indexed by lower case channel name and
+	// event ID, they replace the channel mappings of the same source
+	Events map[string]map[int64][]Field
+	// Static returns additional fields computed out of the event (optional),
+	// mapped fields take precedence over static ones
+	Static func(e *evtx.GoEvtxMap) map[string]interface{}
+}
+
+var (
+	schemas = map[string]*Schema{}
+)
+
+// Register registers a schema so that it can be retrieved by name
+func Register(s *Schema) {
+	schemas[strings.ToLower(s.Name)] = s
+}
+
+// Lookup returns a registered schema by name
+func Lookup(name string) (*Schema, error) {
+	if s, ok := schemas[strings.ToLower(name)]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("Unknown schema: %s", name)
+}
+
+// Names returns the names of the registered schemas
+func Names() []string {
+	names := make([]string, 0, len(schemas))
+	for n := range schemas {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// set sets a value at a dotted path in a document creating intermediate
+// objects when needed
+func set(doc evtx.GoEvtxMap, target string, v interface{}) {
+	parts := strings.Split(target, ".")
+	cur := doc
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(evtx.GoEvtxMap)
+		if !ok {
+			next = make(evtx.GoEvtxMap)
+			cur[p] = next
+		}
+		cur = next
+	}
+	cur[parts[len(parts)-1]] = v
+}
+
+// leaves walks a map and calls f for every leaf with its path
+func leaves(prefix evtx.GoEvtxPath, v interface{}, f func(path evtx.GoEvtxPath, v interface{})) {
+	var m map[string]interface{}
+	switch n := v.(type) {
+	case evtx.GoEvtxMap:
+		m = n
+	case map[string]interface{}:
+		m = n
+	default:
+		f(prefix, v)
+		return
+	}
+	for k, c := range m {
+		p := make(evtx.GoEvtxPath, len(prefix), len(prefix)+1)
+		copy(p, prefix)
+		leaves(append(p, k), c, f)
+	}
+}
+
+// apply applies a field mapping, it returns true if the field was found
+func apply(doc evtx.GoEvtxMap, e *evtx.GoEvtxMap, f Field) bool {
+	path := append(evtx.GoEvtxPath{"Event"}, evtx.Path(f.Source)...)
+	v, err := e.Get(&path)
+	if err != nil {
+		return false
+	}
+	value := *v
+	if f.Convert != nil {
+		var ok bool
+		if value, ok = f.Convert(value); !ok {
+			// we keep the value in raw namespace
+			return false
+		}
+	}
+	set(doc, f.Target, value)
+	return true
+}
+
+// Fields returns the field mappings applied to an event
+func (s *Schema) Fields(e *evtx.GoEvtxMap) []Field {
+	fields := s.Common
+	if channel, err := e.GetString(&evtx.ChannelPath); err == nil {
+		channel = strings.ToLower(channel)
+		var specific []Field
+		if eid, ok := eventID(e); ok {
+			specific = s.Events[channel][eid]
+		}
+		fields = override(append(append([]Field{}, fields...), s.Channels[channel]...), specific)
+	}
+	return fields
+}
+
+// override returns the fields of base not mapping a source of specific,
+// followed by specific
+func override(base, specific []Field) []Field {
+	if len(specific) == 0 {
+		return base
+	}
+	sources := make(map[string]bool, len(specific))
+	for _, f := range specific {
+		sources[f.Source] = true
+	}
+	fields := make([]Field, 0, len(base)+len(specific))
+	for _, f := range base {
+		if !sources[f.Source] {
+			fields = append(fields, f)
+		}
+	}
+	return append(fields, specific...)
+}
+
+// eventID returns the EventID of an event
+func eventID(e *evtx.GoEvtxMap) (int64, bool) {
+	eid, err := e.GetInt(&evtx.EventIDPath)
+	if err != nil {
+		if eid, err = e.GetInt(&evtx.EventIDPath2); err != nil {
+			return 0, false
+		}
+	}
+	return eid, true
+}
+
+// Normalize converts an event into a new document following the schema. The
+// event is not modified.
+// @e : event to normalize
+// return *evtx.GoEvtxMap : normalized document
+func (s *Schema) Normalize(e *evtx.GoEvtxMap) *evtx.GoEvtxMap {
+	doc := make(evtx.GoEvtxMap)
+	mapped := make(map[string]bool)
+
+	if s.Static != nil {
+		for k, v := range s.Static(e) {
+			set(doc, k, v)
+		}
+	}
+
+	for _, f := range s.Fields(e) {
+		if apply(doc, e, f) {
+			mapped[f.Source] = true
+		}
+	}
+
+	// unmapped fields go to raw namespace
+	if event, ok := (*e)["Event"]; ok {
+		leaves(nil, event, func(path evtx.GoEvtxPath, v interface{}) {
+			if len(path) == 1 && path[0] == "xmlns" {
+				return
+			}
+			// a mapped node maps all its children
+			for i := len(path); i > 0; i-- {
+				if mapped[path[:i].String()] {
+					return
+				}
+			}
+			set(doc, s.Raw+"."+strings.Join(path, "."), v)
+		})
+	}
+	return &doc
+}
+
+//////////////////////////////// Converters ////////////////////////////////////
+
+// unwrap returns the Value of nodes having attributes (like EventID having
+// a Qualifiers attribute)
+func unwrap(v interface{}) interface{} {
+	switch m := v.(type) {
+	case evtx.GoEvtxMap:
+		if val, ok := m["Value"]; ok {
+			return val
+		}
+	case map[string]interface{}:
+		if val, ok := m["Value"]; ok {
+			return val
+		}
+	}
+	return v
+}
+
+// ToString converts a value into a string
+func ToString(v interface{}) (interface{}, bool) {
+	switch s := unwrap(v).(type) {
+	case string:
+		return s, true
+	case nil:
+		return nil, false
+	case evtx.GoEvtxMap, map[string]interface{}:
+		return nil, false
+	default:
+		return fmt.Sprint(s), true
+	}
+}
+
+// ToInt converts a value into an integer (decimal or hexadecimal)
+func ToInt(v interface{}) (interface{}, bool) {
+	s, ok := ToString(v)
+	if !ok {
+		return nil, false
+	}
+	i, err := strconv.ParseInt(strings.TrimSpace(s.(string)), 0, 64)
+	if err != nil {
+		return nil, false
+	}
+	return i, true
+}
+
+// ToTime converts a value into a time.Time
+func ToTime(v interface{}) (t time.Time, ok bool) {
+	switch tv := v.(type) {
+	case time.Time:
+		return tv, true
+	case evtx.UTCTime:
+		return time.Time(tv), true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, tv)
+		return t, err == nil
+	}
+	return
+}
+
+// ToRFC3339 converts a time value into an RFC3339 string
+func ToRFC3339(v interface{}) (interface{}, bool) {
+	t, ok := ToTime(v)
+	if !ok {
+		return nil, false
+	}
+	return t.UTC().Format(time.RFC3339Nano), true
+}
+
+// ToEpochMillis converts a time value into milliseconds since epoch
+func ToEpochMillis(v interface{}) (interface{}, bool) {
+	t, ok := ToTime(v)
+	if !ok {
+		return nil, false
+	}
+	return t.UnixNano() / int64(time.Millisecond), true
+}
+
+// ToStrings converts a value into a list of one string
+func ToStrings(v interface{}) (interface{}, bool) {
+	s, ok := ToString(v)
+	if !ok {
+		return nil, false
+	}
+	return []string{s.(string)}, true
+}
+
+// Dash returns a converter ignoring the "-" values Windows uses for empty
+// fields
+func Dash(c Converter) Converter {
+	return func(v interface{}) (interface{}, bool) {
+		if s, ok := v.(string); ok && (s == "-" || s == "") {
+			return nil, false
+		}
+		if c == nil {
+			return v, true
+		}
+		return c(v)
+	}
+}
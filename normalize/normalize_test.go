@@ -0,0 +1,247 @@
+package normalize
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+func event(channel, eid string, data evtx.GoEvtxMap) *evtx.GoEvtxMap {
+	return &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{
+			"System": evtx.GoEvtxMap{
+				"Channel":       channel,
+				"Computer":      "DC01",
+				"EventID":       eid,
+				"EventRecordID": "1234",
+				"Level":         "4",
+				"Security":      evtx.GoEvtxMap{"UserID": "S-1-5-21-1-2-3-500"},
+				"TimeCreated": evtx.GoEvtxMap{
+					"SystemTime": evtx.UTCTime(time.Date(2019, 5, 12, 10, 0, 0, 0, time.UTC)),
+				},
+			},
+			"EventData": data,
+		},
+	}
+}
+
+var (
+	logon = event("Security", "4624", evtx.GoEvtxMap{
+		"SubjectUserSid":   "S-1-5-18",
+		"TargetUserName":   "Administrator",
+		"LogonType":        "10",
+		"IpAddress":        "192.168.1.10",
+		"IpPort":           "-",
+		"ProcessName":      "C:\\Windows\\System32\\svchost.exe",
+		"ProcessId":        "0x3e4",
+		"ImpersonationLvl": "%%1833",
+	})
+
+	processCreation = event("Security", "4688", evtx.GoEvtxMap{
+		"NewProcessName":    "C:\\Windows\\System32\\cmd.exe",
+		"NewProcessId":      "0x1a4",
+		"ProcessId":         "0x2b8",
+		"ParentProcessName": "C:\\Windows\\explorer.exe",
+		"CommandLine":       "cmd.exe /c whoami",
+	})
+
+	sysmonProcess = event("Microsoft-Windows-Sysmon/Operational", "1", evtx.GoEvtxMap{
+		"UtcTime":         "2019-05-12 09:59:59.000",
+		"ProcessGuid":     "{5770385F-C22A-5E0C-0000-0010A4180C00}",
+		"ProcessId":       "420",
+		"Image":           "C:\\Windows\\System32\\cmd.exe",
+		"ParentProcessId": "696",
+		"ParentImage":     "C:\\Windows\\explorer.exe",
+		"User":            "DC01\\Administrator",
+		"Hashes":          "SHA1=0123456789ABCDEF",
+	})
+
+	sysmonNetwork = event("Microsoft-Windows-Sysmon/Operational", "3", evtx.GoEvtxMap{
+		"Image":           "C:\\Windows\\System32\\svchost.exe",
+		"Protocol":        "tcp",
+		"UtcTime":         "2019-05-12 10:00:00.000",
+		"SourceIp":        "10.0.0.1",
+		"SourcePort":      "49152",
+		"DestinationIp":   "10.0.0.2",
+		"DestinationPort": "443",
+		"RuleName":        "technique_id=T1071",
+	})
+
+	scriptBlock = event("Microsoft-Windows-PowerShell/Operational", "4104", evtx.GoEvtxMap{
+		"ScriptBlockText": "Get-Process",
+		"ScriptBlockId":   "b7d9c8e0-0000-0000-0000-000000000000",
+		"MessageNumber":   "1",
+		"MessageTotal":    "1",
+		"Path":            "",
+	})
+)
+
+func get(doc *evtx.GoEvtxMap, target string) (interface{}, bool) {
+	path := evtx.GoEvtxPath(strings.Split(target, "."))
+	v, err := doc.Get(&path)
+	if err != nil {
+		return nil, false
+	}
+	return *v, true
+}
+
+func check(t *testing.T, s *Schema, name string, e *evtx.GoEvtxMap, expect map[string]interface{}) {
+	doc := s.Normalize(e)
+	for target, value := range expect {
+		v, ok := get(doc, target)
+		switch {
+		case value == nil && ok:
+			t.Errorf("%s %s: %s should not be set, got %v", s.Name, name, target, v)
+		case value != nil && !ok:
+			t.Errorf("%s %s: %s is missing", s.Name, name, target)
+		case value != nil && v != value:
+			t.Errorf("%s %s: %s expected %v (%T) got %v (%T)", s.Name, name, target, value, value, v, v)
+		}
+	}
+}
+
+func TestECS(t *testing.T) {
+	check(t, ECS, "4624", logon, map[string]interface{}{
+		"@timestamp":                        "2019-05-12T10:00:00Z",
+		"event.code":                        "4624",
+		"log.level":                         "information",
+		"user.id":                           "S-1-5-18",
+		"user.target.name":                  "Administrator",
+		"winlog.logon.type":                 int64(10),
+		"source.ip":                         "192.168.1.10",
+		"source.port":                       nil,
+		"process.executable":                "C:\\Windows\\System32\\svchost.exe",
+		"process.pid":                       int64(0x3e4),
+		"winlog.EventData.IpPort":           "-",
+		"winlog.EventData.TargetUserName":   nil,
+		"winlog.System.Security.UserID":     nil,
+		"winlog.user.identifier":            "S-1-5-21-1-2-3-500",
+		"winlog.EventData.ImpersonationLvl": "%%1833",
+	})
+
+	check(t, ECS, "4688", processCreation, map[string]interface{}{
+		"process.executable":        "C:\\Windows\\System32\\cmd.exe",
+		"process.pid":               int64(0x1a4),
+		"process.parent.pid":        int64(0x2b8),
+		"process.parent.executable": "C:\\Windows\\explorer.exe",
+		"process.command_line":      "cmd.exe /c whoami",
+	})
+
+	check(t, ECS, "sysmon 1", sysmonProcess, map[string]interface{}{
+		"process.entity_id":         "{5770385F-C22A-5E0C-0000-0010A4180C00}",
+		"process.pid":               int64(420),
+		"process.executable":        "C:\\Windows\\System32\\cmd.exe",
+		"process.parent.pid":        int64(696),
+		"process.parent.executable": "C:\\Windows\\explorer.exe",
+		"process.hash.raw":          "SHA1=0123456789ABCDEF",
+		"user.name":                 "DC01\\Administrator",
+		"process.start":             "2019-05-12 09:59:59.000",
+	})
+
+	check(t, ECS, "sysmon 3", sysmonNetwork, map[string]interface{}{
+		"network.transport": "tcp",
+		"source.ip":         "10.0.0.1",
+		"source.port":       int64(49152),
+		"destination.ip":    "10.0.0.2",
+		"destination.port":  int64(443),
+		"process.start":     nil,
+	})
+
+	check(t, ECS, "4104", scriptBlock, map[string]interface{}{
+		"powershell.file.script_block_text": "Get-Process",
+		"powershell.sequence":               int64(1),
+		"powershell.total":                  int64(1),
+		"file.path":                         nil,
+		"winlog.EventData.Path":             "",
+	})
+}
+
+func TestOCSF(t *testing.T) {
+	check(t, OCSF, "4624", logon, map[string]interface{}{
+		"class_uid":                           int64(3002),
+		"activity_id":                         int64(1),
+		"type_uid":                            int64(300201),
+		"severity_id":                         int64(1),
+		"time":                                time.Date(2019, 5, 12, 10, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond),
+		"actor.user.uid":                      "S-1-5-18",
+		"user.name":                           "Administrator",
+		"logon_type_id":                       int64(10),
+		"src_endpoint.ip":                     "192.168.1.10",
+		"unmapped.System.Security.UserID":     "S-1-5-21-1-2-3-500",
+		"unmapped.EventData.ImpersonationLvl": "%%1833",
+	})
+
+	check(t, OCSF, "4688", processCreation, map[string]interface{}{
+		"class_uid":                        int64(1007),
+		"activity_id":                      int64(1),
+		"type_uid":                         int64(100701),
+		"process.file.path":                "C:\\Windows\\System32\\cmd.exe",
+		"process.pid":                      int64(0x1a4),
+		"actor.process.pid":                int64(0x2b8),
+		"process.parent_process.file.path": "C:\\Windows\\explorer.exe",
+	})
+
+	check(t, OCSF, "sysmon 1", sysmonProcess, map[string]interface{}{
+		"class_uid":                  int64(1007),
+		"type_uid":                   int64(100701),
+		"process.uid":                "{5770385F-C22A-5E0C-0000-0010A4180C00}",
+		"actor.process.uid":          nil,
+		"process.parent_process.pid": int64(696),
+		"actor.user.name":            "DC01\\Administrator",
+		"unmapped.EventData.Hashes":  "SHA1=0123456789ABCDEF",
+	})
+
+	check(t, OCSF, "sysmon 3", sysmonNetwork, map[string]interface{}{
+		"class_uid":               int64(4001),
+		"type_uid":                int64(400101),
+		"src_endpoint.port":       int64(49152),
+		"dst_endpoint.ip":         "10.0.0.2",
+		"actor.process.file.path": "C:\\Windows\\System32\\svchost.exe",
+		"process.file.path":       nil,
+	})
+
+	check(t, OCSF, "4104", scriptBlock, map[string]interface{}{
+		"class_uid":             int64(0),
+		"activity_id":           int64(0),
+		"type_uid":              int64(0),
+		"script.script_content": "Get-Process",
+		"actor.user.uid":        "S-1-5-21-1-2-3-500",
+		"script.file.path":      nil,
+	})
+
+	// required attributes are there even when the event lacks them
+	check(t, OCSF, "empty", &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{}}, map[string]interface{}{
+		"class_uid":   int64(0),
+		"severity_id": int64(0),
+		"time":        int64(0),
+	})
+}
+
+func TestLabels(t *testing.T) {
+	v, ok := get(OCSF.Normalize(sysmonNetwork), "metadata.labels")
+	if labels, isList := v.([]string); !ok || !isList || len(labels) != 1 || labels[0] != "technique_id=T1071" {
+		t.Errorf("Unexpected metadata.labels: %#v", v)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	for _, s := range []*Schema{ECS, OCSF} {
+		for channel, fields := range s.Channels {
+			tables := [][]Field{append(append([]Field{}, s.Common...), fields...)}
+			for _, ef := range s.Events[channel] {
+				tables = append(tables, override(tables[0], ef))
+			}
+			for _, table := range tables {
+				targets := make(map[string]string)
+				for _, f := range table {
+					if src, ok := targets[f.Target]; ok {
+						t.Errorf("%s %s: %s mapped by %s and %s", s.Name, channel, f.Target, src, f.Source)
+					}
+					targets[f.Target] = f.Source
+				}
+			}
+		}
+	}
+}
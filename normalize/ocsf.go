@@ -0,0 +1,206 @@
+package normalize
+
+import (
+	"strings"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// ocsfClass describes an OCSF event class
+type ocsfClass struct {
+	UID         int64
+	Name        string
+	CategoryUID int64
+	Category    string
+}
+
+// ocsfEvent is the OCSF class and activity_id of an event
+type ocsfEvent struct {
+	Class    ocsfClass
+	Activity int64
+}
+
+var (
+	ocsfEventTypePath = evtx.Path("/Event/EventData/EventType")
+
+	ocsfAuthentication = ocsfClass{3002, "Authentication", 3, "Identity & Access Management"}
+	ocsfProcess        = ocsfClass{1007, "Process Activity", 1, "System Activity"}
+	ocsfNetwork        = ocsfClass{4001, "Network Activity", 4, "Network Activity"}
+	ocsfFile           = ocsfClass{1001, "File System Activity", 1, "System Activity"}
+	ocsfRegistry       = ocsfClass{201001, "Registry Key Activity", 1, "System Activity"}
+	ocsfDNS            = ocsfClass{4003, "DNS Activity", 4, "Network Activity"}
+	ocsfBase           = ocsfClass{0, "Base Event", 0, "Uncategorized"}
+
+	// ocsfEvents maps channel and event id to OCSF classes and activities
+	ocsfEvents = map[string]map[int64]ocsfEvent{
+		"security": {
+			4624: {ocsfAuthentication, 1},
+			4625: {ocsfAuthentication, 1},
+			4634: {ocsfAuthentication, 2},
+			4647: {ocsfAuthentication, 2},
+			4648: {ocsfAuthentication, 1},
+			4688: {ocsfProcess, 1},
+			4689: {ocsfProcess, 2},
+		},
+		sysmonChannel: {
+			1:  {ocsfProcess, 1},
+			3:  {ocsfNetwork, 1},
+			5:  {ocsfProcess, 2},
+			11: {ocsfFile, 1},
+			// both key creation and deletion, see ocsfRegistryActivities
+			12: {ocsfRegistry, 0},
+			13: {ocsfRegistry, 3},
+			14: {ocsfRegistry, 5},
+			22: {ocsfDNS, 6},
+			23: {ocsfFile, 4},
+			26: {ocsfFile, 4},
+		},
+	}
+
+	// ocsfRegistryActivities maps Sysmon registry EventType to activity_id
+	ocsfRegistryActivities = map[string]int64{
+		"CreateKey": 1,
+		"DeleteKey": 4,
+	}
+
+	// ocsfSysmonProcess maps the process of Sysmon process events, it is the
+	// actor (actor.process) of the other events
+	ocsfSysmonProcess = []Field{
+		{"EventData/ProcessGuid", "process.uid", nil},
+		{"EventData/ProcessId", "process.pid", ToInt},
+		{"EventData/Image", "process.file.path", nil},
+	}
+
+	// ocsfSeverities maps Windows levels to OCSF severity_id
+	ocsfSeverities = map[string]int64{
+		"0": 1,
+		"1": 5,
+		"2": 4,
+		"3": 3,
+		"4": 1,
+		"5": 1,
+	}
+
+	// OCSF Open Cybersecurity Schema Framework, unmapped fields are stored
+	// under unmapped
+	OCSF = &Schema{
+		Name: "ocsf",
+		Raw:  "unmapped",
+		Common: []Field{
+			{"System/TimeCreated/SystemTime", "time", ToEpochMillis},
+			{"System/EventID", "metadata.event_code", ToString},
+			{"System/Provider/Name", "metadata.product.name", nil},
+			{"System/Channel", "metadata.log_name", nil},
+			{"System/EventRecordID", "metadata.uid", ToString},
+			{"System/Computer", "device.hostname", nil},
+			{"System/Level", "severity_id", func(v interface{}) (interface{}, bool) {
+				s, ok := ocsfSeverities[fmtString(v)]
+				return s, ok
+			}},
+		},
+		Channels: map[string][]Field{
+			"security": {
+				{"EventData/SubjectUserSid", "actor.user.uid", Dash(nil)},
+				{"EventData/SubjectUserName", "actor.user.name", Dash(nil)},
+				{"EventData/SubjectDomainName", "actor.user.domain", Dash(nil)},
+				{"EventData/TargetUserSid", "user.uid", Dash(nil)},
+				{"EventData/TargetUserName", "user.name", Dash(nil)},
+				{"EventData/TargetDomainName", "user.domain", Dash(nil)},
+				{"EventData/LogonType", "logon_type_id", ToInt},
+				{"EventData/TargetLogonId", "session.uid", Dash(nil)},
+				{"EventData/IpAddress", "src_endpoint.ip", Dash(nil)},
+				{"EventData/IpPort", "src_endpoint.port", Dash(ToInt)},
+				{"EventData/WorkstationName", "src_endpoint.hostname", Dash(nil)},
+				{"EventData/AuthenticationPackageName", "auth_protocol", Dash(nil)},
+				{"EventData/NewProcessName", "process.file.path", Dash(nil)},
+				{"EventData/NewProcessId", "process.pid", ToInt},
+				{"EventData/CommandLine", "process.cmd_line", Dash(nil)},
+				{"EventData/ParentProcessName", "process.parent_process.file.path", Dash(nil)},
+				{"EventData/ProcessName", "actor.process.file.path", Dash(nil)},
+				{"EventData/ProcessId", "actor.process.pid", ToInt},
+			},
+			sysmonChannel: {
+				{"EventData/ProcessGuid", "actor.process.uid", nil},
+				{"EventData/ProcessId", "actor.process.pid", ToInt},
+				{"EventData/Image", "actor.process.file.path", nil},
+				{"EventData/CommandLine", "process.cmd_line", nil},
+				{"EventData/CurrentDirectory", "process.working_directory", nil},
+				{"EventData/IntegrityLevel", "process.integrity", nil},
+				{"EventData/ParentProcessGuid", "process.parent_process.uid", nil},
+				{"EventData/ParentProcessId", "process.parent_process.pid", ToInt},
+				{"EventData/ParentImage", "process.parent_process.file.path", nil},
+				{"EventData/ParentCommandLine", "process.parent_process.cmd_line", nil},
+				{"EventData/User", "actor.user.name", nil},
+				{"EventData/Protocol", "connection_info.protocol_name", nil},
+				{"EventData/SourceIp", "src_endpoint.ip", nil},
+				{"EventData/SourceHostname", "src_endpoint.hostname", nil},
+				{"EventData/SourcePort", "src_endpoint.port", ToInt},
+				{"EventData/DestinationIp", "dst_endpoint.ip", nil},
+				{"EventData/DestinationHostname", "dst_endpoint.hostname", nil},
+				{"EventData/DestinationPort", "dst_endpoint.port", ToInt},
+				{"EventData/TargetFilename", "file.path", nil},
+				{"EventData/TargetObject", "reg_key.path", nil},
+				{"EventData/QueryName", "query.hostname", nil},
+				{"EventData/QueryResults", "answers_raw", nil},
+				{"EventData/RuleName", "metadata.labels", Dash(ToStrings)},
+			},
+			powershellChannel: {
+				{"EventData/ScriptBlockText", "script.script_content", nil},
+				{"EventData/ScriptBlockId", "script.uid", nil},
+				{"EventData/Path", "script.file.path", Dash(nil)},
+				// Security channel uses SubjectUserSid
+				{"System/Security/UserID", "actor.user.uid", nil},
+			},
+		},
+		Events: map[string]map[int64][]Field{
+			sysmonChannel: {
+				1: ocsfSysmonProcess,
+				5: ocsfSysmonProcess,
+			},
+		},
+		// time and severity_id defaults are overwritten by mapped fields
+		Static: func(e *evtx.GoEvtxMap) map[string]interface{} {
+			oe := ocsfEventOf(e)
+			return map[string]interface{}{
+				"class_uid":                    oe.Class.UID,
+				"class_name":                   oe.Class.Name,
+				"category_uid":                 oe.Class.CategoryUID,
+				"category_name":                oe.Class.Category,
+				"activity_id":                  oe.Activity,
+				"type_uid":                     oe.Class.UID*100 + oe.Activity,
+				"severity_id":                  int64(0),
+				"time":                         int64(0),
+				"metadata.version":             "1.1.0",
+				"metadata.product.vendor_name": "Microsoft",
+				"device.os.type":               "Windows",
+			}
+		},
+	}
+)
+
+// ocsfEventOf returns the OCSF class and activity of an event
+func ocsfEventOf(e *evtx.GoEvtxMap) ocsfEvent {
+	base := ocsfEvent{ocsfBase, 0}
+	channel, err := e.GetString(&evtx.ChannelPath)
+	if err != nil {
+		return base
+	}
+	eid, ok := eventID(e)
+	if !ok {
+		return base
+	}
+	oe, ok := ocsfEvents[strings.ToLower(channel)][eid]
+	if !ok {
+		return base
+	}
+	if oe.Class.UID == ocsfRegistry.UID && oe.Activity == 0 {
+		if et, err := e.GetString(&ocsfEventTypePath); err == nil {
+			oe.Activity = ocsfRegistryActivities[et]
+		}
+	}
+	return oe
+}
+
+func init() {
+	Register(OCSF)
+}
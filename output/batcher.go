@@ -0,0 +1,185 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-utils/log"
+)
+
+// BatchOptions configures a Batcher
+type BatchOptions struct {
+	// Size is the maximum number of events in a batch
+	Size int
+	// Interval is the maximum time an event waits in a batch before being
+	// delivered (zero disables time based flushing)
+	Interval time.Duration
+	// Retry policy applied to the batches
+	Retry RetryPolicy
+}
+
+var (
+	// DefaultBatchOptions used by evtxdump
+	DefaultBatchOptions = BatchOptions{
+		Size:     100,
+		Interval: time.Second,
+		Retry:    DefaultRetryPolicy,
+	}
+)
+
+// DeliveryError is returned when a batch could not be delivered, it holds the
+// events lost so that the caller can keep them somewhere else
+type DeliveryError struct {
+	Events []*evtx.GoEvtxMap
+	Err    error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("Failed to deliver %d events: %s", len(e.Events), e.Err)
+}
+
+// Batcher groups events into batches before writing them to an Output and
+// retries the batches failing to be delivered. It implements Output.
+type Batcher struct {
+	sync.Mutex
+	out     Output
+	opts    BatchOptions
+	batch   []*evtx.GoEvtxMap
+	pending error
+	notify  func(delivered, failed int)
+	done    chan bool
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// NewBatcher creates a new Batcher writing to out
+// @out : output to write batches to
+// @opts : batching options
+// return *Batcher
+func NewBatcher(out Output, opts BatchOptions) *Batcher {
+	if opts.Size <= 0 {
+		opts.Size = 1
+	}
+	if r, ok := out.(Retrier); ok {
+		r.SetRetryPolicy(opts.Retry)
+		opts.Retry.MaxRetries = 0
+	}
+	return &Batcher{
+		out:   out,
+		opts:  opts,
+		batch: make([]*evtx.GoEvtxMap, 0, opts.Size),
+		done:  make(chan bool),
+	}
+}
+
+// Open opens the underlying output and starts the time based flushing
+func (b *Batcher) Open() error {
+	if err := b.out.Open(); err != nil {
+		return err
+	}
+	if b.opts.Interval > 0 {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			ticker := time.NewTicker(b.opts.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-b.done:
+					return
+				case <-ticker.C:
+					b.Lock()
+					if err := b.flush(context.Background()); err != nil {
+						// returned by next call to Write, Flush or Close
+						log.Error(err)
+						b.pending = err
+					}
+					b.Unlock()
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Write adds events to the current batch, the batch is delivered when full.
+// The call blocks until the batch is delivered or given up.
+func (b *Batcher) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	b.Lock()
+	defer b.Unlock()
+	err := b.takePending()
+	for _, e := range events {
+		b.batch = append(b.batch, e)
+		if len(b.batch) >= b.opts.Size {
+			if ferr := b.flush(ctx); ferr != nil {
+				err = ferr
+			}
+		}
+	}
+	return err
+}
+
+// Flush delivers the current batch
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.Lock()
+	defer b.Unlock()
+	if err := b.flush(ctx); err != nil {
+		return err
+	}
+	return b.takePending()
+}
+
+// Close delivers the current batch, stops the time based flushing and closes
+// the underlying output
+func (b *Batcher) Close() error {
+	b.once.Do(func() { close(b.done) })
+	b.wg.Wait()
+	err := b.Flush(context.Background())
+	if cerr := b.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Notify implements Notifier
+func (b *Batcher) Notify(f func(delivered, failed int)) {
+	b.Lock()
+	defer b.Unlock()
+	b.notify = f
+}
+
+func (b *Batcher) takePending() (err error) {
+	err, b.pending = b.pending, nil
+	return
+}
+
+// flush must be called with the lock held
+func (b *Batcher) flush(ctx context.Context) error {
+	if len(b.batch) == 0 {
+		return nil
+	}
+	batch := b.batch
+	b.batch = make([]*evtx.GoEvtxMap, 0, b.opts.Size)
+	err := b.opts.Retry.Retry(ctx, func() error {
+		if err := b.out.Write(ctx, batch); err != nil {
+			return err
+		}
+		return b.out.Flush(ctx)
+	})
+	if err != nil {
+		b.notified(0, len(batch))
+		return &DeliveryError{batch, err}
+	}
+	b.notified(len(batch), 0)
+	return nil
+}
+
+// notified calls the notify function if any, must be called with the lock held
+func (b *Batcher) notified(delivered, failed int) {
+	if b.notify != nil {
+		b.notify(delivered, failed)
+	}
+}
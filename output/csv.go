@@ -0,0 +1,245 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// csvTable is the set of events of an event ID in auto mode, the rows are
+// spilled to a temporary file as JSON lines until the columns are known
+type csvTable struct {
+	columns map[string]bool
+	fd      *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+}
+
+func newCSVTable() (*csvTable, error) {
+	fd, err := ioutil.TempFile("", "evtx-csv-*")
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(fd)
+	return &csvTable{columns: make(map[string]bool), fd: fd, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// add spills a flattened event to the table
+func (t *csvTable) add(flat map[string]interface{}) error {
+	row := make(map[string]string, len(flat))
+	for k, v := range flat {
+		t.columns[k] = true
+		row[k] = ValueString(v)
+	}
+	return t.enc.Encode(row)
+}
+
+// remove deletes the temporary file of the table
+func (t *csvTable) remove() {
+	t.fd.Close()
+	os.Remove(t.fd.Name())
+}
+
+// CSV writes events as CSV (RFC 4180) or TSV. Columns are paths relative to
+// the Event node (ex: System/TimeCreated/SystemTime) or absolute if they start
+// with a / (ex: /Meta/File). Without columns (auto mode), events are flattened
+// into dotted column names and grouped by event ID, each event ID having its
+// own table with the union of the columns of its events. As the columns are
+// known only once all the events are seen, rows are spilled to temporary files
+// and tables are written on Close.
+type CSV struct {
+	w      *csv.Writer
+	paths  []evtx.GoEvtxPath
+	tables map[int64]*csvTable
+	// W is where the events are written, default is os.Stdout
+	W io.Writer
+	// Columns are the paths of the columns, auto mode if empty
+	Columns []string
+	// Comma is the field separator, default is ','
+	Comma rune
+	// Dir is the directory where the tables of the auto mode are written as
+	// <EventID>.csv files, they are written to W one after the other separated
+	// by an empty line if empty
+	Dir string
+}
+
+func (c *CSV) newWriter(w io.Writer) *csv.Writer {
+	cw := csv.NewWriter(w)
+	cw.Comma = c.Comma
+	cw.UseCRLF = true
+	return cw
+}
+
+// Open checks the configuration and writes the header in fixed columns mode
+func (c *CSV) Open() error {
+	if c.W == nil {
+		c.W = os.Stdout
+	}
+	if c.Comma == 0 {
+		c.Comma = ','
+	}
+	c.w = c.newWriter(c.W)
+	if len(c.Columns) == 0 {
+		c.tables = make(map[int64]*csvTable)
+		return nil
+	}
+	c.paths = make([]evtx.GoEvtxPath, 0, len(c.Columns))
+	for _, col := range c.Columns {
+		col = strings.TrimSpace(col)
+		if col == "" {
+			return fmt.Errorf("Empty CSV column")
+		}
+		c.paths = append(c.paths, fieldPath(col))
+	}
+	if err := c.w.Write(c.Columns); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Write writes the events as rows in fixed columns mode or spills them until
+// Close in auto mode
+func (c *CSV) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	for _, e := range events {
+		if c.tables != nil {
+			eid := eventID(e)
+			t := c.tables[eid]
+			if t == nil {
+				var err error
+				if t, err = newCSVTable(); err != nil {
+					return err
+				}
+				c.tables[eid] = t
+			}
+			if err := t.add(Flatten(e, ".")); err != nil {
+				return err
+			}
+			continue
+		}
+		row := make([]string, len(c.paths))
+		for i := range c.paths {
+			if v, err := e.Get(&c.paths[i]); err == nil {
+				row[i] = ValueString(*v)
+			}
+		}
+		if err := c.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTable writes a table of the auto mode
+func (c *CSV) writeTable(w *csv.Writer, t *csvTable) error {
+	columns := make([]string, 0, len(t.columns))
+	for k := range t.columns {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	if err := t.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := t.fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(t.fd))
+	for {
+		var r map[string]string
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		row := make([]string, len(columns))
+		for i, k := range columns {
+			row[i] = r[k]
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeTables writes the tables of the auto mode ordered by event ID and
+// removes their temporary files
+func (c *CSV) writeTables() error {
+	defer func() {
+		for _, t := range c.tables {
+			t.remove()
+		}
+		c.tables = make(map[int64]*csvTable)
+	}()
+
+	eids := make([]int64, 0, len(c.tables))
+	for eid := range c.tables {
+		eids = append(eids, eid)
+	}
+	sort.Slice(eids, func(i, j int) bool { return eids[i] < eids[j] })
+
+	ext := ".csv"
+	if c.Comma == '\t' {
+		ext = ".tsv"
+	}
+	for i, eid := range eids {
+		t := c.tables[eid]
+		if c.Dir == "" {
+			if i > 0 {
+				io.WriteString(c.W, "\r\n")
+			}
+			if err := c.writeTable(c.w, t); err != nil {
+				return err
+			}
+			continue
+		}
+		name := "unknown"
+		if eid >= 0 {
+			name = fmt.Sprintf("%d", eid)
+		}
+		if err := os.MkdirAll(c.Dir, 0755); err != nil {
+			return err
+		}
+		fd, err := os.Create(filepath.Join(c.Dir, name+ext))
+		if err != nil {
+			return err
+		}
+		err = c.writeTable(c.newWriter(fd), t)
+		if cerr := fd.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the buffered rows in fixed columns mode
+func (c *CSV) Flush(ctx context.Context) error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Close writes the buffered rows and the tables of the auto mode
+func (c *CSV) Close() error {
+	if c.tables != nil {
+		return c.writeTables()
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
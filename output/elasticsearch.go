@@ -0,0 +1,265 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+const (
+	// DefaultIndexTemplate used when no index is given
+	DefaultIndexTemplate = "winlogbeat-{Channel}-{yyyy.MM.dd}"
+)
+
+// characters not allowed in index names
+var indexCleaner = strings.NewReplacer(
+	`\`, "_", "/", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_",
+	"|", "_", " ", "_", ",", "_", "#", "_", ":", "_",
+)
+
+// cleanIndex makes a value usable in an index name
+func cleanIndex(s string) string {
+	return strings.ToLower(indexCleaner.Replace(s))
+}
+
+// DocumentID returns a deterministic document ID computed out of the
+// computer, the channel and the record ID of the event, so that ingesting the
+// same event twice does not create duplicates. Events without record ID
+// (normalized documents, events without System) are identified by their
+// content.
+func DocumentID(e *evtx.GoEvtxMap) string {
+	if _, err := e.GetInt(&evtx.EventRecordIDPath); err != nil {
+		sum := sha256.Sum256(evtx.ToJSON(e))
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s",
+		getString(e, computerPath),
+		getString(e, evtx.ChannelPath),
+		getString(e, evtx.EventRecordIDPath))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Elasticsearch sends events to Elasticsearch or OpenSearch with the _bulk
+// API. Only the items failing with a retryable status (429, 5xx) are retried,
+// by Elasticsearch itself rather than by the Batcher (see Retrier).
+type Elasticsearch struct {
+	client *http.Client
+	index  *Template
+	// URL of the cluster (ex: https://localhost:9200)
+	URL string
+	// Index template (see Template), default is DefaultIndexTemplate
+	Index string
+	// Action is the bulk action, index (default) or create (data streams)
+	Action string
+	// Basic authentication
+	Username string
+	Password string
+	// APIKey is the base64 encoded API key (id:api_key)
+	APIKey string
+	// MaxBytes is the maximum size of a bulk request body
+	MaxBytes int
+	// Retry policy applied to failed items, the one of the Batcher if not set
+	Retry   RetryPolicy
+	Tag     string
+	TLS     TLSConfig
+	Timeout time.Duration
+}
+
+// Open checks the configuration and creates the HTTP client
+func (es *Elasticsearch) Open() (err error) {
+	if es.URL == "" {
+		return fmt.Errorf("Missing Elasticsearch URL")
+	}
+	es.URL = strings.TrimRight(es.URL, "/")
+	if es.Index == "" {
+		es.Index = DefaultIndexTemplate
+	}
+	if es.index, err = ParseTemplate(es.Index); err != nil {
+		return
+	}
+	switch es.Action {
+	case "":
+		es.Action = "index"
+	case "index", "create":
+	default:
+		return fmt.Errorf("Unsupported bulk action: %s", es.Action)
+	}
+	if es.MaxBytes <= 0 {
+		es.MaxBytes = 5 << 20
+	}
+	if es.Retry == (RetryPolicy{}) {
+		es.Retry = DefaultRetryPolicy
+	}
+	es.client, err = newHTTPClient(&es.TLS, es.Timeout)
+	return
+}
+
+// bulkResponse is the part of the _bulk response we need
+type bulkResponse struct {
+	Errors bool                                `json:"errors"`
+	Items  []map[string]bulkResponseItemResult `json:"items"`
+}
+
+type bulkResponseItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// bulk sends one bulk request and returns the events to retry and the events
+// which failed permanently
+func (es *Elasticsearch) bulk(ctx context.Context, events []*evtx.GoEvtxMap, body []byte) (retry, failed []*evtx.GoEvtxMap, err error) {
+	req, err := http.NewRequest("POST", es.URL+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, Permanent(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case es.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+es.APIKey)
+	case es.Username != "":
+		req.SetBasicAuth(es.Username, es.Password)
+	}
+
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can't connect to Elasticsearch %s: %s", es.URL, err)
+	}
+	defer resp.Body.Close()
+	if err = statusError(es.URL, resp); err != nil {
+		return
+	}
+
+	var br bulkResponse
+	if err = json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return nil, nil, fmt.Errorf("Bad bulk response from %s: %s", es.URL, err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	if !br.Errors {
+		return
+	}
+	if len(br.Items) != len(events) {
+		return nil, nil, fmt.Errorf("Bulk response from %s has %d items for %d events", es.URL, len(br.Items), len(events))
+	}
+	var lastErr json.RawMessage
+	for i, item := range br.Items {
+		for _, r := range item {
+			switch {
+			case r.Status >= 200 && r.Status <= 299:
+			case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+				retry = append(retry, events[i])
+				lastErr = r.Error
+			default:
+				failed = append(failed, events[i])
+				lastErr = r.Error
+			}
+		}
+	}
+	if len(retry) > 0 || len(failed) > 0 {
+		err = fmt.Errorf("%d items failed in bulk request, last error: %s", len(retry)+len(failed), lastErr)
+	}
+	return
+}
+
+// send sends events in bulk requests not exceeding MaxBytes
+func (es *Elasticsearch) send(ctx context.Context, events []*evtx.GoEvtxMap) (retry, failed []*evtx.GoEvtxMap, err error) {
+	body := new(bytes.Buffer)
+	start := 0
+	flush := func(end int) {
+		r, f, e := es.bulk(ctx, events[start:end], body.Bytes())
+		if e != nil && len(r) == 0 && len(f) == 0 {
+			// the whole request failed
+			if IsPermanent(e) {
+				f = events[start:end]
+			} else {
+				r = events[start:end]
+			}
+		}
+		retry, failed = append(retry, r...), append(failed, f...)
+		if e != nil {
+			err = e
+		}
+		body.Reset()
+		start = end
+	}
+
+	for i, e := range events {
+		doc := evtx.ToJSON(mark(e, es.Tag))
+		meta := map[string]map[string]string{
+			es.Action: {
+				"_index": es.index.Execute(e, cleanIndex),
+				"_id":    DocumentID(e),
+			},
+		}
+		line := append(evtx.ToJSON(meta), '\n')
+		if body.Len() > 0 && body.Len()+len(line)+len(doc)+1 > es.MaxBytes {
+			flush(i)
+		}
+		body.Write(line)
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+	if body.Len() > 0 {
+		flush(len(events))
+	}
+	return
+}
+
+// Write indexes the events, items failing with a retryable status are retried
+// according to the retry policy. Events which cannot be indexed are returned
+// in a DeliveryError.
+func (es *Elasticsearch) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	var failed []*evtx.GoEvtxMap
+	var rejected error
+	pending := events
+	err := es.Retry.Retry(ctx, func() error {
+		retry, f, err := es.send(ctx, pending)
+		if len(f) > 0 {
+			failed = append(failed, f...)
+			rejected = err
+		}
+		pending = retry
+		if len(retry) > 0 {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return &DeliveryError{append(failed, pending...), err}
+	}
+	if len(failed) > 0 {
+		return Permanent(&DeliveryError{failed, rejected})
+	}
+	return nil
+}
+
+// SetRetryPolicy implements Retrier
+func (es *Elasticsearch) SetRetryPolicy(p RetryPolicy) {
+	if es.Retry == (RetryPolicy{}) {
+		es.Retry = p
+	}
+}
+
+// Flush does nothing as Write is synchronous
+func (es *Elasticsearch) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close releases idle connections
+func (es *Elasticsearch) Close() error {
+	if es.client != nil {
+		es.client.CloseIdleConnections()
+	}
+	return nil
+}
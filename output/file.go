@@ -0,0 +1,295 @@
+package output
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+const (
+	// DefaultMaxOpen is the default maximum number of partitions kept open
+	DefaultMaxOpen = 64
+)
+
+var (
+	// FileEncodings are the available encodings of the events written to
+	// files, each event is encoded as a line
+	FileEncodings = map[string]func(e *evtx.GoEvtxMap) []byte{
+		"json": func(e *evtx.GoEvtxMap) []byte { return evtx.ToJSON(e) },
+		"kv":   func(e *evtx.GoEvtxMap) []byte { return []byte(KeyValue(e)) },
+	}
+
+	// replaces what could change the directory of a partition
+	pathCleaner = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "\x00", "")
+)
+
+// cleanPathPart makes a value safe to be used in a path
+func cleanPathPart(s string) string {
+	s = pathCleaner.Replace(s)
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// splitExt splits a file name on its first dot (ex: 4624.jsonl.gz gives 4624
+// and .jsonl.gz) so that sequence numbers are put before all the extensions
+func splitExt(path string) (string, string) {
+	dir, name := filepath.Split(path)
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return dir + name[:i], name[i:]
+	}
+	return path, ""
+}
+
+// countWriter counts the bytes written to the underlying writer
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (n int, err error) {
+	n, err = c.w.Write(b)
+	c.n += int64(n)
+	return
+}
+
+// partition is a file opened for a rendered path
+type partition struct {
+	path   string
+	file   *os.File
+	count  *countWriter
+	comp   io.WriteCloser
+	w      *bufio.Writer
+	opened time.Time
+	used   time.Time
+}
+
+func (p *partition) flush() error {
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+	switch c := p.comp.(type) {
+	case *gzip.Writer:
+		return c.Flush()
+	case *zstd.Encoder:
+		return c.Flush()
+	}
+	return nil
+}
+
+func (p *partition) close() error {
+	if err := p.w.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	if p.comp != nil {
+		if err := p.comp.Close(); err != nil {
+			p.file.Close()
+			return err
+		}
+	}
+	return p.file.Close()
+}
+
+// File writes events into files whose path is a template (see Template), for
+// example out/{Computer}/{Channel}/{yyyy-MM-dd}/{EventID}.jsonl.gz. Files are
+// rotated by size or age and existing files are never overwritten: a sequence
+// number is added to the name (ex: 4624-1.jsonl.gz) if the file already exists.
+type File struct {
+	path   *Template
+	encode func(e *evtx.GoEvtxMap) []byte
+	parts  map[string]*partition
+	// Path template of the files
+	Path string
+	// Encoding of the events (see FileEncodings), default is json
+	Encoding string
+	// Compression is gzip, zstd or none, if empty it is guessed from the
+	// extension of Path (.gz or .zst)
+	Compression string
+	// MaxSize is the size in bytes (approximate when compressed) at which
+	// a file is rotated, 0 means no limit
+	MaxSize int64
+	// MaxAge is the duration after which a file is rotated, 0 means no limit
+	MaxAge time.Duration
+	// MaxOpen is the maximum number of files kept open, the least recently
+	// used one is closed when it is reached, default is DefaultMaxOpen
+	MaxOpen int
+	Tag     string
+}
+
+// Open checks the configuration
+func (f *File) Open() (err error) {
+	if f.Path == "" {
+		return fmt.Errorf("Missing file output path")
+	}
+	if f.path, err = ParseTemplate(f.Path); err != nil {
+		return
+	}
+	if f.Encoding == "" {
+		f.Encoding = "json"
+	}
+	if f.encode = FileEncodings[f.Encoding]; f.encode == nil {
+		return fmt.Errorf("Unknown file encoding: %s", f.Encoding)
+	}
+	if f.Compression == "" {
+		switch {
+		case strings.HasSuffix(f.Path, ".gz"):
+			f.Compression = "gzip"
+		case strings.HasSuffix(f.Path, ".zst"):
+			f.Compression = "zstd"
+		default:
+			f.Compression = "none"
+		}
+	}
+	switch f.Compression {
+	case "gzip", "zstd", "none":
+	default:
+		return fmt.Errorf("Unknown file compression: %s", f.Compression)
+	}
+	if f.MaxOpen <= 0 {
+		f.MaxOpen = DefaultMaxOpen
+	}
+	f.parts = make(map[string]*partition)
+	return nil
+}
+
+// create creates a new file for a partition path
+func (f *File) create(path string) (p *partition, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	base, ext := splitExt(path)
+	var fd *os.File
+	name := path
+	for i := 1; ; i++ {
+		fd, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	p = &partition{path: path, file: fd, count: &countWriter{w: fd}, opened: now, used: now}
+	var w io.Writer = p.count
+	switch f.Compression {
+	case "gzip":
+		p.comp = gzip.NewWriter(p.count)
+	case "zstd":
+		if p.comp, err = zstd.NewWriter(p.count); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	if p.comp != nil {
+		w = p.comp
+	}
+	p.w = bufio.NewWriter(w)
+	return p, nil
+}
+
+// closeLRU closes the least recently used partition
+func (f *File) closeLRU() error {
+	var lru *partition
+	for _, p := range f.parts {
+		if lru == nil || p.used.Before(lru.used) {
+			lru = p
+		}
+	}
+	delete(f.parts, lru.path)
+	return lru.close()
+}
+
+// rotate closes the partitions too big or too old
+func (f *File) rotate() (err error) {
+	for path, p := range f.parts {
+		if (f.MaxSize > 0 && p.count.n >= f.MaxSize) || (f.MaxAge > 0 && time.Since(p.opened) >= f.MaxAge) {
+			delete(f.parts, path)
+			if cerr := p.close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return
+}
+
+// partition returns the opened partition for a path
+func (f *File) partition(path string) (p *partition, err error) {
+	if p = f.parts[path]; p != nil {
+		p.used = time.Now()
+		return
+	}
+	if len(f.parts) >= f.MaxOpen {
+		if err = f.closeLRU(); err != nil {
+			return
+		}
+	}
+	if p, err = f.create(path); err != nil {
+		return nil, fmt.Errorf("Failed to create file %s: %s", path, err)
+	}
+	f.parts[path] = p
+	return
+}
+
+// Write writes the events into their partitions
+func (f *File) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	for _, e := range events {
+		p, err := f.partition(filepath.FromSlash(f.path.Execute(e, cleanPathPart)))
+		if err != nil {
+			return err
+		}
+		m := mark(e, f.Tag)
+		p.w.Write(f.encode(&m))
+		if err := p.w.WriteByte('\n'); err != nil {
+			return fmt.Errorf("Failed to write to %s: %s", p.file.Name(), err)
+		}
+		if f.MaxSize > 0 && p.count.n+int64(p.w.Buffered()) >= f.MaxSize {
+			// flush to know the real size
+			if err := p.flush(); err != nil {
+				return err
+			}
+			if p.count.n >= f.MaxSize {
+				delete(f.parts, p.path)
+				if err := p.close(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return f.rotate()
+}
+
+// Flush writes the buffered data of the opened files and rotates them if
+// needed
+func (f *File) Flush(ctx context.Context) error {
+	for _, p := range f.parts {
+		if err := p.flush(); err != nil {
+			return fmt.Errorf("Failed to flush %s: %s", p.file.Name(), err)
+		}
+	}
+	return f.rotate()
+}
+
+// Close closes all the opened files
+func (f *File) Close() (err error) {
+	for path, p := range f.parts {
+		delete(f.parts, path)
+		if cerr := p.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
@@ -0,0 +1,295 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+const (
+	// DefaultFluentTag used when no tag is given
+	DefaultFluentTag = "evtx.{Channel}"
+)
+
+// characters not allowed in Fluent tags
+var fluentTagCleaner = strings.NewReplacer(" ", "_", "/", ".", "\\", ".")
+
+func cleanFluentTag(s string) string {
+	return strings.ToLower(fluentTagCleaner.Replace(s))
+}
+
+// Fluent sends events to Fluentd or Fluent Bit with the forward protocol.
+// Events of a batch are grouped by tag and sent as Forward or PackedForward
+// messages. With RequireAck, Write returns once the server acknowledged the
+// messages (at-least-once delivery).
+type Fluent struct {
+	conn   net.Conn
+	reader *msgpackDecoder
+	tls    *tls.Config
+	tag    *Template
+	// Network is tcp (default) or tls
+	Network string
+	Address string
+	// Tag template (see Template), default is DefaultFluentTag
+	Tag string
+	// Mode is forward or packed (PackedForward, default)
+	Mode string
+	// RequireAck asks the server to acknowledge every message
+	RequireAck bool
+	// SharedKey enables the handshake, Username and Password are optional
+	SharedKey string
+	Username  string
+	Password  string
+	// Hostname sent during the handshake
+	Hostname string
+	TLS      TLSConfig
+	Timeout  time.Duration
+}
+
+// Open checks the configuration and connects to the server
+func (f *Fluent) Open() (err error) {
+	switch f.Network {
+	case "":
+		f.Network = "tcp"
+	case "tcp":
+	case "tls":
+		if f.tls, err = f.TLS.Config(); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("Unknown Fluent network: %s", f.Network)
+	}
+	switch f.Mode {
+	case "":
+		f.Mode = "packed"
+	case "packed", "forward":
+	default:
+		return fmt.Errorf("Unknown Fluent forward mode: %s", f.Mode)
+	}
+	if f.Tag == "" {
+		f.Tag = DefaultFluentTag
+	}
+	if f.tag, err = ParseTemplate(f.Tag); err != nil {
+		return
+	}
+	if f.Hostname == "" {
+		f.Hostname, _ = os.Hostname()
+	}
+	if f.Timeout == 0 {
+		f.Timeout = 30 * time.Second
+	}
+	return f.connect()
+}
+
+func (f *Fluent) connect() (err error) {
+	dialer := &net.Dialer{Timeout: f.Timeout, KeepAlive: 30 * time.Second}
+	switch f.Network {
+	case "tls":
+		f.conn, err = tls.DialWithDialer(dialer, "tcp", f.Address, f.tls)
+	default:
+		f.conn, err = dialer.Dial("tcp", f.Address)
+	}
+	if err != nil {
+		f.conn = nil
+		return fmt.Errorf("Can't connect to Fluent server %s: %s", f.Address, err)
+	}
+	f.reader = &msgpackDecoder{bufio.NewReader(f.conn)}
+	if f.SharedKey != "" {
+		f.conn.SetDeadline(time.Now().Add(f.Timeout))
+		if err = f.handshake(); err != nil {
+			f.conn.Close()
+			f.conn = nil
+			return
+		}
+		f.conn.SetDeadline(time.Time{})
+	}
+	return nil
+}
+
+func sha512Hex(parts ...interface{}) string {
+	h := sha512.New()
+	for _, p := range parts {
+		switch v := p.(type) {
+		case string:
+			h.Write([]byte(v))
+		case []byte:
+			h.Write(v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handshake implements the shared key authentication of the forward protocol
+func (f *Fluent) handshake() error {
+	v, err := f.reader.decode()
+	if err != nil {
+		return fmt.Errorf("Fluent handshake failed reading HELO: %s", err)
+	}
+	helo, ok := v.([]interface{})
+	if !ok || len(helo) < 2 || helo[0] != "HELO" {
+		return Permanent(fmt.Errorf("Fluent handshake failed: unexpected HELO %v", v))
+	}
+	opts, _ := helo[1].(map[string]interface{})
+	nonce := toBytes(opts["nonce"])
+	auth := toBytes(opts["auth"])
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	userDigest := ""
+	if len(auth) > 0 {
+		userDigest = sha512Hex(auth, f.Username, f.Password)
+	}
+	ping := []interface{}{
+		"PING",
+		f.Hostname,
+		msgpackBin(salt),
+		sha512Hex(salt, f.Hostname, nonce, f.SharedKey),
+		f.Username,
+		userDigest,
+	}
+	if _, err := f.conn.Write(msgpackEncode(ping)); err != nil {
+		return err
+	}
+
+	if v, err = f.reader.decode(); err != nil {
+		return fmt.Errorf("Fluent handshake failed reading PONG: %s", err)
+	}
+	pong, ok := v.([]interface{})
+	if !ok || len(pong) < 5 || pong[0] != "PONG" {
+		return Permanent(fmt.Errorf("Fluent handshake failed: unexpected PONG %v", v))
+	}
+	if authOK, _ := pong[1].(bool); !authOK {
+		return Permanent(fmt.Errorf("Fluent authentication failed: %v", pong[2]))
+	}
+	serverName := fmt.Sprint(pong[3])
+	if pong[4] != sha512Hex(salt, serverName, nonce, f.SharedKey) {
+		return Permanent(fmt.Errorf("Fluent server %s failed to authenticate", serverName))
+	}
+	return nil
+}
+
+func toBytes(v interface{}) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	return nil
+}
+
+// entry encodes an event as a forward entry [time, record]
+func (f *Fluent) entry(e *evtx.GoEvtxMap) []interface{} {
+	return []interface{}{msgpackEventTime(eventTime(e)), *e}
+}
+
+// message builds a forward message for a tag
+func (f *Fluent) message(tag string, events []*evtx.GoEvtxMap) (msg []byte, chunk string) {
+	option := map[string]interface{}{"size": len(events)}
+	if f.RequireAck {
+		id := make([]byte, 16)
+		rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		option["chunk"] = chunk
+	}
+	if f.Mode == "forward" {
+		entries := make([]interface{}, 0, len(events))
+		for _, e := range events {
+			entries = append(entries, f.entry(e))
+		}
+		return msgpackEncode([]interface{}{tag, entries, option}), chunk
+	}
+	packed := &msgpackEncoder{}
+	for _, e := range events {
+		packed.encode(f.entry(e))
+	}
+	return msgpackEncode([]interface{}{tag, msgpackBin(packed.bytes()), option}), chunk
+}
+
+// Write sends the events grouped by tag, keeping their order within a tag
+func (f *Fluent) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if f.conn != nil && !f.RequireAck && closed(f.conn) {
+		// closed by the server while idle, without acks the first write
+		// would succeed and the events be lost
+		f.conn.Close()
+		f.conn = nil
+	}
+	if f.conn == nil {
+		if err := f.connect(); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(f.Timeout)
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	f.conn.SetDeadline(deadline)
+	defer func() {
+		if f.conn != nil {
+			f.conn.SetDeadline(time.Time{})
+		}
+	}()
+
+	tags := make([]string, 0)
+	byTag := make(map[string][]*evtx.GoEvtxMap)
+	for _, e := range events {
+		tag := f.tag.Execute(e, cleanFluentTag)
+		if _, ok := byTag[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		byTag[tag] = append(byTag[tag], e)
+	}
+
+	for _, tag := range tags {
+		msg, chunk := f.message(tag, byTag[tag])
+		if err := f.send(msg, chunk); err != nil {
+			f.conn.Close()
+			f.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fluent) send(msg []byte, chunk string) error {
+	if _, err := f.conn.Write(msg); err != nil {
+		return fmt.Errorf("Failed to write to Fluent server %s: %s", f.Address, err)
+	}
+	if chunk == "" {
+		return nil
+	}
+	v, err := f.reader.decode()
+	if err != nil {
+		return fmt.Errorf("Failed to read ack from Fluent server %s: %s", f.Address, err)
+	}
+	if m, ok := v.(map[string]interface{}); !ok || fmt.Sprint(m["ack"]) != chunk {
+		return fmt.Errorf("Unexpected ack from Fluent server %s: %v", f.Address, v)
+	}
+	return nil
+}
+
+// Flush does nothing as messages are written directly
+func (f *Fluent) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the connection
+func (f *Fluent) Close() error {
+	if f.conn != nil {
+		err := f.conn.Close()
+		f.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+var (
+	// paths of the System fields used by the sinks
+	computerPath = evtx.Path("/Event/System/Computer")
+	providerPath = evtx.Path("/Event/System/Provider/Name")
+	levelPath    = evtx.Path("/Event/System/Level")
+	// ProcessID is an attribute of Execution
+	executionPIDPath = evtx.Path("/Event/System/Execution/ProcessID")
+
+	// MetaFilePath is where the path of the file an event comes from can be
+	// stored, out of the Event node
+	MetaFilePath = evtx.Path("/Meta/File")
+)
+
+// Windows event levels
+const (
+	levelLogAlways = iota
+	levelCritical
+	levelError
+	levelWarning
+	levelInformation
+	levelVerbose
+)
+
+// eventLevel returns the level of an event, information if not found
+func eventLevel(e *evtx.GoEvtxMap) int64 {
+	if l, err := e.GetInt(&levelPath); err == nil {
+		return l
+	}
+	return levelInformation
+}
+
+// eventTime returns the creation time of an event or now if not found
+func eventTime(e *evtx.GoEvtxMap) time.Time {
+	if t, err := e.GetTime(&evtx.SystemTimePath); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// eventID returns the event ID or -1 if not found
+func eventID(e *evtx.GoEvtxMap) int64 {
+	if eid, err := e.GetInt(&evtx.EventIDPath); err == nil {
+		return eid
+	}
+	if eid, err := e.GetInt(&evtx.EventIDPath2); err == nil {
+		return eid
+	}
+	return -1
+}
+
+// getString returns the value at path as a string or an empty string, values
+// are converted to strings (see evtx.File.SetTypedValues)
+func getString(e *evtx.GoEvtxMap, path evtx.GoEvtxPath) string {
+	if v, err := e.Get(&path); err == nil {
+		switch (*v).(type) {
+		case evtx.GoEvtxMap, map[string]interface{}:
+			return ""
+		}
+		return ValueString(*v)
+	}
+	return ""
+}
+
+// ValueString converts a value of an event into a string
+func ValueString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case evtx.UTCTime:
+		return time.Time(s).UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return s.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return s.String()
+	case json.Marshaler, []interface{}, []string, map[string]interface{}, evtx.GoEvtxMap:
+		b, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Sprint(s)
+		}
+		if len(b) > 1 && b[0] == '"' {
+			if u, err := strconv.Unquote(string(b)); err == nil {
+				return u
+			}
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+// Flatten flattens an event into a map of dotted keys. The fields of the
+// Event node are put at the root (ex: System.Channel, EventData.Image), other
+// top level fields keep their name.
+// @e : event to flatten
+// @sep : separator used in keys
+// return map[string]interface{}
+func Flatten(e *evtx.GoEvtxMap, sep string) map[string]interface{} {
+	out := make(map[string]interface{})
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		var m map[string]interface{}
+		switch n := v.(type) {
+		case evtx.GoEvtxMap:
+			m = n
+		case map[string]interface{}:
+			m = n
+		default:
+			out[prefix] = v
+			return
+		}
+		for k, c := range m {
+			if prefix != "" {
+				k = prefix + sep + k
+			}
+			walk(k, c)
+		}
+	}
+	for k, v := range *e {
+		if k == "Event" {
+			walk("", v)
+			continue
+		}
+		walk(k, v)
+	}
+	return out
+}
+
+// sortedKeys returns the keys of a map sorted
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// KeyValue formats an event as a sequence of key="value" separated by spaces
+func KeyValue(e *evtx.GoEvtxMap) string {
+	flat := Flatten(e, ".")
+	b := strings.Builder{}
+	for i, k := range sortedKeys(flat) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(ValueString(flat[k])))
+	}
+	return b.String()
+}
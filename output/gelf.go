@@ -0,0 +1,324 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+const (
+	gelfVersion = "1.1"
+	// maximum number of chunks of a GELF message
+	gelfMaxChunks = 128
+	// size of a chunk header
+	gelfChunkHeader = 12
+)
+
+var (
+	// DefaultGELFShortFields are the fields added to short_message if present
+	DefaultGELFShortFields = []string{
+		"EventData/SubjectUserName",
+		"EventData/TargetUserName",
+		"EventData/IpAddress",
+		"EventData/Image",
+		"EventData/CommandLine",
+		"EventData/TargetFilename",
+		"EventData/DestinationIp",
+		"EventData/QueryName",
+		"EventData/ServiceName",
+	}
+
+	gelfInvalidChars = regexp.MustCompile(`[^\w\.\-]`)
+)
+
+// GELF sends events to Graylog using GELF over UDP (chunked and compressed),
+// TCP (null byte delimited) or HTTP
+type GELF struct {
+	conn   net.Conn
+	client *http.Client
+	tls    *tls.Config
+	short  []evtx.GoEvtxPath
+	// Network is one of udp (default), tcp, tls or http
+	Network string
+	// Address is host:port for udp, tcp and tls or the URL for http
+	Address string
+	// Compression used over UDP and HTTP: gzip (default for UDP), zlib or none
+	Compression string
+	// ChunkSize is the maximum size of a UDP datagram, default is 1420
+	ChunkSize int
+	// ShortFields are the paths (relative to Event) of the fields put in
+	// short_message, default is DefaultGELFShortFields
+	ShortFields []string
+	// Hostname used when the event has no Computer
+	Hostname string
+	Tag      string
+	TLS      TLSConfig
+	Timeout  time.Duration
+}
+
+// Open checks the configuration and connects to the server
+func (g *GELF) Open() (err error) {
+	g.Network = strings.ToLower(g.Network)
+	switch g.Network {
+	case "":
+		g.Network = "udp"
+	case "udp", "tcp":
+	case "tls":
+		if g.tls, err = g.TLS.Config(); err != nil {
+			return
+		}
+	case "http":
+		if g.client, err = newHTTPClient(&g.TLS, g.Timeout); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("Unknown GELF network: %s", g.Network)
+	}
+	switch g.Compression {
+	case "":
+		if g.Network == "udp" {
+			g.Compression = "gzip"
+		} else {
+			g.Compression = "none"
+		}
+	case "gzip", "zlib", "none":
+	default:
+		return fmt.Errorf("Unknown GELF compression: %s", g.Compression)
+	}
+	if g.Compression != "none" && (g.Network == "tcp" || g.Network == "tls") {
+		return fmt.Errorf("GELF over TCP does not support compression")
+	}
+	if g.ChunkSize <= gelfChunkHeader {
+		g.ChunkSize = 1420
+	}
+	if g.ShortFields == nil {
+		g.ShortFields = DefaultGELFShortFields
+	}
+	g.short = make([]evtx.GoEvtxPath, 0, len(g.ShortFields))
+	for _, f := range g.ShortFields {
+		g.short = append(g.short, append(evtx.GoEvtxPath{"Event"}, evtx.Path(f)...))
+	}
+	if g.Hostname == "" {
+		g.Hostname, _ = os.Hostname()
+	}
+	if g.Timeout == 0 {
+		g.Timeout = 10 * time.Second
+	}
+	if g.Network == "http" {
+		return nil
+	}
+	return g.connect()
+}
+
+func (g *GELF) connect() (err error) {
+	dialer := &net.Dialer{Timeout: g.Timeout}
+	switch g.Network {
+	case "tls":
+		g.conn, err = tls.DialWithDialer(dialer, "tcp", g.Address, g.tls)
+	default:
+		g.conn, err = dialer.Dial(g.Network, g.Address)
+	}
+	if err != nil {
+		g.conn = nil
+		return fmt.Errorf("Can't connect to GELF server %s: %s", g.Address, err)
+	}
+	return nil
+}
+
+// gelfKey makes a valid GELF additional field name
+func gelfKey(k string) string {
+	k = "_" + gelfInvalidChars.ReplaceAllString(k, "_")
+	if k == "_id" {
+		// reserved by GELF
+		return "_event_id"
+	}
+	return k
+}
+
+// Message builds the GELF message of an event
+func (g *GELF) Message(e *evtx.GoEvtxMap) map[string]interface{} {
+	m := mark(e, g.Tag)
+	host := getString(e, computerPath)
+	if host == "" {
+		host = g.Hostname
+	}
+	level := sevInformational
+	if sev, ok := syslogSeverities[eventLevel(e)]; ok {
+		level = sev
+	}
+
+	// short message
+	short := new(strings.Builder)
+	short.WriteString(getString(e, providerPath))
+	if eid := eventID(e); eid >= 0 {
+		fmt.Fprintf(short, " %d", eid)
+	}
+	for _, p := range g.short {
+		if v, err := e.Get(&p); err == nil {
+			if s := ValueString(*v); s != "" && s != "-" {
+				fmt.Fprintf(short, " %s=%s", p[len(p)-1], s)
+			}
+		}
+	}
+
+	msg := map[string]interface{}{
+		"version":       gelfVersion,
+		"host":          host,
+		"short_message": strings.TrimSpace(short.String()),
+		"timestamp":     float64(eventTime(e).UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         level,
+	}
+	for k, v := range Flatten(&m, "_") {
+		msg[gelfKey(k)] = ValueString(v)
+	}
+	return msg
+}
+
+func (g *GELF) compress(data []byte) []byte {
+	var buf bytes.Buffer
+	switch g.Compression {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	case "zlib":
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	default:
+		return data
+	}
+	return buf.Bytes()
+}
+
+// chunks splits a message into GELF chunks if it does not fit in a datagram
+func (g *GELF) chunks(data []byte) ([][]byte, error) {
+	if len(data) <= g.ChunkSize {
+		return [][]byte{data}, nil
+	}
+	size := g.ChunkSize - gelfChunkHeader
+	count := (len(data) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, Permanent(fmt.Errorf("GELF message too large: %d bytes", len(data)))
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		c := make([]byte, 0, gelfChunkHeader+end-i*size)
+		c = append(c, 0x1e, 0x0f)
+		c = append(c, id...)
+		c = append(c, byte(i), byte(count))
+		c = append(c, data[i*size:end]...)
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+func (g *GELF) writeConn(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if g.conn != nil && g.Network != "udp" && closed(g.conn) {
+		// closed by the server while idle, the first write would succeed
+		g.conn.Close()
+		g.conn = nil
+	}
+	if g.conn == nil {
+		if err := g.connect(); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(g.Timeout)
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	g.conn.SetWriteDeadline(deadline)
+
+	for _, e := range events {
+		data := evtx.ToJSON(g.Message(e))
+		var packets [][]byte
+		if g.Network == "udp" {
+			var err error
+			if packets, err = g.chunks(g.compress(data)); err != nil {
+				return err
+			}
+		} else {
+			packets = [][]byte{append(data, 0)}
+		}
+		for _, p := range packets {
+			if _, err := g.conn.Write(p); err != nil {
+				g.conn.Close()
+				g.conn = nil
+				return fmt.Errorf("Failed to write to GELF server %s: %s", g.Address, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (g *GELF) writeHTTP(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	for _, e := range events {
+		req, err := http.NewRequest("POST", g.Address, bytes.NewReader(g.compress(evtx.ToJSON(g.Message(e)))))
+		if err != nil {
+			return Permanent(err)
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		switch g.Compression {
+		case "gzip":
+			req.Header.Set("Content-Encoding", "gzip")
+		case "zlib":
+			req.Header.Set("Content-Encoding", "deflate")
+		}
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("Can't connect to GELF server %s: %s", g.Address, err)
+		}
+		err = statusError(g.Address, resp)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write sends the events, one GELF message per event
+func (g *GELF) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if g.Network == "http" {
+		return g.writeHTTP(ctx, events)
+	}
+	return g.writeConn(ctx, events)
+}
+
+// Flush does nothing as messages are written directly
+func (g *GELF) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the connection
+func (g *GELF) Close() error {
+	if g.client != nil {
+		g.client.CloseIdleConnections()
+	}
+	if g.conn != nil {
+		err := g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	return nil
+}
@@ -2,33 +2,213 @@ package output
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/0xrawsec/golang-evtx/evtx"
-	"github.com/0xrawsec/golang-utils/log"
 )
 
+const (
+	// DefaultHMACHeader is the header carrying the signature of the requests
+	DefaultHMACHeader = "X-Evtx-Signature"
+)
+
+// HttpJSON sends events as JSON documents POSTed to an URL, one request per
+// event (json format) or per batch (ndjson format, newline delimited JSON).
+// Non 2xx responses are failures, 429 and 5xx ones being retried after the
+// delay given by Retry-After if any. When HMACKey is set, requests are signed
+// with HMAC-SHA256 of the body, as sent, in the HMACHeader header
+// (sha256=<hex>) so that receivers can authenticate them.
 type HttpJSON struct {
 	client *http.Client
 	Url    string
-	Tag    string
+	// Format is json (default) or ndjson
+	Format string
+	// Compression of the request bodies: gzip or none (default)
+	Compression string
+	// Headers added to the requests
+	Headers  map[string]string
+	Username string
+	Password string
+	// BearerToken used for authentication if set
+	BearerToken string
+	HMACKey     string
+	// HMACHeader default is DefaultHMACHeader
+	HMACHeader string
+	// Proxy URL, proxy is taken from the environment (HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY) if empty
+	Proxy   string
+	Tag     string
+	TLS     TLSConfig
+	Timeout time.Duration
 }
 
-func (hj *HttpJSON) Open(url string) error {
-	hj.client = &http.Client{}
+// Open checks the configuration and initializes the HTTP client
+func (hj *HttpJSON) Open() (err error) {
+	if hj.Url == "" {
+		return fmt.Errorf("Missing URL for http output")
+	}
+	switch hj.Format {
+	case "":
+		hj.Format = "json"
+	case "json", "ndjson":
+	default:
+		return fmt.Errorf("Unknown http output format: %s", hj.Format)
+	}
+	switch hj.Compression {
+	case "":
+		hj.Compression = "none"
+	case "none", "gzip":
+	default:
+		return fmt.Errorf("Unknown http output compression: %s", hj.Compression)
+	}
+	if hj.HMACHeader == "" {
+		hj.HMACHeader = DefaultHMACHeader
+	}
+	if hj.client, err = newHTTPClient(&hj.TLS, hj.Timeout); err != nil {
+		return
+	}
+	if hj.Proxy != "" {
+		proxy, err := url.Parse(hj.Proxy)
+		if err != nil {
+			return fmt.Errorf("Bad http proxy URL %s: %s", hj.Proxy, err)
+		}
+		hj.client.Transport.(*http.Transport).Proxy = http.ProxyURL(proxy)
+	}
 	return nil
 }
 
-func (hj *HttpJSON) Request(message *evtx.GoEvtxMap) {
-	mark := evtx.GoEvtxMap{
-		"tags": hj.Tag,
+// Sign returns the value of the signature header of a body
+func (hj *HttpJSON) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(hj.HMACKey))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (hj *HttpJSON) post(ctx context.Context, body []byte, contentType string) error {
+	if hj.Compression == "gzip" {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		w.Write(body)
+		w.Close()
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest("POST", hj.Url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req = req.WithContext(ctx)
+	for k, v := range hj.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if hj.Compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	switch {
+	case hj.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+hj.BearerToken)
+	case hj.Username != "":
+		req.SetBasicAuth(hj.Username, hj.Password)
+	}
+	if hj.HMACKey != "" {
+		req.Header.Set(hj.HMACHeader, hj.Sign(body))
 	}
-	message.Add(mark)
-	req, err := http.NewRequest("POST", hj.Url, bytes.NewBuffer([]byte(evtx.ToJSON(message))))
-	req.Header.Set("Content-Type", "application/json")
 	resp, err := hj.client.Do(req)
 	if err != nil {
-		log.Errorf("Can 't connect to remote http log server: %s", hj.Url)
+		return fmt.Errorf("Can't connect to remote http log server %s: %s", hj.Url, err)
+	}
+	err = statusError(hj.Url, resp)
+	// the body must be read to reuse the connection
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return err
+}
+
+// Write POSTs the events one by one or as a single NDJSON body
+func (hj *HttpJSON) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if hj.Format == "ndjson" {
+		buf := new(bytes.Buffer)
+		for _, e := range events {
+			buf.Write(evtx.ToJSON(mark(e, hj.Tag)))
+			buf.WriteByte('\n')
+		}
+		return hj.post(ctx, buf.Bytes(), "application/x-ndjson")
+	}
+	for _, e := range events {
+		if err := hj.post(ctx, evtx.ToJSON(mark(e, hj.Tag)), "application/json"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush does nothing as events are not buffered
+func (hj *HttpJSON) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close releases idle connections
+func (hj *HttpJSON) Close() error {
+	if hj.client != nil {
+		hj.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// newHTTPClient creates an HTTP client with TLS settings and timeout
+func newHTTPClient(tlsConf *TLSConfig, timeout time.Duration) (*http.Client, error) {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConf != nil {
+		conf, err := tlsConf.Config()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = conf
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date
+func retryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// statusError returns an error for non 2xx responses, errors other than 429
+// and 5xx are permanent and the Retry-After header of the others is honoured
+func statusError(url string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("%s returned %s: %s", url, resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		if after := retryAfter(resp.Header.Get("Retry-After")); after > 0 {
+			return &RetryAfterError{err, after}
+		}
+		return err
 	}
-	defer resp.Body.Close()
+	return Permanent(err)
 }
@@ -2,53 +2,196 @@ package output
 
 import (
 	"context"
-	"github.com/segmentio/kafka-go"
-	"github.com/segmentio/kafka-go/snappy"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/0xrawsec/golang-evtx/evtx"
 	"github.com/0xrawsec/golang-utils/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+var (
+	// KafkaCompressions are the available compression codecs
+	KafkaCompressions = map[string]kafka.Compression{
+		"none":   0,
+		"gzip":   kafka.Gzip,
+		"snappy": kafka.Snappy,
+		"lz4":    kafka.Lz4,
+		"zstd":   kafka.Zstd,
+	}
+
+	// KafkaAcks are the available required acks
+	KafkaAcks = map[string]kafka.RequiredAcks{
+		"none":   kafka.RequireNone,
+		"leader": kafka.RequireOne,
+		"all":    kafka.RequireAll,
+	}
 )
 
+// Kafka sends events as JSON messages to a Kafka topic. Messages with the same
+// key (see Key) go to the same partition which keeps their order. In async
+// mode, Write does not wait for the messages to be delivered, delivery errors
+// are logged and reported by Close.
 type Kafka struct {
-	conn       *kafka.Writer
+	sync.Mutex
+	conn   *kafka.Writer
+	key    *Template
+	failed int
+	// BrokerURLs is a comma separated list of brokers (host:port)
 	BrokerURLs string
 	ClientID   string
 	Topic      string
-	Tag        string
+	// Key is the template of the message keys (see Template), for instance
+	// {Computer}/{Channel}, messages have no key if empty
+	Key string
+	// Compression is one of KafkaCompressions, default is snappy
+	Compression string
+	// RequiredAcks is one of KafkaAcks, default is all
+	RequiredAcks string
+	Async        bool
+	// UseTLS enables TLS, configured with TLS
+	UseTLS bool
+	TLS    TLSConfig
+	// SASL mechanism: plain, scram-sha-256 or scram-sha-512, none if empty
+	SASL     string
+	Username string
+	Password string
+	Tag      string
+	Timeout  time.Duration
 }
 
-func (k *Kafka) Open(url string) error {
-	dialer := &kafka.Dialer{
-		Timeout:  10 * time.Second,
-		ClientID: k.ClientID,
+func (k *Kafka) mechanism() (sasl.Mechanism, error) {
+	switch strings.ToLower(k.SASL) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: k.Username, Password: k.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, k.Username, k.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, k.Username, k.Password)
 	}
+	return nil, fmt.Errorf("Unknown Kafka SASL mechanism: %s", k.SASL)
+}
 
-	config := kafka.WriterConfig{
-		Brokers:          []string{k.BrokerURLs},
-		Topic:            k.Topic,
-		Balancer:         &kafka.LeastBytes{},
-		Dialer:           dialer,
-		WriteTimeout:     10 * time.Second,
-		ReadTimeout:      10 * time.Second,
-		CompressionCodec: snappy.NewCompressionCodec(),
+// Open checks the configuration and creates the Kafka writer
+func (k *Kafka) Open() (err error) {
+	brokers := make([]string, 0)
+	for _, b := range strings.Split(k.BrokerURLs, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	if len(brokers) == 0 || k.Topic == "" {
+		return fmt.Errorf("Kafka output needs a broker URL and a topic")
+	}
+	if k.Compression == "" {
+		k.Compression = "snappy"
+	}
+	codec, ok := KafkaCompressions[k.Compression]
+	if !ok {
+		return fmt.Errorf("Unknown Kafka compression: %s", k.Compression)
+	}
+	if k.RequiredAcks == "" {
+		k.RequiredAcks = "all"
+	}
+	acks, ok := KafkaAcks[k.RequiredAcks]
+	if !ok {
+		return fmt.Errorf("Unknown Kafka required acks: %s", k.RequiredAcks)
+	}
+	if k.Timeout == 0 {
+		k.Timeout = 10 * time.Second
+	}
+	k.key = nil
+	if k.Key != "" {
+		if k.key, err = ParseTemplate(k.Key); err != nil {
+			return
+		}
+	}
+
+	transport := &kafka.Transport{
+		ClientID:    k.ClientID,
+		DialTimeout: k.Timeout,
+	}
+	if k.UseTLS {
+		if transport.TLS, err = k.TLS.Config(); err != nil {
+			return
+		}
+	}
+	if transport.SASL, err = k.mechanism(); err != nil {
+		return
+	}
+
+	k.conn = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        k.Topic,
+		Balancer:     &kafka.LeastBytes{},
+		RequiredAcks: acks,
+		Async:        k.Async,
+		Compression:  codec,
+		WriteTimeout: k.Timeout,
+		ReadTimeout:  k.Timeout,
+		Transport:    transport,
+	}
+	if k.key != nil {
+		// same partitioning as the Java client so that keys are consistent
+		k.conn.Balancer = &kafka.Murmur2Balancer{Consistent: true}
+	}
+	if k.Async {
+		k.conn.Completion = func(messages []kafka.Message, err error) {
+			if err != nil {
+				log.Errorf("Failed to deliver %d messages to Kafka topic %s: %s", len(messages), k.Topic, err)
+				k.Lock()
+				k.failed += len(messages)
+				k.Unlock()
+			}
+		}
 	}
-	k.conn = kafka.NewWriter(config)
 	return nil
 }
 
-func (k *Kafka) Request(message *evtx.GoEvtxMap) {
-	mark := evtx.GoEvtxMap{
-		"tags": k.Tag,
+// Write sends the events, it returns when the messages are written unless in
+// async mode
+func (k *Kafka) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		var key []byte
+		if k.key != nil {
+			key = []byte(k.key.Execute(e, nil))
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   key,
+			Value: evtx.ToJSON(mark(e, k.Tag)),
+			Time:  time.Now(),
+		})
 	}
-	message.Add(mark)
-	body := kafka.Message{
-		Key:   nil,
-		Value: evtx.ToJSON(message),
-		Time:  time.Now(),
+	return k.conn.WriteMessages(ctx, msgs...)
+}
+
+// Flush does nothing as Write is synchronous or errors are reported on Close
+// in async mode
+func (k *Kafka) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close delivers the pending messages and closes the Kafka writer, in async
+// mode an error is returned if messages were not delivered
+func (k *Kafka) Close() error {
+	if k.conn == nil {
+		return nil
 	}
-	err := k.conn.WriteMessages(context.Background(), body)
-	if err != nil {
-		log.Error(err)
+	err := k.conn.Close()
+	k.conn = nil
+	k.Lock()
+	defer k.Unlock()
+	if err == nil && k.failed > 0 {
+		err = fmt.Errorf("%d messages were not delivered to Kafka topic %s", k.failed, k.Topic)
 	}
+	return err
 }
@@ -0,0 +1,256 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+var (
+	// LokiLabels are the fields which can be used as stream labels, they have
+	// a low cardinality
+	LokiLabels = map[string]evtx.GoEvtxPath{
+		"computer": computerPath,
+		"channel":  evtx.ChannelPath,
+		"provider": providerPath,
+		"level":    levelPath,
+	}
+
+	// DefaultLokiLabels used when no label is given
+	DefaultLokiLabels = []string{"computer", "channel"}
+)
+
+// Loki sends events to Grafana Loki push API. Events are grouped into streams
+// by labels, each event being a JSON log line timestamped with the event time.
+type Loki struct {
+	client *http.Client
+	// URL of Loki (ex: http://loki:3100), the push path is added
+	URL string
+	// Labels are the names of the labels (see LokiLabels), default is
+	// DefaultLokiLabels
+	Labels []string
+	// Static labels added to all the streams
+	StaticLabels map[string]string
+	// Encoding of the requests: protobuf (snappy compressed, default) or json
+	Encoding string
+	// TenantID sent in X-Scope-OrgID header
+	TenantID string
+	Username string
+	Password string
+	// BearerToken used for authentication if set
+	BearerToken string
+	Tag         string
+	TLS         TLSConfig
+	Timeout     time.Duration
+}
+
+// Open checks the configuration and creates the HTTP client
+func (l *Loki) Open() (err error) {
+	if l.URL == "" {
+		return fmt.Errorf("Missing Loki URL")
+	}
+	l.URL = strings.TrimRight(l.URL, "/")
+	if !strings.HasSuffix(l.URL, "/loki/api/v1/push") {
+		l.URL += "/loki/api/v1/push"
+	}
+	if l.Labels == nil {
+		l.Labels = DefaultLokiLabels
+	}
+	for _, name := range l.Labels {
+		if _, ok := LokiLabels[name]; !ok {
+			return fmt.Errorf("Unknown Loki label: %s", name)
+		}
+	}
+	switch l.Encoding {
+	case "":
+		l.Encoding = "protobuf"
+	case "protobuf", "json":
+	default:
+		return fmt.Errorf("Unknown Loki encoding: %s", l.Encoding)
+	}
+	l.client, err = newHTTPClient(&l.TLS, l.Timeout)
+	return
+}
+
+// lokiEntry is a log line of a stream
+type lokiEntry struct {
+	ts   time.Time
+	line string
+}
+
+// lokiStream is a set of entries sharing the same labels
+type lokiStream struct {
+	labels map[string]string
+	key    string
+	// entries are kept in order
+	entries []lokiEntry
+}
+
+// streams groups the events into streams, entries of a stream are ordered
+// by timestamp (events having the same timestamp keep their order)
+func (l *Loki) streams(events []*evtx.GoEvtxMap) []*lokiStream {
+	streams := make([]*lokiStream, 0)
+	byKey := make(map[string]*lokiStream)
+	for _, e := range events {
+		labels := make(map[string]string, len(l.Labels)+len(l.StaticLabels))
+		for k, v := range l.StaticLabels {
+			labels[k] = v
+		}
+		for _, name := range l.Labels {
+			if v := getString(e, LokiLabels[name]); v != "" {
+				labels[name] = v
+			}
+		}
+		key := lokiLabelString(labels)
+		s, ok := byKey[key]
+		if !ok {
+			s = &lokiStream{labels: labels, key: key}
+			byKey[key] = s
+			streams = append(streams, s)
+		}
+		s.entries = append(s.entries, lokiEntry{eventTime(e), string(evtx.ToJSON(mark(e, l.Tag)))})
+	}
+	for _, s := range streams {
+		sort.SliceStable(s.entries, func(i, j int) bool { return s.entries[i].ts.Before(s.entries[j].ts) })
+	}
+	return streams
+}
+
+// lokiLabelString formats labels as expected by Loki: {a="b", c="d"}
+func lokiLabelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	b := strings.Builder{}
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(n)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[n]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// encodeJSON encodes the streams as a JSON push request
+func (l *Loki) encodeJSON(streams []*lokiStream) []byte {
+	req := make([]interface{}, 0, len(streams))
+	for _, s := range streams {
+		values := make([][2]string, 0, len(s.entries))
+		for _, e := range s.entries {
+			values = append(values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line})
+		}
+		req = append(req, map[string]interface{}{"stream": s.labels, "values": values})
+	}
+	return evtx.ToJSON(map[string]interface{}{"streams": req})
+}
+
+// protobuf encoding helpers
+func pbVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func pbBytes(b []byte, field int, data []byte) []byte {
+	b = pbVarint(b, uint64(field<<3|2))
+	b = pbVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func pbUint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = pbVarint(b, uint64(field<<3))
+	return pbVarint(b, v)
+}
+
+// encodeProtobuf encodes the streams as a snappy compressed protobuf push
+// request (logproto.PushRequest)
+func (l *Loki) encodeProtobuf(streams []*lokiStream) []byte {
+	var req []byte
+	for _, s := range streams {
+		// StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+		stream := pbBytes(nil, 1, []byte(s.key))
+		for _, e := range s.entries {
+			// Timestamp { int64 seconds = 1; int32 nanos = 2; }
+			ts := pbUint(nil, 1, uint64(e.ts.Unix()))
+			ts = pbUint(ts, 2, uint64(e.ts.Nanosecond()))
+			// EntryAdapter { Timestamp timestamp = 1; string line = 2; }
+			entry := pbBytes(nil, 1, ts)
+			entry = pbBytes(entry, 2, []byte(e.line))
+			stream = pbBytes(stream, 2, entry)
+		}
+		// PushRequest { repeated StreamAdapter streams = 1; }
+		req = pbBytes(req, 1, stream)
+	}
+	return snappy.Encode(nil, req)
+}
+
+// Write pushes the events in a single request
+func (l *Loki) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if len(events) == 0 {
+		return nil
+	}
+	streams := l.streams(events)
+	var body []byte
+	contentType := "application/json"
+	if l.Encoding == "protobuf" {
+		body = l.encodeProtobuf(streams)
+		contentType = "application/x-protobuf"
+	} else {
+		body = l.encodeJSON(streams)
+	}
+
+	req, err := http.NewRequest("POST", l.URL, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	if l.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.TenantID)
+	}
+	switch {
+	case l.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+l.BearerToken)
+	case l.Username != "":
+		req.SetBasicAuth(l.Username, l.Password)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Can't connect to Loki %s: %s", l.URL, err)
+	}
+	defer resp.Body.Close()
+	return statusError(l.URL, resp)
+}
+
+// Flush does nothing as Write is synchronous
+func (l *Loki) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close releases idle connections
+func (l *Loki) Close() error {
+	if l.client != nil {
+		l.client.CloseIdleConnections()
+	}
+	return nil
+}
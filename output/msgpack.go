@@ -0,0 +1,359 @@
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// Minimal MessagePack encoder and decoder, enough for the Fluent forward
+// protocol. Decoded maps are map[string]interface{}, strings and binaries are
+// decoded as string and []byte, integers as int64 and floats as float64.
+
+// msgpackBin forces a byte slice to be encoded as bin
+type msgpackBin []byte
+
+// msgpackEventTime is the Fluent EventTime extension (type 0)
+type msgpackEventTime time.Time
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (m *msgpackEncoder) bytes() []byte {
+	return m.buf
+}
+
+func (m *msgpackEncoder) byte1(b byte) {
+	m.buf = append(m.buf, b)
+}
+
+func (m *msgpackEncoder) uint16(t byte, v uint16) {
+	m.buf = append(m.buf, t, byte(v>>8), byte(v))
+}
+
+func (m *msgpackEncoder) uint32(t byte, v uint32) {
+	m.buf = append(m.buf, t)
+	m.buf = append(m.buf, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(m.buf[len(m.buf)-4:], v)
+}
+
+func (m *msgpackEncoder) uint64(t byte, v uint64) {
+	m.buf = append(m.buf, t)
+	m.buf = append(m.buf, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(m.buf[len(m.buf)-8:], v)
+}
+
+func (m *msgpackEncoder) int(v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		m.byte1(byte(v))
+	case v < 0 && v >= -32:
+		m.byte1(byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		m.buf = append(m.buf, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		m.uint16(0xd1, uint16(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		m.uint32(0xd2, uint32(v))
+	default:
+		m.uint64(0xd3, uint64(v))
+	}
+}
+
+func (m *msgpackEncoder) str(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		m.byte1(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		m.uint16(0xda, uint16(n))
+	default:
+		m.uint32(0xdb, uint32(n))
+	}
+	m.buf = append(m.buf, s...)
+}
+
+func (m *msgpackEncoder) bin(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		m.uint16(0xc5, uint16(n))
+	default:
+		m.uint32(0xc6, uint32(n))
+	}
+	m.buf = append(m.buf, b...)
+}
+
+func (m *msgpackEncoder) arrayHeader(n int) {
+	switch {
+	case n < 16:
+		m.byte1(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		m.uint16(0xdc, uint16(n))
+	default:
+		m.uint32(0xdd, uint32(n))
+	}
+}
+
+func (m *msgpackEncoder) mapHeader(n int) {
+	switch {
+	case n < 16:
+		m.byte1(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		m.uint16(0xde, uint16(n))
+	default:
+		m.uint32(0xdf, uint32(n))
+	}
+}
+
+func (m *msgpackEncoder) stringMap(v map[string]interface{}) {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	m.mapHeader(len(keys))
+	for _, k := range keys {
+		m.str(k)
+		m.encode(v[k])
+	}
+}
+
+// encode encodes a value, unknown types are encoded as their string value
+func (m *msgpackEncoder) encode(v interface{}) {
+	switch t := v.(type) {
+	case nil:
+		m.byte1(0xc0)
+	case bool:
+		if t {
+			m.byte1(0xc3)
+		} else {
+			m.byte1(0xc2)
+		}
+	case int:
+		m.int(int64(t))
+	case int8:
+		m.int(int64(t))
+	case int16:
+		m.int(int64(t))
+	case int32:
+		m.int(int64(t))
+	case int64:
+		m.int(t)
+	case uint8:
+		m.int(int64(t))
+	case uint16:
+		m.int(int64(t))
+	case uint32:
+		m.int(int64(t))
+	case uint64:
+		if t > math.MaxInt64 {
+			m.uint64(0xcf, t)
+		} else {
+			m.int(int64(t))
+		}
+	case float32:
+		m.uint32(0xca, math.Float32bits(t))
+	case float64:
+		m.uint64(0xcb, math.Float64bits(t))
+	case string:
+		m.str(t)
+	case msgpackBin:
+		m.bin(t)
+	case msgpackEventTime:
+		tt := time.Time(t)
+		m.buf = append(m.buf, 0xd7, 0x00)
+		m.buf = append(m.buf, make([]byte, 8)...)
+		binary.BigEndian.PutUint32(m.buf[len(m.buf)-8:], uint32(tt.Unix()))
+		binary.BigEndian.PutUint32(m.buf[len(m.buf)-4:], uint32(tt.Nanosecond()))
+	case []interface{}:
+		m.arrayHeader(len(t))
+		for _, e := range t {
+			m.encode(e)
+		}
+	case []string:
+		m.arrayHeader(len(t))
+		for _, e := range t {
+			m.str(e)
+		}
+	case map[string]interface{}:
+		m.stringMap(t)
+	case evtx.GoEvtxMap:
+		m.stringMap(t)
+	case *evtx.GoEvtxMap:
+		m.stringMap(*t)
+	default:
+		// slices of other types
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+			m.arrayHeader(rv.Len())
+			for i := 0; i < rv.Len(); i++ {
+				m.encode(rv.Index(i).Interface())
+			}
+			return
+		}
+		m.str(ValueString(v))
+	}
+}
+
+// msgpackEncode encodes values one after the other
+func msgpackEncode(values ...interface{}) []byte {
+	m := &msgpackEncoder{}
+	for _, v := range values {
+		m.encode(v)
+	}
+	return m.bytes()
+}
+
+type msgpackDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) array(n int) ([]interface{}, error) {
+	a := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) smap(n int) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		switch ks := k.(type) {
+		case string:
+			m[ks] = v
+		case []byte:
+			m[string(ks)] = v
+		default:
+			m[fmt.Sprint(ks)] = v
+		}
+	}
+	return m, nil
+}
+
+// decode decodes the next value
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.smap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.array(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		b, err := d.read(int(c & 0x1f))
+		return string(b), err
+	}
+
+	var n uint64
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		if n, err = d.uint(1 << (c - 0xc4)); err != nil {
+			return nil, err
+		}
+		return d.read(int(n))
+	case 0xca:
+		n, err = d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err = d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err = d.uint(1 << (c - 0xcc))
+		return int64(n), err
+	case 0xd0:
+		n, err = d.uint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err = d.uint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err = d.uint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err = d.uint(8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		// fixext, type followed by data
+		_, err = d.read(1 + (1 << (c - 0xd4)))
+		return nil, err
+	case 0xd9, 0xda, 0xdb:
+		if n, err = d.uint(1 << (c - 0xd9)); err != nil {
+			return nil, err
+		}
+		b, err := d.read(int(n))
+		return string(b), err
+	case 0xdc, 0xdd:
+		if n, err = d.uint(2 << (c - 0xdc)); err != nil {
+			return nil, err
+		}
+		return d.array(int(n))
+	case 0xde, 0xdf:
+		if n, err = d.uint(2 << (c - 0xde)); err != nil {
+			return nil, err
+		}
+		return d.smap(int(n))
+	case 0xc7, 0xc8, 0xc9:
+		// ext with length
+		if n, err = d.uint(1 << (c - 0xc7)); err != nil {
+			return nil, err
+		}
+		_, err = d.read(int(n) + 1)
+		return nil, err
+	}
+	return nil, fmt.Errorf("Unsupported msgpack type 0x%02x", c)
+}
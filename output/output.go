@@ -1,8 +1,63 @@
+/*
+Package output implements the sinks events can be sent to (HTTP, TCP, Kafka
+...).
+
+All the sinks implement the Output interface. Write delivers a batch of events
+and returns an error if they could not be delivered, so that no event is lost
+silently. A sink can be wrapped into a Batcher to group events into batches
+(by size and time) and to retry failed batches with an exponential backoff.
+As Batcher.Write blocks while a batch is being delivered, a slow or unavailable
+sink slows down the reader instead of piling up events in memory.
+*/
 package output
 
-import "github.com/0xrawsec/golang-evtx/evtx"
+import (
+	"context"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
 
+// Output interface implemented by all the sinks
 type Output interface {
-	Open(url string) error
-	Request(message *evtx.GoEvtxMap)
+	// Open initializes the sink (connections, clients ...)
+	Open() error
+	// Write delivers events to the sink, a nil error means the events were
+	// accepted by the sink
+	Write(ctx context.Context, events []*evtx.GoEvtxMap) error
+	// Flush delivers the events buffered by the sink if any
+	Flush(ctx context.Context) error
+	// Close flushes and releases the resources of the sink
+	Close() error
+}
+
+// Notifier is implemented by the outputs buffering events (Batcher,
+// DiskQueue), a successful Write does not mean the events are delivered. The
+// function given to Notify is called with the numbers of events delivered and
+// lost every time a batch is processed, Notify must be called before Open.
+type Notifier interface {
+	Notify(f func(delivered, failed int))
+}
+
+// TypedOutput is implemented by the outputs expecting the events to be decoded
+// with typed values (see evtx.File.SetTypedValues)
+type TypedOutput interface {
+	TypedValues() bool
+}
+
+// TypedValues returns true if the output expects typed values
+func TypedValues(o Output) bool {
+	t, ok := o.(TypedOutput)
+	return ok && t.TypedValues()
+}
+
+// mark returns a shallow copy of the event with the tag field set. The event
+// itself is not modified so that it can be written several times (retries,
+// several sinks).
+func mark(e *evtx.GoEvtxMap, tag string) evtx.GoEvtxMap {
+	m := make(evtx.GoEvtxMap, len(*e)+1)
+	for k, v := range *e {
+		m[k] = v
+	}
+	m["tags"] = tag
+	return m
 }
@@ -0,0 +1,1166 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// flaky output failing the first writes
+type flaky struct {
+	sync.Mutex
+	failures int
+	poison   bool
+	written  []*evtx.GoEvtxMap
+	writes   int
+}
+
+func (f *flaky) Open() error                     { return nil }
+func (f *flaky) Flush(ctx context.Context) error { return nil }
+func (f *flaky) Close() error                    { return nil }
+
+func (f *flaky) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	f.Lock()
+	defer f.Unlock()
+	f.writes++
+	if f.poison {
+		for _, e := range events {
+			if _, ok := (*e)["poison"]; ok {
+				return errors.New("malformed event")
+			}
+		}
+	}
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("collector down")
+	}
+	f.written = append(f.written, events...)
+	return nil
+}
+
+func events(n int) (out []*evtx.GoEvtxMap) {
+	for i := 0; i < n; i++ {
+		out = append(out, &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{"i": i}})
+	}
+	return
+}
+
+func TestBatcherRetry(t *testing.T) {
+	f := &flaky{failures: 2}
+	b := NewBatcher(f, BatchOptions{Size: 3, Retry: RetryPolicy{MaxRetries: 3, MinBackoff: time.Millisecond}})
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(context.Background(), events(4)); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.written) != 3 || f.writes != 3 {
+		t.Errorf("Unexpected state: written=%d writes=%d", len(f.written), f.writes)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.written) != 4 {
+		t.Errorf("Last event not flushed on close")
+	}
+}
+
+func TestBatcherGiveUp(t *testing.T) {
+	f := &flaky{failures: 10}
+	b := NewBatcher(f, BatchOptions{Size: 2, Retry: RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond}})
+	b.Open()
+	err := b.Write(context.Background(), events(2))
+	if de, ok := err.(*DeliveryError); !ok || len(de.Events) != 2 {
+		t.Errorf("Expected a delivery error with 2 events, got: %v", err)
+	}
+	if f.writes != 2 {
+		t.Errorf("Expected 2 writes, got %d", f.writes)
+	}
+}
+
+func TestRouterStats(t *testing.T) {
+	f := &flaky{failures: 1}
+	b := NewBatcher(f, BatchOptions{Size: 3, Retry: RetryPolicy{MaxRetries: 0, MinBackoff: time.Millisecond}})
+	r := NewRouter(&Route{Name: "flaky", Output: b})
+	if err := r.Open(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// buffered events are not delivered yet
+	r.Write(ctx, events(2))
+	if s := r.Stats()[0]; s.Matched != 2 || s.Delivered != 0 || s.Failed != 0 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+	// the first batch fails
+	if err := r.Write(ctx, events(2)); err == nil {
+		t.Error("Expected a delivery error")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Stats()[0]; s.Matched != 4 || s.Delivered != 1 || s.Failed != 3 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	calls := 0
+	err := DefaultRetryPolicy.Retry(context.Background(), func() error {
+		calls++
+		return Permanent(errors.New("bad request"))
+	})
+	if !IsPermanent(err) || calls != 1 {
+		t.Errorf("Permanent error must not be retried")
+	}
+}
+
+func TestMark(t *testing.T) {
+	e := events(1)[0]
+	mark(e, "foo")
+	mark(e, "bar")
+	if _, ok := (*e)["tags"]; ok {
+		t.Errorf("Event must not be modified")
+	}
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &flaky{failures: 3, poison: true}
+	opts := QueueOptions{
+		Dir:          dir,
+		SegmentSize:  256,
+		BatchSize:    4,
+		MaxAttempts:  2,
+		Retry:        RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+		DrainTimeout: 5 * time.Second,
+	}
+	// a queue which was never opened must close like a Batcher
+	if err := NewDiskQueue(&flaky{}, opts).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewDiskQueue(f, opts)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	in := events(20)
+	(*in[7])["poison"] = true
+	for _, e := range in {
+		if err := q.Write(context.Background(), []*evtx.GoEvtxMap{e}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.written) != 19 {
+		t.Fatalf("Expected 19 events delivered, got %d", len(f.written))
+	}
+	// order must be kept
+	prev := -1.0
+	for _, e := range f.written {
+		i := (*e)["Event"].(map[string]interface{})["i"].(float64)
+		if i <= prev {
+			t.Errorf("Events out of order")
+		}
+		prev = i
+	}
+	dl, err := ioutil.ReadFile(filepath.Join(dir, "deadletter.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(dl), "\n") != 1 || !strings.Contains(string(dl), "malformed event") {
+		t.Errorf("Unexpected dead-letter content: %s", dl)
+	}
+}
+
+func TestDiskQueuePoison(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a poison event alone in its batch, nothing delivered around it
+	f := &flaky{poison: true}
+	opts := QueueOptions{
+		Dir:          dir,
+		BatchSize:    1,
+		MaxAttempts:  2,
+		MaxRetryAge:  50 * time.Millisecond,
+		Retry:        RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+		DrainTimeout: 5 * time.Second,
+	}
+	q := NewDiskQueue(f, opts)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	in := events(2)
+	(*in[0])["poison"] = true
+	for _, e := range in {
+		if err := q.Write(context.Background(), []*evtx.GoEvtxMap{e}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.written) != 1 {
+		t.Errorf("Events queued behind the poison event must be delivered, got %d", len(f.written))
+	}
+	dl, err := ioutil.ReadFile(filepath.Join(dir, "deadletter.jsonl"))
+	if err != nil || strings.Count(string(dl), "\n") != 1 {
+		t.Errorf("Unexpected dead-letter content: %s (%v)", dl, err)
+	}
+}
+
+func TestDiskQueueRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// sink down during the whole first run
+	down := &flaky{failures: 1 << 30}
+	opts := QueueOptions{Dir: dir, BatchSize: 10, MaxAttempts: 1, Retry: RetryPolicy{MinBackoff: time.Millisecond}}
+	q := NewDiskQueue(down, opts)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	q.Write(context.Background(), events(5))
+	q.Close()
+
+	up := &flaky{}
+	opts.DrainTimeout = 5 * time.Second
+	q = NewDiskQueue(up, opts)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	if len(up.written) != 5 {
+		t.Errorf("Expected 5 events replayed after restart, got %d", len(up.written))
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	s := &Syslog{Network: "tcp", Address: ln.Addr().String(), Facility: SyslogFacilities["local0"]}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	e := &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{"System": evtx.GoEvtxMap{
+		"Computer":    "DC01",
+		"Channel":     "Security",
+		"EventID":     "4625",
+		"Level":       "2",
+		"TimeCreated": evtx.GoEvtxMap{"SystemTime": "2020-01-02T03:04:05.123456Z"},
+	}}}
+	if err := s.Write(context.Background(), []*evtx.GoEvtxMap{e}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	msg := <-received
+	i := strings.Index(msg, " ")
+	if n, err := strconv.Atoi(msg[:i]); err != nil || n != len(msg)-i-1 {
+		t.Errorf("Bad octet-counting framing: %s", msg)
+	}
+	prefix := "<131>1 2020-01-02T03:04:05.123456Z DC01 evtx - 4625 [evtx@32473 channel=\"Security\""
+	if !strings.HasPrefix(msg[i+1:], prefix) {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+}
+
+func TestSyslogIdleClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the first connection is closed by the server once the first message is
+	// read, the second one is read until closed
+	received := make(chan string)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			if i == 0 {
+				size, _ := r.ReadString(' ')
+				n, _ := strconv.Atoi(strings.TrimSpace(size))
+				r.Discard(n)
+				conn.Close()
+				received <- ""
+				continue
+			}
+			data, _ := ioutil.ReadAll(r)
+			conn.Close()
+			received <- string(data)
+		}
+	}()
+
+	s := &Syslog{Network: "tcp", Address: ln.Addr().String()}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(context.Background(), events(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	// gives time to the FIN to arrive
+	time.Sleep(20 * time.Millisecond)
+	if err := s.Write(context.Background(), events(1)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	select {
+	case msg := <-received:
+		if msg == "" {
+			t.Error("Event written on the connection closed by the server")
+		}
+	case <-time.After(time.Second):
+		t.Error("Event written on the connection closed by the server")
+	}
+}
+
+func TestTcpReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the first connection is dropped after the first event, like a restarting
+	// server, the second one is read until closed
+	received := make(chan []byte)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			if i == 0 {
+				var size [4]byte
+				r.Read(size[:])
+				r.Discard(int(size[0])<<24 | int(size[1])<<16 | int(size[2])<<8 | int(size[3]))
+				conn.Close()
+				received <- nil
+				continue
+			}
+			data, _ := ioutil.ReadAll(r)
+			conn.Close()
+			received <- data
+		}
+	}()
+
+	tj := &TcpJSON{
+		Address:   " , " + ln.Addr().String(),
+		Framing:   "length",
+		Reconnect: RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+	}
+	if err := tj.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tj.Write(context.Background(), events(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	if err := tj.Write(context.Background(), events(1)); err == nil {
+		t.Error("Write on a closed connection must fail")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := tj.Write(context.Background(), events(2)); err != nil {
+		t.Fatalf("Failed to reconnect: %s", err)
+	}
+	tj.Close()
+
+	data := <-received
+	for n := 0; n < 2; n++ {
+		if len(data) < 4 {
+			t.Fatalf("Missing event %d", n)
+		}
+		size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		var m map[string]interface{}
+		if err := json.Unmarshal(data[4:4+size], &m); err != nil {
+			t.Errorf("Bad length-prefixed framing: %s", err)
+		}
+		data = data[4+size:]
+	}
+	if len(data) != 0 {
+		t.Errorf("Unexpected trailing data: %q", data)
+	}
+}
+
+func TestHttpNDJSON(t *testing.T) {
+	var calls int
+	var lines []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls == 1 {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		hj := &HttpJSON{HMACKey: "secret"}
+		if sig := r.Header.Get(DefaultHMACHeader); sig != hj.Sign(body) {
+			t.Errorf("Bad signature: %s", sig)
+		}
+		if u, p, _ := r.BasicAuth(); u != "evtx" || p != "pass" {
+			t.Errorf("Bad credentials: %s:%s", u, p)
+		}
+		if r.Header.Get("X-Source") != "test" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Unexpected headers: %v", r.Header)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(gz)
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}))
+	defer srv.Close()
+
+	hj := &HttpJSON{
+		Url:         srv.URL,
+		Format:      "ndjson",
+		Compression: "gzip",
+		Headers:     map[string]string{"X-Source": "test"},
+		Username:    "evtx",
+		Password:    "pass",
+		HMACKey:     "secret",
+	}
+	if err := hj.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer hj.Close()
+	err := hj.Write(context.Background(), events(3))
+	if err == nil || IsPermanent(err) || RetryAfter(err) != 3*time.Second {
+		t.Errorf("Expected a retryable error with Retry-After, got %v", err)
+	}
+	if d := DefaultRetryPolicy.Delay(0, err); d != 3*time.Second {
+		t.Errorf("Retry-After not honoured: %s", d)
+	}
+	if d := (RetryPolicy{MaxBackoff: time.Second}).Delay(0, err); d != time.Second {
+		t.Errorf("Retry-After not capped: %s", d)
+	}
+	if err := hj.Write(context.Background(), events(3)); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 {
+		t.Errorf("Expected 3 NDJSON lines, got %d", len(lines))
+	}
+}
+
+func TestElasticsearchRetryFailedItems(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		mutex.Lock()
+		requests = append(requests, len(lines)/2)
+		first := len(requests) == 1
+		mutex.Unlock()
+		if first {
+			if !strings.Contains(lines[0], `"_index":"winlogbeat-security-2020.01.02"`) {
+				t.Errorf("Bad index: %s", lines[0])
+			}
+			// second item is throttled
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	defer srv.Close()
+
+	es := &Elasticsearch{URL: srv.URL, Retry: RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond}}
+	if err := es.Open(); err != nil {
+		t.Fatal(err)
+	}
+	in := events(2)
+	for i, e := range in {
+		(*e)["Event"] = evtx.GoEvtxMap{"System": evtx.GoEvtxMap{
+			"Channel":       "Security",
+			"Computer":      "DC01",
+			"EventRecordID": fmt.Sprint(i),
+			"TimeCreated":   evtx.GoEvtxMap{"SystemTime": "2020-01-02T03:04:05Z"},
+		}}
+	}
+	if err := es.Write(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[1] != 1 {
+		t.Errorf("Only the failed item must be retried: %v", requests)
+	}
+	if DocumentID(in[0]) == DocumentID(in[1]) {
+		t.Errorf("Document IDs must differ")
+	}
+	// events without record ID are identified by their content
+	if a, b := events(2), events(2); DocumentID(a[0]) == DocumentID(a[1]) || DocumentID(a[0]) != DocumentID(b[0]) {
+		t.Errorf("Document IDs of events without record ID must depend on their content")
+	}
+
+	// retries of the batches are not nested into the retries of the items
+	var attempts int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	b := NewBatcher(&Elasticsearch{URL: down.URL}, BatchOptions{Size: 2, Retry: RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond}})
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(context.Background(), in); err == nil {
+		t.Error("Expected a delivery error")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestSplunkHECAck(t *testing.T) {
+	acked := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Splunk secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/services/collector/event":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := ioutil.ReadAll(zr)
+			if !strings.Contains(string(data), `"time":1577934245.000,"host":"DC01","source":"Security.evtx"`) {
+				t.Errorf("Unexpected envelope: %s", data)
+			}
+			w.Write([]byte(`{"text":"Success","code":0,"ackId":7}`))
+		case "/services/collector/ack":
+			w.Write([]byte(fmt.Sprintf(`{"acks":{"7":%t}}`, acked)))
+			acked = true
+		}
+	}))
+	defer srv.Close()
+
+	s := &SplunkHEC{URL: srv.URL, Token: "secret", UseAck: true, AckInterval: time.Millisecond}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	e := &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{"System": evtx.GoEvtxMap{
+			"Computer":    "DC01",
+			"TimeCreated": evtx.GoEvtxMap{"SystemTime": "2020-01-02T03:04:05Z"},
+		}},
+		"Meta": evtx.GoEvtxMap{"File": "Security.evtx"},
+	}
+	if err := s.Write(context.Background(), []*evtx.GoEvtxMap{e}); err != nil {
+		t.Fatal(err)
+	}
+	if !acked {
+		t.Errorf("Ack not polled")
+	}
+}
+
+func TestGELFChunking(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	g := &GELF{Address: pc.LocalAddr().String(), Compression: "none", ChunkSize: 200}
+	if err := g.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	e := &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{
+		"System":    evtx.GoEvtxMap{"Computer": "WS01", "EventID": "1", "Provider": evtx.GoEvtxMap{"Name": "Sysmon"}},
+		"EventData": evtx.GoEvtxMap{"Image": "C:\\Windows\\cmd.exe", "CommandLine": strings.Repeat("A", 500)},
+	}}
+	if err := g.Write(context.Background(), []*evtx.GoEvtxMap{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks [][]byte
+	buf := make([]byte, 1024)
+	for {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := append([]byte{}, buf[:n]...)
+		if c[0] != 0x1e || c[1] != 0x0f || n > 200 {
+			t.Fatalf("Bad chunk")
+		}
+		chunks = append(chunks, c)
+		if len(chunks) == int(c[11]) {
+			break
+		}
+	}
+	var data []byte
+	for _, c := range chunks {
+		data = append(data, c[12:]...)
+	}
+	msg := make(map[string]interface{})
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["host"] != "WS01" || msg["_EventData_Image"] != "C:\\Windows\\cmd.exe" ||
+		!strings.HasPrefix(msg["short_message"].(string), "Sysmon 1 Image=C:\\Windows\\cmd.exe CommandLine=AAA") {
+		t.Errorf("Unexpected message: %v", msg)
+	}
+}
+
+func TestGELFIdleClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the first connection is closed by the server once the first message is
+	// read, the second one is read until closed
+	received := make(chan string)
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			if i == 0 {
+				r.ReadBytes(0)
+				conn.Close()
+				received <- ""
+				continue
+			}
+			data, _ := ioutil.ReadAll(r)
+			conn.Close()
+			received <- string(data)
+		}
+	}()
+
+	g := &GELF{Network: "tcp", Address: ln.Addr().String()}
+	if err := g.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Write(context.Background(), events(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	// gives time to the FIN to arrive
+	time.Sleep(20 * time.Millisecond)
+	if err := g.Write(context.Background(), events(1)); err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+	select {
+	case msg := <-received:
+		if msg == "" {
+			t.Error("Event written on the connection closed by the server")
+		}
+	case <-time.After(time.Second):
+		t.Error("Event written on the connection closed by the server")
+	}
+}
+
+func TestFluentForwardHandshakeAck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type result struct {
+		tag     string
+		entries []interface{}
+		err     error
+	}
+	results := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() { results <- r }()
+		conn, err := ln.Accept()
+		if err != nil {
+			r.err = err
+			return
+		}
+		defer conn.Close()
+		dec := &msgpackDecoder{bufio.NewReader(conn)}
+		nonce := []byte("0123456789abcdef")
+		conn.Write(msgpackEncode([]interface{}{"HELO", map[string]interface{}{"nonce": msgpackBin(nonce), "auth": msgpackBin(nil), "keepalive": true}}))
+		v, err := dec.decode()
+		if err != nil {
+			r.err = err
+			return
+		}
+		ping := v.([]interface{})
+		if ping[3] != sha512Hex(ping[2], ping[1], nonce, "key") {
+			r.err = errors.New("bad shared key digest")
+			return
+		}
+		conn.Write(msgpackEncode([]interface{}{"PONG", true, "", "server", sha512Hex(ping[2], "server", nonce, "key")}))
+
+		if v, err = dec.decode(); err != nil {
+			r.err = err
+			return
+		}
+		msg := v.([]interface{})
+		r.tag = msg[0].(string)
+		packed := &msgpackDecoder{bufio.NewReader(strings.NewReader(string(msg[1].([]byte))))}
+		for {
+			e, err := packed.decode()
+			if err != nil {
+				break
+			}
+			r.entries = append(r.entries, e)
+		}
+		option := msg[2].(map[string]interface{})
+		conn.Write(msgpackEncode(map[string]interface{}{"ack": option["chunk"]}))
+	}()
+
+	f := &Fluent{Address: ln.Addr().String(), SharedKey: "key", RequireAck: true}
+	if err := f.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	in := events(3)
+	for _, e := range in {
+		(*e)["Event"] = evtx.GoEvtxMap{"System": evtx.GoEvtxMap{"Channel": "Security"}}
+	}
+	if err := f.Write(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.tag != "evtx.security" || len(r.entries) != 3 {
+		t.Errorf("Unexpected message: tag=%s entries=%d", r.tag, len(r.entries))
+	}
+}
+
+func lokiEvent(computer, channel, ts string) *evtx.GoEvtxMap {
+	return &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{"System": evtx.GoEvtxMap{
+			"Computer":    computer,
+			"Channel":     channel,
+			"TimeCreated": evtx.GoEvtxMap{"SystemTime": ts},
+		}},
+	}
+}
+
+func TestLokiStreams(t *testing.T) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("X-Scope-OrgID") != "soc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	l := &Loki{URL: srv.URL, Encoding: "json", TenantID: "soc"}
+	if err := l.Open(); err != nil {
+		t.Fatal(err)
+	}
+	events := []*evtx.GoEvtxMap{
+		lokiEvent("DC01", "Security", "2020-01-02T03:04:06Z"),
+		lokiEvent("DC02", "Security", "2020-01-02T03:04:05Z"),
+		lokiEvent("DC01", "Security", "2020-01-02T03:04:05Z"),
+	}
+	if err := l.Write(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Streams) != 2 {
+		t.Fatalf("Unexpected number of streams: %d", len(req.Streams))
+	}
+	s := req.Streams[0]
+	if s.Stream["computer"] != "DC01" || s.Stream["channel"] != "Security" || len(s.Values) != 2 {
+		t.Fatalf("Unexpected stream: %v", s)
+	}
+	if s.Values[0][0] != "1577934245000000000" || s.Values[1][0] != "1577934246000000000" {
+		t.Errorf("Entries not ordered by event time: %v", s.Values)
+	}
+
+	// protobuf encoding must be a valid snappy block
+	l.Encoding = "protobuf"
+	body := l.encodeProtobuf(l.streams(events))
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `{channel="Security", computer="DC01"}`) {
+		t.Errorf("Missing stream labels in protobuf request")
+	}
+}
+
+func TestFilePartitionRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evtx-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &File{Path: filepath.Join(dir, "{Computer}", "{Channel}", "{yyyy-MM-dd}", "{EventID}.jsonl.gz"), MaxSize: 1}
+	if err := f.Open(); err != nil {
+		t.Fatal(err)
+	}
+	events := []*evtx.GoEvtxMap{
+		lokiEvent("DC01", "Security", "2020-01-02T03:04:05Z"),
+		lokiEvent("DC01", "Security", "2020-01-02T03:04:06Z"),
+		lokiEvent("../DC02", "Security", "2020-01-03T03:04:05Z"),
+	}
+	if err := f.Write(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// every event exceeds MaxSize so the second one goes to a new file
+	for _, name := range []string{
+		"DC01/Security/2020-01-02/unknown.jsonl.gz",
+		"DC01/Security/2020-01-02/unknown-1.jsonl.gz",
+		".._DC02/Security/2020-01-03/unknown.jsonl.gz",
+	} {
+		fd, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(zr)
+		fd.Close()
+		if strings.Count(string(data), "\n") != 1 {
+			t.Errorf("Unexpected content in %s: %s", name, data)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	e := &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{
+			"System":    evtx.GoEvtxMap{"EventID": "4624", "Computer": "DC01"},
+			"EventData": evtx.GoEvtxMap{"CommandLine": "cmd.exe /c \"echo a,b\"\nwhoami"},
+		},
+	}
+
+	buf := new(strings.Builder)
+	c := &CSV{W: buf, Columns: []string{"System/EventID", "EventData/CommandLine", "EventData/Missing"}}
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(context.Background(), []*evtx.GoEvtxMap{e})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "System/EventID,EventData/CommandLine,EventData/Missing\r\n" +
+		"4624,\"cmd.exe /c \"\"echo a,b\"\"\r\nwhoami\",\r\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV: %q", buf.String())
+	}
+
+	// auto mode
+	buf.Reset()
+	c = &CSV{W: buf, Comma: '\t'}
+	if err := c.Open(); err != nil {
+		t.Fatal(err)
+	}
+	other := &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{"System": evtx.GoEvtxMap{"EventID": "4624", "Channel": "Security"}}}
+	c.Write(context.Background(), []*evtx.GoEvtxMap{e, other})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(buf.String(), "\r\n", 2)
+	if lines[0] != "EventData.CommandLine\tSystem.Channel\tSystem.Computer\tSystem.EventID" {
+		t.Errorf("Unexpected header: %q", lines[0])
+	}
+	r := csv.NewReader(strings.NewReader(buf.String()))
+	r.Comma = '\t'
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][0] != "cmd.exe /c \"echo a,b\"\nwhoami" || records[1][1] != "" ||
+		strings.Join(records[2], ",") != ",Security,,4624" {
+		t.Errorf("Unexpected rows: %q", records)
+	}
+}
+
+func TestParquet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evtx-parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &Parquet{Dir: dir, RowGroupSize: 2}
+	if err := p.Open(); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	typed := func(v interface{}, ip interface{}) *evtx.GoEvtxMap {
+		data := evtx.GoEvtxMap{"LogonType": v}
+		if ip != nil {
+			data["IpAddress"] = ip
+		}
+		return &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{
+			"System": evtx.GoEvtxMap{
+				"Channel":     "Security",
+				"EventID":     uint16(4624),
+				"TimeCreated": evtx.GoEvtxMap{"SystemTime": evtx.UTCTime(created)},
+			},
+			"EventData": data,
+		}}
+	}
+	// the last event does not fit in the schema of the first row group
+	events := []*evtx.GoEvtxMap{typed(uint32(2), "10.0.0.1"), typed(uint32(10), nil), typed(uint32(3), nil), typed("bad", nil)}
+	if err := p.Write(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// files are decoded with an independent implementation
+	read := func(name string) (*parquet.File, []parquet.Row) {
+		fd, err := os.Open(filepath.Join(dir, "channel=Security", "event_id=4624", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { fd.Close() })
+		fi, err := fd.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := parquet.OpenFile(fd, fi.Size())
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", name, err)
+		}
+		rows := make([]parquet.Row, 0)
+		r := parquet.NewReader(f)
+		for {
+			buf := make([]parquet.Row, 8)
+			n, err := r.ReadRows(buf)
+			// values point to buffers of the reader
+			for _, row := range buf[:n] {
+				rows = append(rows, row.Clone())
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read rows of %s: %s", name, err)
+			}
+		}
+		return f, rows
+	}
+
+	f, rows := read("part-0.parquet")
+	if f.NumRows() != 3 || len(rows) != 3 || len(f.RowGroups()) != 2 {
+		t.Fatalf("Unexpected rows in part-0: %d rows, %d read, %d row groups", f.NumRows(), len(rows), len(f.RowGroups()))
+	}
+	expected := []struct {
+		name string
+		kind parquet.Kind
+	}{
+		{"EventData_IpAddress", parquet.ByteArray},
+		{"EventData_LogonType", parquet.Int64},
+		{"System_Channel", parquet.ByteArray},
+		{"System_EventID", parquet.Int32},
+		{"System_TimeCreated_SystemTime", parquet.Int64},
+	}
+	fields := f.Schema().Fields()
+	if len(fields) != len(expected) {
+		t.Fatalf("Unexpected schema: %s", f.Schema())
+	}
+	for i, e := range expected {
+		if fields[i].Name() != e.name || fields[i].Type().Kind() != e.kind || !fields[i].Optional() {
+			t.Errorf("Unexpected column %d: %s %s", i, fields[i].Name(), fields[i].Type())
+		}
+	}
+	if lt := fields[4].Type().LogicalType(); lt == nil || lt.Timestamp == nil {
+		t.Errorf("SystemTime must be a timestamp: %s", fields[4].Type())
+	}
+	if lt := fields[2].Type().LogicalType(); lt == nil || lt.UTF8 == nil {
+		t.Errorf("Channel must be a string: %s", fields[2].Type())
+	}
+	for i, lt := range []int64{2, 10, 3} {
+		row := rows[i]
+		if row[0].IsNull() != (i != 0) || (i == 0 && row[0].String() != "10.0.0.1") {
+			t.Errorf("Unexpected IpAddress in row %d: %v", i, row[0])
+		}
+		if row[1].Int64() != lt || row[2].String() != "Security" || row[3].Int32() != 4624 ||
+			row[4].Int64() != created.UnixNano()/int64(time.Microsecond) {
+			t.Errorf("Unexpected row %d: %v", i, row)
+		}
+	}
+
+	f, rows = read("part-1.parquet")
+	if f.NumRows() != 1 || len(rows) != 1 {
+		t.Fatalf("Unexpected rows in part-1: %d", f.NumRows())
+	}
+	if c, ok := f.Schema().Lookup("EventData_LogonType"); !ok || c.Node.Type().Kind() != parquet.ByteArray || rows[0][c.ColumnIndex].String() != "bad" {
+		t.Errorf("LogonType must be a string in part-1: %s", f.Schema())
+	}
+
+	if v, ok := toParquet("0x10", ParquetInt32); !ok || v.(int32) != 16 {
+		t.Errorf("Bad conversion: %v", v)
+	}
+	if mergeParquetTypes(ParquetInt32, ParquetInt64) != ParquetInt64 || mergeParquetTypes(ParquetUint64, ParquetInt32) != ParquetString {
+		t.Errorf("Bad type merge")
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "evtx-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "case.db")
+
+	event := func(rid string, user string) *evtx.GoEvtxMap {
+		return &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{
+			"System": evtx.GoEvtxMap{
+				"Channel":       "Security",
+				"Computer":      "DC01",
+				"EventID":       "4624",
+				"EventRecordID": rid,
+				"TimeCreated":   evtx.GoEvtxMap{"SystemTime": "2020-01-02T03:04:05Z"},
+			},
+			"EventData": evtx.GoEvtxMap{"TargetUserName": user, "Computer": "WS01"},
+		}}
+	}
+	// loading twice must not create duplicates
+	for i := 0; i < 2; i++ {
+		s := &SQLite{Path: path}
+		if err := s.Open(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Write(context.Background(), []*evtx.GoEvtxMap{event("1", "alice"), event("2", "bob")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &SQLite{Path: path}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var count int
+	if err := s.db.QueryRow("SELECT count(*) FROM events WHERE event_id = 4624").Scan(&count); err != nil || count != 2 {
+		t.Errorf("Unexpected number of events: %d (%v)", count, err)
+	}
+	var user, computer string
+	err = s.db.QueryRow(`SELECT TargetUserName, EventData_Computer FROM event_4624 WHERE record_id = 2`).Scan(&user, &computer)
+	if err != nil || user != "bob" || computer != "WS01" {
+		t.Errorf("Unexpected view content: %s %s (%v)", user, computer, err)
+	}
+
+	// the fields of a failed write must be recorded by the retry
+	e := event("3", "carol")
+	(*e)["Event"].(evtx.GoEvtxMap)["EventData"].(evtx.GoEvtxMap)["IpAddress"] = "10.0.0.1"
+	s.db.Exec("DROP TABLE event_fields")
+	if err := s.Write(context.Background(), []*evtx.GoEvtxMap{e}); err == nil {
+		t.Fatal("Write expected to fail without event_fields table")
+	}
+	s.db.Exec(sqliteSchema)
+	if err := s.Write(context.Background(), []*evtx.GoEvtxMap{e}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var ip string
+	if err := s.db.QueryRow(`SELECT IpAddress FROM event_4624 WHERE record_id = 3`).Scan(&ip); err != nil || ip != "10.0.0.1" {
+		t.Errorf("Field of the retried write missing from the view: %s (%v)", ip, err)
+	}
+}
+
+func TestKafkaConfig(t *testing.T) {
+	k := &Kafka{BrokerURLs: "kafka1:9092, kafka2:9092", Topic: "winlogs", Key: "{Computer}/{Channel}", SASL: "scram-sha-512", Username: "evtx", Password: "secret"}
+	if err := k.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if k.conn.Addr.String() != "kafka1:9092,kafka2:9092" {
+		t.Errorf("Unexpected brokers: %s", k.conn.Addr)
+	}
+	if key := k.key.Execute(lokiEvent("DC01", "Security", "2020-01-02T03:04:05Z"), nil); key != "DC01/Security" {
+		t.Errorf("Unexpected key: %s", key)
+	}
+
+	for _, bad := range []*Kafka{
+		{BrokerURLs: " , ", Topic: "winlogs"},
+		{BrokerURLs: "kafka:9092", Topic: "winlogs", Compression: "brotli"},
+		{BrokerURLs: "kafka:9092", Topic: "winlogs", SASL: "gssapi"},
+	} {
+		if err := bad.Open(); err == nil {
+			t.Errorf("Bad configuration accepted: %+v", bad)
+		}
+	}
+}
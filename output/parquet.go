@@ -0,0 +1,699 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+const (
+	// DefaultParquetPartition is the default partitioning of Parquet files,
+	// Hive style so that query engines can prune partitions
+	DefaultParquetPartition = "channel={Channel}/event_id={EventID}"
+	// DefaultRowGroupSize is the default number of rows of a row group
+	DefaultRowGroupSize = 50000
+
+	parquetMagic = "PAR1"
+)
+
+// ParquetType is the type of a Parquet column
+type ParquetType int
+
+// Parquet column types
+const (
+	ParquetBool ParquetType = iota
+	ParquetInt32
+	ParquetInt64
+	ParquetUint64
+	ParquetFloat
+	ParquetDouble
+	ParquetTimestamp
+	ParquetString
+	ParquetBinary
+)
+
+var (
+	// ParquetTypes maps type names, as used in schema mappings, to types
+	ParquetTypes = map[string]ParquetType{
+		"bool":      ParquetBool,
+		"int32":     ParquetInt32,
+		"int64":     ParquetInt64,
+		"uint64":    ParquetUint64,
+		"float":     ParquetFloat,
+		"double":    ParquetDouble,
+		"timestamp": ParquetTimestamp,
+		"string":    ParquetString,
+		"binary":    ParquetBinary,
+	}
+
+	// physical types (Type enum of Parquet)
+	parquetPhysical = map[ParquetType]int32{
+		ParquetBool:      0,
+		ParquetInt32:     1,
+		ParquetInt64:     2,
+		ParquetUint64:    2,
+		ParquetFloat:     4,
+		ParquetDouble:    5,
+		ParquetTimestamp: 2,
+		ParquetString:    6,
+		ParquetBinary:    6,
+	}
+
+	// converted types (ConvertedType enum of Parquet)
+	parquetConverted = map[ParquetType]int32{
+		ParquetString:    0,  // UTF8
+		ParquetTimestamp: 10, // TIMESTAMP_MICROS
+		ParquetUint64:    14, // UINT_64
+	}
+
+	parquetCodecs = map[string]int32{
+		"none":   0,
+		"snappy": 1,
+		"gzip":   2,
+	}
+)
+
+// parquetTypeOf returns the type of a column able to store v
+func parquetTypeOf(v interface{}) ParquetType {
+	switch v.(type) {
+	case bool:
+		return ParquetBool
+	case int8, int16, int32, uint8, uint16:
+		return ParquetInt32
+	case int64, uint32:
+		return ParquetInt64
+	case uint64:
+		return ParquetUint64
+	case float32:
+		return ParquetFloat
+	case float64:
+		return ParquetDouble
+	case time.Time, evtx.UTCTime:
+		return ParquetTimestamp
+	case []byte:
+		return ParquetBinary
+	}
+	return ParquetString
+}
+
+// mergeParquetTypes returns a type able to store the values of both types
+func mergeParquetTypes(a, b ParquetType) ParquetType {
+	if a == b {
+		return a
+	}
+	if a > b {
+		a, b = b, a
+	}
+	switch {
+	case a == ParquetInt32 && b == ParquetInt64:
+		return ParquetInt64
+	case (a == ParquetInt32 || a == ParquetInt64 || a == ParquetFloat) && b == ParquetDouble:
+		return ParquetDouble
+	case (a == ParquetInt32 || a == ParquetInt64) && b == ParquetFloat:
+		return ParquetDouble
+	}
+	return ParquetString
+}
+
+// toParquet converts a value to the Go type used to encode a column type
+// (bool, int32, int64, uint64, float32, float64, time.Time, []byte)
+func toParquet(v interface{}, t ParquetType) (interface{}, bool) {
+	if s, ok := v.(string); ok && t != ParquetString && t != ParquetBinary {
+		s = strings.TrimSpace(s)
+		switch t {
+		case ParquetBool:
+			b, err := strconv.ParseBool(s)
+			return b, err == nil
+		case ParquetInt32:
+			i, err := strconv.ParseInt(s, 0, 32)
+			return int32(i), err == nil
+		case ParquetInt64:
+			i, err := strconv.ParseInt(s, 0, 64)
+			return i, err == nil
+		case ParquetUint64:
+			u, err := strconv.ParseUint(s, 0, 64)
+			return u, err == nil
+		case ParquetFloat:
+			f, err := strconv.ParseFloat(s, 32)
+			return float32(f), err == nil
+		case ParquetDouble:
+			f, err := strconv.ParseFloat(s, 64)
+			return f, err == nil
+		case ParquetTimestamp:
+			ts, err := time.Parse(time.RFC3339Nano, s)
+			return ts, err == nil
+		}
+	}
+
+	rv := reflect.ValueOf(v)
+	switch t {
+	case ParquetBool:
+		b, ok := v.(bool)
+		return b, ok
+	case ParquetInt32, ParquetInt64, ParquetFloat, ParquetDouble:
+		var i int64
+		var f float64
+		switch rv.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i = rv.Int()
+			f = float64(i)
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if rv.Uint() > math.MaxInt64 {
+				return nil, false
+			}
+			i = int64(rv.Uint())
+			f = float64(i)
+		case reflect.Float32, reflect.Float64:
+			if t == ParquetFloat || t == ParquetDouble {
+				f = rv.Float()
+				if t == ParquetFloat {
+					return float32(f), true
+				}
+				return f, true
+			}
+			return nil, false
+		default:
+			return nil, false
+		}
+		switch t {
+		case ParquetInt32:
+			return int32(i), i >= math.MinInt32 && i <= math.MaxInt32
+		case ParquetInt64:
+			return i, true
+		case ParquetFloat:
+			return float32(f), true
+		}
+		return f, true
+	case ParquetUint64:
+		switch rv.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return rv.Uint(), true
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return uint64(rv.Int()), rv.Int() >= 0
+		}
+		return nil, false
+	case ParquetTimestamp:
+		switch ts := v.(type) {
+		case time.Time:
+			return ts, true
+		case evtx.UTCTime:
+			return time.Time(ts), true
+		}
+		return nil, false
+	case ParquetBinary:
+		switch b := v.(type) {
+		case []byte:
+			return b, true
+		case string:
+			return []byte(b), true
+		}
+		return nil, false
+	}
+	// string
+	if b, ok := v.([]byte); ok {
+		return []byte(fmt.Sprintf("%X", b)), true
+	}
+	return []byte(ValueString(v)), true
+}
+
+// ParquetColumn is a column of a Parquet file
+type ParquetColumn struct {
+	Name string
+	Type ParquetType
+}
+
+// parquetColumnMeta is the metadata of a column chunk
+type parquetColumnMeta struct {
+	column             ParquetColumn
+	offset             int64
+	uncompressedSize   int64
+	compressedSize     int64
+	valuesUncompressed int64
+}
+
+type parquetRowGroup struct {
+	columns []parquetColumnMeta
+	rows    int64
+}
+
+// parquetFile is a Parquet file being written
+type parquetFile struct {
+	key     string
+	fd      *os.File
+	offset  int64
+	columns []ParquetColumn
+	index   map[string]int
+	pending []map[string]interface{}
+	groups  []parquetRowGroup
+	used    time.Time
+}
+
+// compatible returns true if all the fields of a row fit in the schema
+func (f *parquetFile) compatible(row map[string]interface{}) bool {
+	for k, v := range row {
+		if v == nil {
+			continue
+		}
+		i, ok := f.index[k]
+		if !ok {
+			return false
+		}
+		if _, ok := toParquet(v, f.columns[i].Type); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *parquetFile) setColumns(columns []ParquetColumn) {
+	f.columns = columns
+	f.index = make(map[string]int, len(columns))
+	for i, c := range columns {
+		f.index[c.Name] = i
+	}
+}
+
+func (f *parquetFile) write(b []byte) error {
+	n, err := f.fd.Write(b)
+	f.offset += int64(n)
+	return err
+}
+
+// Parquet writes events into Parquet files, in partitioned directories. The
+// events are flattened into columns named after their path (ex:
+// EventData_TargetUserName). Unless a schema is given, a file holds the events
+// of a channel and event ID and its schema is inferred from the types of the
+// values, so events must be decoded with typed values (see TypedOutput). A new
+// file is started when an event does not fit in the schema of the current
+// file.
+type Parquet struct {
+	partition *Template
+	codec     int32
+	files     map[string]*parquetFile
+	schema    []ParquetColumn
+	// Dir is the root directory of the files
+	Dir string
+	// Partition is the template of the directories of the files (see
+	// Template), default is DefaultParquetPartition
+	Partition string
+	// Schema maps column names to type names (see ParquetTypes), the schema
+	// is inferred if empty. Values which can't be converted are null.
+	Schema map[string]string
+	// RowGroupSize is the number of rows of a row group, default is
+	// DefaultRowGroupSize
+	RowGroupSize int
+	// Compression is snappy (default), gzip or none
+	Compression string
+	// MaxOpen is the maximum number of files kept open, default is
+	// DefaultMaxOpen
+	MaxOpen int
+}
+
+// TypedValues implements TypedOutput, columns are typed after the BinXML
+// values
+func (p *Parquet) TypedValues() bool {
+	return true
+}
+
+// Open checks the configuration
+func (p *Parquet) Open() (err error) {
+	if p.Dir == "" {
+		return fmt.Errorf("Missing Parquet output directory")
+	}
+	if p.Partition == "" {
+		p.Partition = DefaultParquetPartition
+	}
+	if p.partition, err = ParseTemplate(p.Partition); err != nil {
+		return
+	}
+	if p.Compression == "" {
+		p.Compression = "snappy"
+	}
+	codec, ok := parquetCodecs[p.Compression]
+	if !ok {
+		return fmt.Errorf("Unknown Parquet compression: %s", p.Compression)
+	}
+	p.codec = codec
+	if p.RowGroupSize <= 0 {
+		p.RowGroupSize = DefaultRowGroupSize
+	}
+	if p.MaxOpen <= 0 {
+		p.MaxOpen = DefaultMaxOpen
+	}
+	p.schema = nil
+	for name, typ := range p.Schema {
+		t, ok := ParquetTypes[typ]
+		if !ok {
+			return fmt.Errorf("Unknown Parquet type %s for column %s", typ, name)
+		}
+		p.schema = append(p.schema, ParquetColumn{name, t})
+	}
+	sort.Slice(p.schema, func(i, j int) bool { return p.schema[i].Name < p.schema[j].Name })
+	p.files = make(map[string]*parquetFile)
+	return nil
+}
+
+// infer infers the schema of rows
+func (p *Parquet) infer(rows []map[string]interface{}) []ParquetColumn {
+	types := make(map[string]ParquetType)
+	for _, r := range rows {
+		for k, v := range r {
+			if v == nil {
+				continue
+			}
+			t := parquetTypeOf(v)
+			if prev, ok := types[k]; ok {
+				t = mergeParquetTypes(prev, t)
+			}
+			types[k] = t
+		}
+	}
+	columns := make([]ParquetColumn, 0, len(types))
+	for k, t := range types {
+		columns = append(columns, ParquetColumn{k, t})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+	return columns
+}
+
+// create creates a new file in a directory
+func (p *Parquet) create(key, dir string) (*parquetFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var fd *os.File
+	var err error
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("part-%d.parquet", i))
+		fd, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &parquetFile{key: key, fd: fd, used: time.Now()}
+	if p.schema != nil {
+		f.setColumns(p.schema)
+	}
+	if err := f.write([]byte(parquetMagic)); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// rleLevels encodes definition levels (0 or 1) with the RLE hybrid encoding,
+// prefixed by the length as expected in data pages v1
+func rleLevels(levels []byte) []byte {
+	var enc []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		enc = pbVarint(enc, uint64(j-i)<<1)
+		enc = append(enc, levels[i])
+		i = j
+	}
+	out := make([]byte, 4, 4+len(enc))
+	binary.LittleEndian.PutUint32(out, uint32(len(enc)))
+	return append(out, enc...)
+}
+
+// parquetPlain encodes non null values with the PLAIN encoding
+func parquetPlain(t ParquetType, values []interface{}) []byte {
+	buf := new(bytes.Buffer)
+	if t == ParquetBool {
+		b := make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			if v.(bool) {
+				b[i/8] |= 1 << uint(i%8)
+			}
+		}
+		return b
+	}
+	for _, v := range values {
+		switch t {
+		case ParquetInt32:
+			binary.Write(buf, binary.LittleEndian, v.(int32))
+		case ParquetInt64:
+			binary.Write(buf, binary.LittleEndian, v.(int64))
+		case ParquetUint64:
+			binary.Write(buf, binary.LittleEndian, v.(uint64))
+		case ParquetFloat:
+			binary.Write(buf, binary.LittleEndian, math.Float32bits(v.(float32)))
+		case ParquetDouble:
+			binary.Write(buf, binary.LittleEndian, math.Float64bits(v.(float64)))
+		case ParquetTimestamp:
+			binary.Write(buf, binary.LittleEndian, v.(time.Time).UnixNano()/int64(time.Microsecond))
+		default:
+			b := v.([]byte)
+			binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+			buf.Write(b)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (p *Parquet) compress(data []byte) []byte {
+	switch p.Compression {
+	case "snappy":
+		return snappy.Encode(nil, data)
+	case "gzip":
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+	return data
+}
+
+// writeRowGroup writes the pending rows of a file as a row group
+func (p *Parquet) writeRowGroup(f *parquetFile) error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	if f.columns == nil {
+		f.setColumns(p.infer(f.pending))
+	}
+	rg := parquetRowGroup{rows: int64(len(f.pending))}
+	for _, c := range f.columns {
+		levels := make([]byte, len(f.pending))
+		values := make([]interface{}, 0, len(f.pending))
+		for i, r := range f.pending {
+			if v, ok := r[c.Name]; ok && v != nil {
+				if cv, ok := toParquet(v, c.Type); ok {
+					levels[i] = 1
+					values = append(values, cv)
+				}
+			}
+		}
+		page := append(rleLevels(levels), parquetPlain(c.Type, values)...)
+		data := p.compress(page)
+
+		// PageHeader
+		h := &thriftWriter{}
+		h.begin()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(data)))
+		h.structField(5)
+		h.i32(1, int32(len(f.pending)))
+		h.i32(2, 0) // PLAIN
+		h.i32(3, 3) // RLE
+		h.i32(4, 3) // RLE
+		h.end()
+		h.end()
+
+		meta := parquetColumnMeta{
+			column:             c,
+			offset:             f.offset,
+			uncompressedSize:   int64(len(h.bytes()) + len(page)),
+			compressedSize:     int64(len(h.bytes()) + len(data)),
+			valuesUncompressed: int64(len(f.pending)),
+		}
+		if err := f.write(h.bytes()); err != nil {
+			return err
+		}
+		if err := f.write(data); err != nil {
+			return err
+		}
+		rg.columns = append(rg.columns, meta)
+	}
+	f.groups = append(f.groups, rg)
+	f.pending = f.pending[:0]
+	return nil
+}
+
+// footer encodes the FileMetaData of a file
+func (p *Parquet) footer(f *parquetFile) []byte {
+	var rows int64
+	for _, g := range f.groups {
+		rows += g.rows
+	}
+	t := &thriftWriter{}
+	t.begin()
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(f.columns)+1)
+	t.begin()
+	t.string(4, "schema")
+	t.i32(5, int32(len(f.columns)))
+	t.end()
+	for _, c := range f.columns {
+		t.begin()
+		t.i32(1, parquetPhysical[c.Type])
+		t.i32(3, 1) // OPTIONAL
+		t.string(4, c.Name)
+		if ct, ok := parquetConverted[c.Type]; ok {
+			t.i32(6, ct)
+		}
+		t.end()
+	}
+	t.i64(3, rows)
+	t.list(4, thriftStruct, len(f.groups))
+	for _, g := range f.groups {
+		var size int64
+		t.begin()
+		t.list(1, thriftStruct, len(g.columns))
+		for _, c := range g.columns {
+			size += c.uncompressedSize
+			// ColumnChunk
+			t.begin()
+			t.i64(2, c.offset)
+			// ColumnMetaData
+			t.structField(3)
+			t.i32(1, parquetPhysical[c.column.Type])
+			t.list(2, thriftI32, 2)
+			t.rawI32(0) // PLAIN
+			t.rawI32(3) // RLE
+			t.list(3, thriftBinary, 1)
+			t.rawString(c.column.Name)
+			t.i32(4, p.codec)
+			t.i64(5, c.valuesUncompressed)
+			t.i64(6, c.uncompressedSize)
+			t.i64(7, c.compressedSize)
+			t.i64(9, c.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, g.rows)
+		t.end()
+	}
+	t.string(6, "golang-evtx")
+	t.end()
+	return t.bytes()
+}
+
+// close writes the last row group and the footer of a file
+func (p *Parquet) close(f *parquetFile) error {
+	delete(p.files, f.key)
+	err := p.writeRowGroup(f)
+	if err == nil {
+		footer := p.footer(f)
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(footer)))
+		err = f.write(append(append(footer, size...), parquetMagic...))
+	}
+	if cerr := f.fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write Parquet file %s: %s", f.fd.Name(), err)
+	}
+	return nil
+}
+
+// closeLRU closes the least recently used file
+func (p *Parquet) closeLRU() error {
+	var lru *parquetFile
+	for _, f := range p.files {
+		if lru == nil || f.used.Before(lru.used) {
+			lru = f
+		}
+	}
+	return p.close(lru)
+}
+
+// file returns the file events of key are written to
+func (p *Parquet) file(key, dir string) (f *parquetFile, err error) {
+	if f = p.files[key]; f != nil {
+		f.used = time.Now()
+		return
+	}
+	if len(p.files) >= p.MaxOpen {
+		if err = p.closeLRU(); err != nil {
+			return
+		}
+	}
+	if f, err = p.create(key, dir); err != nil {
+		return nil, fmt.Errorf("Failed to create Parquet file in %s: %s", dir, err)
+	}
+	p.files[key] = f
+	return
+}
+
+// Write adds the events to their files, row groups are written when full
+func (p *Parquet) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	for _, e := range events {
+		dir := filepath.Join(p.Dir, filepath.FromSlash(p.partition.Execute(e, cleanPathPart)))
+		key := dir
+		if p.schema == nil {
+			// one schema per channel and event ID
+			key = fmt.Sprintf("%s\x00%s\x00%d", dir, getString(e, evtx.ChannelPath), eventID(e))
+		}
+		f, err := p.file(key, dir)
+		if err != nil {
+			return err
+		}
+		row := Flatten(e, "_")
+		if p.schema == nil && f.columns != nil && !f.compatible(row) {
+			// the schema changed, a new file is started
+			if err := p.close(f); err != nil {
+				return err
+			}
+			if f, err = p.file(key, dir); err != nil {
+				return err
+			}
+		}
+		f.pending = append(f.pending, row)
+		if len(f.pending) >= p.RowGroupSize {
+			if err := p.writeRowGroup(f); err != nil {
+				return fmt.Errorf("Failed to write Parquet file %s: %s", f.fd.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Flush does nothing, row groups are written when full or on Close
+func (p *Parquet) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close writes the pending rows and closes all the files
+func (p *Parquet) Close() (err error) {
+	for _, f := range p.files {
+		if cerr := p.close(f); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
@@ -0,0 +1,637 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-utils/log"
+)
+
+// OverflowPolicy defines what a DiskQueue does when it is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the writers until some space is freed
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest segments of the queue
+	OverflowDropOldest
+	// OverflowDropNewest drops the events being written
+	OverflowDropNewest
+)
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+)
+
+var (
+	// ErrQueueFull returned when events are dropped because the queue is full
+	ErrQueueFull = errors.New("Queue is full")
+	// ErrQueueClosed returned when writing to a closed queue
+	ErrQueueClosed = errors.New("Queue is closed")
+)
+
+// ParseOverflowPolicy parses an overflow policy name (block, drop-oldest,
+// drop-newest)
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch strings.ToLower(s) {
+	case "block", "":
+		return OverflowBlock, nil
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	case "drop-newest":
+		return OverflowDropNewest, nil
+	}
+	return OverflowBlock, fmt.Errorf("Unknown overflow policy: %s", s)
+}
+
+// QueueOptions configures a DiskQueue
+type QueueOptions struct {
+	// Dir is the directory where segments are stored
+	Dir string
+	// SegmentSize is the size above which a new segment is started
+	SegmentSize int64
+	// MaxSize is the maximum size of the queue on disk (zero means no limit)
+	MaxSize int64
+	// Overflow policy applied when MaxSize is reached
+	Overflow OverflowPolicy
+	// BatchSize is the maximum number of events replayed at once
+	BatchSize int
+	// MaxAttempts is the number of times an event is tried while the sink is
+	// up before being moved to the dead-letter file
+	MaxAttempts int
+	// MaxRetryAge is the time after which an event still failing is moved to
+	// the dead-letter file even if the sink is not known to be up, so that a
+	// poison event with nothing delivered around it does not block the queue
+	// forever. Default is DefaultQueueOptions.MaxRetryAge, negative means no
+	// limit.
+	MaxRetryAge time.Duration
+	// Retry policy giving the delays between attempts, MaxRetries is not used
+	Retry RetryPolicy
+	// DeadLetter file path, default is deadletter.jsonl in Dir
+	DeadLetter string
+	// DrainTimeout is the time Close waits for the queue to be replayed,
+	// events not replayed stay on disk for the next run
+	DrainTimeout time.Duration
+}
+
+var (
+	// DefaultQueueOptions used by evtxdump
+	DefaultQueueOptions = QueueOptions{
+		SegmentSize:  16 << 20,
+		BatchSize:    100,
+		MaxAttempts:  5,
+		MaxRetryAge:  time.Hour,
+		Retry:        DefaultRetryPolicy,
+		DrainTimeout: 30 * time.Second,
+	}
+)
+
+// DiskQueue is a persistent queue put in front of an Output. Events written
+// to the queue are appended to segment files and replayed in order to the
+// output in the background, giving at-least-once delivery across sink outages
+// and restarts. Events which keep failing while the sink accepts other events
+// are moved to a dead-letter file. It implements Output.
+type DiskQueue struct {
+	sync.Mutex
+	cond     *sync.Cond
+	out      Output
+	opts     QueueOptions
+	segments []int64
+	sizes    map[int64]int64
+	size     int64
+	offset   int64
+	writer   *os.File
+	notify   func(delivered, failed int)
+	closed   bool
+	done     chan bool
+	wg       sync.WaitGroup
+}
+
+// NewDiskQueue creates a new DiskQueue in front of out
+// @out : output events are replayed to
+// @opts : queue options
+// return *DiskQueue
+func NewDiskQueue(out Output, opts QueueOptions) *DiskQueue {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultQueueOptions.SegmentSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.MaxRetryAge == 0 {
+		opts.MaxRetryAge = DefaultQueueOptions.MaxRetryAge
+	}
+	if opts.DeadLetter == "" {
+		opts.DeadLetter = filepath.Join(opts.Dir, "deadletter.jsonl")
+	}
+	q := &DiskQueue{
+		out:   out,
+		opts:  opts,
+		sizes: make(map[int64]int64),
+		done:  make(chan bool),
+	}
+	q.cond = sync.NewCond(q)
+	return q
+}
+
+func (q *DiskQueue) segmentPath(id int64) string {
+	return filepath.Join(q.opts.Dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// Open opens the underlying output, loads the segments left by a previous run
+// and starts replaying them
+func (q *DiskQueue) Open() error {
+	if q.opts.Dir == "" {
+		return fmt.Errorf("Missing queue directory")
+	}
+	if err := os.MkdirAll(q.opts.Dir, 0700); err != nil {
+		return err
+	}
+	if err := q.out.Open(); err != nil {
+		return err
+	}
+
+	// loading existing segments
+	files, err := ioutil.ReadDir(q.opts.Dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if filepath.Ext(fi.Name()) != segmentExt {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(fi.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, id)
+		q.sizes[id] = fi.Size()
+		q.size += fi.Size()
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+
+	// restoring the replay cursor
+	if data, err := ioutil.ReadFile(filepath.Join(q.opts.Dir, cursorFile)); err == nil {
+		var seg, off int64
+		if _, err := fmt.Sscanf(string(data), "%d %d", &seg, &off); err == nil {
+			for len(q.segments) > 0 && q.segments[0] < seg {
+				q.remove()
+			}
+			if len(q.segments) > 0 && q.segments[0] == seg {
+				q.offset = off
+			}
+		}
+	}
+
+	// a new segment is always started so that a partial line written before
+	// a crash cannot be merged with new events
+	if err := q.rotate(); err != nil {
+		return err
+	}
+	if len(q.segments) > 1 {
+		log.Infof("Replaying %d bytes of events queued in %s", q.size-q.offset, q.opts.Dir)
+	}
+
+	q.wg.Add(1)
+	go q.replay()
+	return nil
+}
+
+// rotate starts a new segment, must be called with the lock held
+func (q *DiskQueue) rotate() error {
+	id := int64(1)
+	if len(q.segments) > 0 {
+		id = q.segments[len(q.segments)-1] + 1
+	}
+	w, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if q.writer != nil {
+		q.writer.Sync()
+		q.writer.Close()
+	}
+	q.writer = w
+	q.segments = append(q.segments, id)
+	q.sizes[id] = 0
+	return nil
+}
+
+// remove deletes the head segment, must be called with the lock held
+func (q *DiskQueue) remove() {
+	head := q.segments[0]
+	if err := os.Remove(q.segmentPath(head)); err != nil {
+		log.Error(err)
+	}
+	q.size -= q.sizes[head]
+	delete(q.sizes, head)
+	q.segments = q.segments[1:]
+	q.offset = 0
+	q.saveCursor()
+	q.cond.Broadcast()
+}
+
+// saveCursor persists the replay position, must be called with the lock held
+func (q *DiskQueue) saveCursor() {
+	if len(q.segments) == 0 {
+		return
+	}
+	path := filepath.Join(q.opts.Dir, cursorFile)
+	data := fmt.Sprintf("%d %d", q.segments[0], q.offset)
+	if err := ioutil.WriteFile(path+".tmp", []byte(data), 0600); err != nil {
+		log.Error(err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Error(err)
+	}
+}
+
+// makeRoom applies the overflow policy, must be called with the lock held
+func (q *DiskQueue) makeRoom(ctx context.Context, n int64) error {
+	if q.opts.MaxSize <= 0 {
+		return nil
+	}
+	for q.size+n > q.opts.MaxSize && q.size > 0 {
+		switch q.opts.Overflow {
+		case OverflowDropNewest:
+			return ErrQueueFull
+		case OverflowDropOldest:
+			if len(q.segments) == 1 {
+				if err := q.rotate(); err != nil {
+					return err
+				}
+			}
+			log.Warnf("Queue full, dropping %d bytes of events", q.sizes[q.segments[0]]-q.offset)
+			q.remove()
+		default:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if q.closed {
+				return ErrQueueClosed
+			}
+			if len(q.segments) == 1 && q.offset >= q.sizes[q.segments[0]] {
+				// the current segment is fully replayed, its space can be freed
+				if err := q.rotate(); err != nil {
+					return err
+				}
+				q.remove()
+				continue
+			}
+			// woken up by remove
+			q.cond.Wait()
+		}
+	}
+	return nil
+}
+
+// Notify implements Notifier, events are delivered when replayed
+func (q *DiskQueue) Notify(f func(delivered, failed int)) {
+	q.notify = f
+}
+
+// notified calls the notify function if any
+func (q *DiskQueue) notified(delivered, failed int) {
+	if q.notify != nil && (delivered > 0 || failed > 0) {
+		q.notify(delivered, failed)
+	}
+}
+
+// Write appends the events to the queue, it returns once the events are
+// written to disk
+func (q *DiskQueue) Write(ctx context.Context, events []*evtx.GoEvtxMap) (err error) {
+	defer func() {
+		if err != nil {
+			q.notified(0, len(events))
+		}
+	}()
+	buf := make([]byte, 0, 1024*len(events))
+	for _, e := range events {
+		buf = append(buf, evtx.ToJSON(e)...)
+		buf = append(buf, '\n')
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return &DeliveryError{events, ErrQueueClosed}
+	}
+	if err := q.makeRoom(ctx, int64(len(buf))); err != nil {
+		return &DeliveryError{events, err}
+	}
+	if q.sizes[q.segments[len(q.segments)-1]] >= q.opts.SegmentSize {
+		if err := q.rotate(); err != nil {
+			return &DeliveryError{events, err}
+		}
+	}
+	n, err := q.writer.Write(buf)
+	q.sizes[q.segments[len(q.segments)-1]] += int64(n)
+	q.size += int64(n)
+	q.cond.Broadcast()
+	if err != nil {
+		return &DeliveryError{events, err}
+	}
+	return nil
+}
+
+// Flush syncs the current segment to disk
+func (q *DiskQueue) Flush(ctx context.Context) error {
+	q.Lock()
+	defer q.Unlock()
+	if q.writer == nil {
+		return nil
+	}
+	return q.writer.Sync()
+}
+
+// Len returns the number of bytes waiting to be replayed
+func (q *DiskQueue) Len() int64 {
+	q.Lock()
+	defer q.Unlock()
+	return q.size - q.offset
+}
+
+// Close waits for the queue to be replayed (at most DrainTimeout), stops the
+// replay and closes the underlying output
+func (q *DiskQueue) Close() error {
+	q.Lock()
+	if q.closed {
+		q.Unlock()
+		return nil
+	}
+	// tolerates a queue which was never opened or failed to open
+	if q.writer != nil {
+		q.writer.Sync()
+	}
+	q.Unlock()
+
+	timeout := time.After(q.opts.DrainTimeout)
+	for q.Len() > 0 {
+		select {
+		case <-timeout:
+			log.Warnf("Queue not fully replayed, %d bytes left in %s", q.Len(), q.opts.Dir)
+		case <-time.After(50 * time.Millisecond):
+			continue
+		}
+		break
+	}
+
+	q.Lock()
+	q.closed = true
+	close(q.done)
+	q.cond.Broadcast()
+	q.Unlock()
+	q.wg.Wait()
+
+	q.Lock()
+	// the empty segment of this run is not needed anymore
+	if n := len(q.segments); n > 1 && q.sizes[q.segments[n-1]] == 0 {
+		os.Remove(q.segmentPath(q.segments[n-1]))
+	}
+	var err error
+	if q.writer != nil {
+		err = q.writer.Close()
+	}
+	q.Unlock()
+
+	if cerr := q.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//////////////////////////////// Replay ////////////////////////////////////////
+
+// next returns the next lines to replay along with the segment and the offset
+// following the lines. It blocks until there is something to replay and
+// returns ok=false when the queue is closed.
+func (q *DiskQueue) next() (lines [][]byte, seg, next int64, ok bool) {
+	q.Lock()
+	for {
+		if q.closed {
+			q.Unlock()
+			return
+		}
+		seg = q.segments[0]
+		last := seg == q.segments[len(q.segments)-1]
+		if q.offset < q.sizes[seg] {
+			break
+		}
+		if !last {
+			// segment fully replayed
+			q.remove()
+			continue
+		}
+		q.cond.Wait()
+	}
+	last := seg == q.segments[len(q.segments)-1]
+	offset := q.offset
+	q.Unlock()
+
+	ok = true
+	next = offset
+	f, err := os.Open(q.segmentPath(seg))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		log.Error(err)
+		return
+	}
+	r := bufio.NewReader(f)
+	for len(lines) < q.opts.BatchSize {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 && !last {
+			// partial line left by a crash
+			lines = append(lines, line)
+			next += int64(len(line))
+		}
+		if err != nil {
+			break
+		}
+		next += int64(len(line))
+		lines = append(lines, line[:len(line)-1])
+	}
+	return
+}
+
+// commit moves the replay cursor after lines were processed
+func (q *DiskQueue) commit(seg, next int64) {
+	q.Lock()
+	defer q.Unlock()
+	// the segment may have been dropped in the meantime
+	if q.segments[0] == seg && next > q.offset {
+		q.offset = next
+		q.saveCursor()
+		q.cond.Broadcast()
+	}
+}
+
+// wait waits for the nth retry delay after err, it returns false if the queue
+// is closed
+func (q *DiskQueue) wait(n int, err error) bool {
+	select {
+	case <-q.done:
+		return false
+	case <-time.After(q.opts.Retry.Delay(n, err)):
+		return true
+	}
+}
+
+func (q *DiskQueue) send(events []*evtx.GoEvtxMap) error {
+	ctx := context.Background()
+	if err := q.out.Write(ctx, events); err != nil {
+		return err
+	}
+	return q.out.Flush(ctx)
+}
+
+// deliver delivers events to the output, it returns false if the queue is
+// closed before the events are delivered
+func (q *DiskQueue) deliver(lines [][]byte) bool {
+	sent, lost := 0, 0
+	defer func() { q.notified(sent, lost) }()
+
+	events := make([]*evtx.GoEvtxMap, 0, len(lines))
+	for _, l := range lines {
+		e := make(evtx.GoEvtxMap)
+		if err := json.Unmarshal(l, &e); err != nil {
+			q.deadLetter(l, err)
+			lost++
+			continue
+		}
+		events = append(events, &e)
+	}
+	if len(events) == 0 {
+		return true
+	}
+
+	// the whole batch is tried first
+	for n := 0; n < q.opts.MaxAttempts; n++ {
+		err := q.send(events)
+		if err == nil {
+			sent += len(events)
+			return true
+		}
+		log.Warnf("Failed to replay %d events: %s", len(events), err)
+		if IsPermanent(err) {
+			break
+		}
+		if !q.wait(n, err) {
+			return false
+		}
+	}
+
+	// events are then tried one by one, in order, to isolate the poison
+	// messages. An event failing MaxAttempts times is considered as poison if
+	// the sink is known to be up: a previous event was delivered or the next
+	// one can be delivered. Otherwise it is considered as poison once it has
+	// been failing for MaxRetryAge.
+	delivered := false
+	since := time.Now()
+	for i, n, failures := 0, 0, 0; i < len(events); {
+		err := q.send(events[i : i+1])
+		if err == nil {
+			delivered = true
+			sent++
+			i, n, failures = i+1, 0, 0
+			since = time.Now()
+			continue
+		}
+		if IsPermanent(err) {
+			q.deadLetter(evtx.ToJSON(events[i]), err)
+			lost++
+			i, failures = i+1, 0
+			since = time.Now()
+			continue
+		}
+		if failures++; failures >= q.opts.MaxAttempts {
+			if !delivered && i+1 < len(events) && q.send(events[i+1:i+2]) == nil {
+				q.deadLetter(evtx.ToJSON(events[i]), err)
+				delivered = true
+				sent, lost = sent+1, lost+1
+				i, n, failures = i+2, 0, 0
+				since = time.Now()
+				continue
+			}
+			if delivered || (q.opts.MaxRetryAge > 0 && time.Since(since) >= q.opts.MaxRetryAge) {
+				q.deadLetter(evtx.ToJSON(events[i]), err)
+				lost++
+				i, failures = i+1, 0
+				since = time.Now()
+				continue
+			}
+		}
+		// the sink is probably down
+		if !q.wait(n, err) {
+			return false
+		}
+		n++
+	}
+	return true
+}
+
+// deadLetter appends a message to the dead-letter file
+func (q *DiskQueue) deadLetter(data []byte, reason error) {
+	log.Errorf("Moving event to dead-letter file %s: %s", q.opts.DeadLetter, reason)
+	entry := map[string]interface{}{
+		"Time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"Error": reason.Error(),
+	}
+	if json.Valid(data) {
+		entry["Event"] = json.RawMessage(data)
+	} else {
+		entry["Data"] = string(data)
+	}
+	f, err := os.OpenFile(q.opts.DeadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(evtx.ToJSON(entry), '\n')); err != nil {
+		log.Error(err)
+	}
+}
+
+// replay routine replaying the queue to the output
+func (q *DiskQueue) replay() {
+	defer q.wg.Done()
+	for {
+		lines, seg, next, ok := q.next()
+		if !ok {
+			return
+		}
+		if len(lines) == 0 {
+			// nothing complete to read yet or read error
+			if !q.wait(0, nil) {
+				return
+			}
+			continue
+		}
+		if !q.deliver(lines) {
+			return
+		}
+		q.commit(seg, next)
+	}
+}
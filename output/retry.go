@@ -0,0 +1,134 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/log"
+)
+
+// RetryPolicy defines how failed deliveries are retried
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt (negative
+	// means retry until the context is done)
+	MaxRetries int
+	// MinBackoff is the delay before the first retry
+	MinBackoff time.Duration
+	// MaxBackoff is the upper bound of the delay between two retries
+	MaxBackoff time.Duration
+}
+
+var (
+	// DefaultRetryPolicy used when no policy is specified
+	DefaultRetryPolicy = RetryPolicy{
+		MaxRetries: 5,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+	}
+)
+
+// Retrier is implemented by the outputs retrying failed deliveries by
+// themselves (ex: only the failed items of a bulk request). A Batcher gives
+// them its retry policy, unless they have their own, and does not retry their
+// batches so that retries are not nested.
+type Retrier interface {
+	SetRetryPolicy(p RetryPolicy)
+}
+
+// PermanentError is an error which must not be retried (malformed event,
+// authentication failure ...)
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Permanent marks an error as not retryable
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{err}
+}
+
+// IsPermanent returns true if the error must not be retried
+func IsPermanent(err error) bool {
+	_, ok := err.(*PermanentError)
+	return ok
+}
+
+// RetryAfterError is a retryable error for which the remote asked to wait
+// before trying again (ex: HTTP Retry-After header)
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+// RetryAfter returns the delay the remote asked to wait for, 0 if none
+func RetryAfter(err error) time.Duration {
+	if ra, ok := err.(*RetryAfterError); ok {
+		return ra.After
+	}
+	return 0
+}
+
+// Backoff returns the delay to wait before the nth retry (starting at 0),
+// it doubles at every retry and has some jitter to avoid synchronized retries
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// jitter between 75% and 100% of the delay
+	return d - time.Duration(rand.Int63n(int64(d)/4+1))
+}
+
+// Delay returns the delay to wait before the nth retry after err, it is the
+// backoff unless the remote asked for a longer delay, which is capped at
+// MaxBackoff
+func (p RetryPolicy) Delay(n int, err error) time.Duration {
+	d := p.Backoff(n)
+	if after := RetryAfter(err); after > d {
+		if p.MaxBackoff > 0 && after > p.MaxBackoff {
+			log.Warnf("Remote asked to retry after %s, waiting only %s", after, p.MaxBackoff)
+			return p.MaxBackoff
+		}
+		return after
+	}
+	return d
+}
+
+// Retry calls f until it succeeds, it returns a permanent error, the number of
+// retries is exhausted or the context is done
+// @ctx : context
+// @f : function to call
+// return error : last error returned by f
+func (p RetryPolicy) Retry(ctx context.Context, f func() error) (err error) {
+	for n := 0; ; n++ {
+		if err = f(); err == nil || IsPermanent(err) {
+			return
+		}
+		if p.MaxRetries >= 0 && n >= p.MaxRetries {
+			return fmt.Errorf("Giving up after %d retries: %s", n, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s (last error: %s)", ctx.Err(), err)
+		case <-time.After(p.Delay(n, err)):
+		}
+	}
+}
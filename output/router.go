@@ -0,0 +1,234 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// Matcher is implemented by the event filters (query.Query, xpath.Query ...)
+type Matcher interface {
+	Match(e *evtx.GoEvtxMap) bool
+}
+
+// fieldPath returns the path of a field given relative to the Event node (ex:
+// EventData/TargetUserName) or absolute if it starts with a / (ex: /Meta/File)
+func fieldPath(field string) evtx.GoEvtxPath {
+	if strings.HasPrefix(field, evtx.PathSeparator) {
+		return evtx.Path(field)
+	}
+	return append(evtx.GoEvtxPath{"Event"}, evtx.Path(field)...)
+}
+
+// detach returns a shallow copy of m in which the maps along path are copied
+// too, so that the element at path can be set or deleted without modifying m.
+// Missing maps along path are created.
+func detach(m evtx.GoEvtxMap, path evtx.GoEvtxPath) evtx.GoEvtxMap {
+	c := make(evtx.GoEvtxMap, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	if len(path) > 1 {
+		var child evtx.GoEvtxMap
+		switch v := c[path[0]].(type) {
+		case evtx.GoEvtxMap:
+			child = v
+		case map[string]interface{}:
+			child = v
+		}
+		c[path[0]] = detach(child, path[1:])
+	}
+	return c
+}
+
+// RouteStats are the counters of a route
+type RouteStats struct {
+	Name string
+	// Matched is the number of events selected by the route filter
+	Matched uint64
+	// Delivered and Failed are the numbers of events delivered or lost by the
+	// output of the route, the events buffered by the output are not counted
+	// yet (see Notifier)
+	Delivered uint64
+	Failed    uint64
+}
+
+// Route sends the events matching Filter to Output, after having dropped and
+// renamed the fields given by Drop and Rename. Fields are paths relative to
+// the Event node or absolute if they start with a /. Events are copied before
+// being transformed so that the other routes see the original events.
+type Route struct {
+	drop   []evtx.GoEvtxPath
+	rename [][2]evtx.GoEvtxPath
+	stats  RouteStats
+	// delivery counted by the output (see Notifier)
+	notified bool
+	Name     string
+	// Filter selecting the events, all the events go through the route if nil
+	Filter Matcher
+	// Drop is the list of the fields removed from the events
+	Drop []string
+	// Rename maps fields to their new name
+	Rename map[string]string
+	Output Output
+}
+
+// transform applies the drop and rename transforms to an event
+func (r *Route) transform(e *evtx.GoEvtxMap) *evtx.GoEvtxMap {
+	m := *e
+	for _, p := range r.drop {
+		if _, err := m.Get(&p); err == nil {
+			m = detach(m, p)
+			m.Del(&p)
+		}
+	}
+	for _, rn := range r.rename {
+		from, to := rn[0], rn[1]
+		v, err := m.Get(&from)
+		if err != nil {
+			continue
+		}
+		value := *v
+		m = detach(m, from)
+		m.Del(&from)
+		m = detach(m, to)
+		m.Set(&to, value)
+	}
+	return &m
+}
+
+// Stats returns the counters of the route
+func (r *Route) Stats() RouteStats {
+	return RouteStats{
+		Name:      r.Name,
+		Matched:   atomic.LoadUint64(&r.stats.Matched),
+		Delivered: atomic.LoadUint64(&r.stats.Delivered),
+		Failed:    atomic.LoadUint64(&r.stats.Failed),
+	}
+}
+
+// Router is an Output sending every event to all the routes it matches
+// (fan-out), an event matching no route is dropped. The output of a route
+// failing does not prevent the other routes from receiving the events, so
+// that the outputs of the routes must be wrapped into their own Batcher (or
+// DiskQueue) to be retried rather than the Router itself: retrying the Router
+// would deliver the events again to the routes which succeeded.
+type Router struct {
+	Routes []*Route
+}
+
+// NewRouter creates a new Router
+func NewRouter(routes ...*Route) *Router {
+	return &Router{Routes: routes}
+}
+
+// Open checks the routes and opens their outputs
+func (r *Router) Open() (err error) {
+	names := make(map[string]bool)
+	for i, route := range r.Routes {
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route%d", i)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("Duplicate route name: %s", route.Name)
+		}
+		names[route.Name] = true
+		if route.Output == nil {
+			return fmt.Errorf("Route %s has no output", route.Name)
+		}
+		route.stats.Name = route.Name
+		route.drop = make([]evtx.GoEvtxPath, 0, len(route.Drop))
+		for _, f := range route.Drop {
+			route.drop = append(route.drop, fieldPath(f))
+		}
+		route.rename = make([][2]evtx.GoEvtxPath, 0, len(route.Rename))
+		for from, to := range route.Rename {
+			route.rename = append(route.rename, [2]evtx.GoEvtxPath{fieldPath(from), fieldPath(to)})
+		}
+	}
+	for i, route := range r.Routes {
+		if n, ok := route.Output.(Notifier); ok {
+			stats := &route.stats
+			n.Notify(func(delivered, failed int) {
+				atomic.AddUint64(&stats.Delivered, uint64(delivered))
+				atomic.AddUint64(&stats.Failed, uint64(failed))
+			})
+			route.notified = true
+		}
+		if err = route.Output.Open(); err != nil {
+			for _, opened := range r.Routes[:i] {
+				opened.Output.Close()
+			}
+			return fmt.Errorf("Can't open output of route %s: %s", route.Name, err)
+		}
+	}
+	return nil
+}
+
+// Write sends the events to the routes they match, it returns an error if any
+// of the routes failed
+func (r *Router) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	failed := make([]string, 0)
+	for _, route := range r.Routes {
+		selected := make([]*evtx.GoEvtxMap, 0, len(events))
+		for _, e := range events {
+			if route.Filter == nil || route.Filter.Match(e) {
+				selected = append(selected, route.transform(e))
+			}
+		}
+		if len(selected) == 0 {
+			continue
+		}
+		n := uint64(len(selected))
+		atomic.AddUint64(&route.stats.Matched, n)
+		// buffering outputs count the events of the batches they process,
+		// an error may come from a previous batch
+		err := route.Output.Write(ctx, selected)
+		switch {
+		case route.notified:
+		case err != nil:
+			atomic.AddUint64(&route.stats.Failed, n)
+		default:
+			atomic.AddUint64(&route.stats.Delivered, n)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", route.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to deliver events to routes %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Flush flushes the outputs of all the routes
+func (r *Router) Flush(ctx context.Context) error {
+	return r.each(func(route *Route) error { return route.Output.Flush(ctx) })
+}
+
+// Close closes the outputs of all the routes
+func (r *Router) Close() error {
+	return r.each(func(route *Route) error { return route.Output.Close() })
+}
+
+// each calls f on all the routes, it returns the first error
+func (r *Router) each(f func(*Route) error) (err error) {
+	for _, route := range r.Routes {
+		if rerr := f(route); rerr != nil && err == nil {
+			err = fmt.Errorf("Route %s: %s", route.Name, rerr)
+		}
+	}
+	return
+}
+
+// Stats returns the counters of the routes
+func (r *Router) Stats() []RouteStats {
+	stats := make([]RouteStats, 0, len(r.Routes))
+	for _, route := range r.Routes {
+		stats = append(stats, route.Stats())
+	}
+	return stats
+}
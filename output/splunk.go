@@ -0,0 +1,197 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// SplunkHEC sends events to a Splunk HTTP Event Collector. Events of a batch
+// are sent in a single gzip compressed request. When UseAck is set, indexer
+// acknowledgment is used and Write returns only once the events are indexed.
+type SplunkHEC struct {
+	client  *http.Client
+	channel string
+	// URL of the collector (ex: https://splunk:8088)
+	URL   string
+	Token string
+	// Source of the events, the file the event comes from (Meta/File) is used
+	// if empty
+	Source     string
+	Sourcetype string
+	Index      string
+	// UseAck enables indexer acknowledgment
+	UseAck bool
+	// AckTimeout is the maximum time to wait for an acknowledgment
+	AckTimeout time.Duration
+	// AckInterval is the polling interval of the acknowledgments
+	AckInterval time.Duration
+	Tag         string
+	TLS         TLSConfig
+	Timeout     time.Duration
+}
+
+// hecEvent is the HEC envelope
+type hecEvent struct {
+	Time       json.Number     `json:"time"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Sourcetype string          `json:"sourcetype,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// uuid4 generates a random UUID used as HEC channel
+func uuid4() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Open checks the configuration and creates the HTTP client
+func (s *SplunkHEC) Open() (err error) {
+	if s.URL == "" || s.Token == "" {
+		return fmt.Errorf("Splunk HEC output needs an URL and a token")
+	}
+	s.URL = strings.TrimRight(s.URL, "/")
+	if s.Sourcetype == "" {
+		s.Sourcetype = "_json"
+	}
+	if s.AckTimeout == 0 {
+		s.AckTimeout = time.Minute
+	}
+	if s.AckInterval == 0 {
+		s.AckInterval = time.Second
+	}
+	s.channel = uuid4()
+	s.client, err = newHTTPClient(&s.TLS, s.Timeout)
+	return
+}
+
+// Envelope wraps an event into the HEC envelope
+func (s *SplunkHEC) Envelope(e *evtx.GoEvtxMap) []byte {
+	t := eventTime(e)
+	source := s.Source
+	if source == "" {
+		source = getString(e, MetaFilePath)
+	}
+	return evtx.ToJSON(hecEvent{
+		Time:       json.Number(strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)),
+		Host:       getString(e, computerPath),
+		Source:     source,
+		Sourcetype: s.Sourcetype,
+		Index:      s.Index,
+		Event:      evtx.ToJSON(mark(e, s.Tag)),
+	})
+}
+
+func (s *SplunkHEC) post(ctx context.Context, path string, body []byte, compress bool) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(body)
+	if compress {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		w.Write(body)
+		w.Close()
+		r = buf
+	}
+	req, err := http.NewRequest("POST", s.URL+path, r)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Splunk "+s.Token)
+	req.Header.Set("X-Splunk-Request-Channel", s.channel)
+	req.Header.Set("Content-Type", "application/json")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Can't connect to Splunk HEC %s: %s", s.URL, err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(s.URL+path, resp); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// waitAck polls the ack endpoint until the request is indexed
+func (s *SplunkHEC) waitAck(ctx context.Context, ackID int64) error {
+	body := evtx.ToJSON(map[string][]int64{"acks": {ackID}})
+	timeout := time.After(s.AckTimeout)
+	for {
+		data, err := s.post(ctx, "/services/collector/ack?channel="+s.channel, body, false)
+		if err != nil {
+			return err
+		}
+		var resp struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return fmt.Errorf("Bad ack response from %s: %s", s.URL, err)
+		}
+		if resp.Acks[strconv.FormatInt(ackID, 10)] {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("No acknowledgment from %s for ack %d after %s", s.URL, ackID, s.AckTimeout)
+		case <-time.After(s.AckInterval):
+		}
+	}
+}
+
+// Write sends the events in a single request
+func (s *SplunkHEC) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if len(events) == 0 {
+		return nil
+	}
+	body := new(bytes.Buffer)
+	for _, e := range events {
+		body.Write(s.Envelope(e))
+		body.WriteByte('\n')
+	}
+	data, err := s.post(ctx, "/services/collector/event", body.Bytes(), true)
+	if err != nil {
+		return err
+	}
+	if !s.UseAck {
+		return nil
+	}
+	var resp struct {
+		AckID *int64 `json:"ackId"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil || resp.AckID == nil {
+		return Permanent(fmt.Errorf("No ackId in response from %s, indexer acknowledgment may be disabled: %s", s.URL, data))
+	}
+	return s.waitAck(ctx, *resp.AckID)
+}
+
+// Flush does nothing as Write is synchronous
+func (s *SplunkHEC) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close releases idle connections
+func (s *SplunkHEC) Close() error {
+	if s.client != nil {
+		s.client.CloseIdleConnections()
+	}
+	return nil
+}
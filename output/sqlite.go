@@ -0,0 +1,261 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	// registers the pure Go sqlite driver, no cgo needed for cross-compiling
+	_ "modernc.org/sqlite"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+const (
+	// fixed width so that times sort as strings, understood by SQLite date
+	// functions
+	sqliteTimeLayout = "2006-01-02T15:04:05.000000Z"
+
+	sqliteSchema = `
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY,
+	uid TEXT NOT NULL UNIQUE,
+	time TEXT,
+	record_id INTEGER,
+	event_id INTEGER,
+	channel TEXT,
+	provider TEXT,
+	computer TEXT,
+	json TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_time ON events(time);
+CREATE INDEX IF NOT EXISTS events_record_id ON events(record_id);
+CREATE INDEX IF NOT EXISTS events_event_id ON events(event_id);
+CREATE INDEX IF NOT EXISTS events_channel ON events(channel);
+CREATE INDEX IF NOT EXISTS events_provider ON events(provider);
+CREATE INDEX IF NOT EXISTS events_computer ON events(computer);
+CREATE TABLE IF NOT EXISTS event_fields (
+	event_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	PRIMARY KEY (event_id, name)
+);`
+)
+
+var (
+	eventDataPath = evtx.Path("/Event/EventData")
+
+	// columns of the events table exposed in the views
+	sqliteViewColumns = []string{"id", "time", "record_id", "event_id", "channel", "provider", "computer"}
+)
+
+// sqliteIdent quotes an SQL identifier
+func sqliteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// sqliteString quotes an SQL string literal
+func sqliteString(s string) string {
+	return `'` + strings.Replace(s, `'`, `''`, -1) + `'`
+}
+
+// SQLite writes events into an SQLite database. Events go into the events
+// table, with indexed columns and the full JSON event, and an event_<EventID>
+// view is maintained for every event ID exposing the EventData fields as
+// columns. Events already in the database (same computer, channel and record
+// ID) are skipped so that several files can be loaded into the same database.
+type SQLite struct {
+	db     *sql.DB
+	fields map[int64]map[string]bool
+	dirty  map[int64]bool
+	// Path of the database, created if needed
+	Path string
+}
+
+// Open opens the database and creates the schema
+func (s *SQLite) Open() (err error) {
+	if s.Path == "" {
+		return fmt.Errorf("Missing SQLite database path")
+	}
+	if s.db, err = sql.Open("sqlite", s.Path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"); err != nil {
+		return
+	}
+	// a single connection, SQLite does not handle concurrent writers
+	s.db.SetMaxOpenConns(1)
+	if _, err = s.db.Exec(sqliteSchema); err != nil {
+		s.db.Close()
+		return Permanent(fmt.Errorf("Failed to create SQLite schema in %s: %s", s.Path, err))
+	}
+	s.fields = make(map[int64]map[string]bool)
+	s.dirty = make(map[int64]bool)
+	return nil
+}
+
+// null returns nil for empty values so that they are stored as NULL
+func null(v interface{}, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	if s, isString := v.(string); isString && s == "" {
+		return nil
+	}
+	return v
+}
+
+// Write inserts the events in a single transaction, duplicates are skipped
+func (s *SQLite) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insert, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO events
+		(uid, time, record_id, event_id, channel, provider, computer, json)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	// fields are known only once committed, so that a failed attempt does
+	// not hide them from the next one
+	newFields := make(map[int64]map[string]bool)
+	for _, e := range events {
+		var t interface{}
+		if st, err := e.GetTime(&evtx.SystemTimePath); err == nil {
+			t = st.UTC().Format(sqliteTimeLayout)
+		}
+		data := evtx.ToJSON(e)
+		recordID, rerr := e.GetInt(&evtx.EventRecordIDPath)
+		eid := eventID(e)
+		_, err := insert.ExecContext(ctx,
+			DocumentID(e),
+			t,
+			null(recordID, rerr == nil),
+			null(eid, eid >= 0),
+			null(getString(e, evtx.ChannelPath), true),
+			null(getString(e, providerPath), true),
+			null(getString(e, computerPath), true),
+			string(data))
+		if err != nil {
+			return fmt.Errorf("Failed to insert event into %s: %s", s.Path, err)
+		}
+
+		// fields of the views
+		if eid < 0 {
+			continue
+		}
+		if ed, err := e.Get(&eventDataPath); err == nil {
+			if m, ok := (*ed).(evtx.GoEvtxMap); ok {
+				for name := range m {
+					if s.fields[eid][name] || newFields[eid][name] {
+						continue
+					}
+					if newFields[eid] == nil {
+						newFields[eid] = make(map[string]bool)
+					}
+					newFields[eid][name] = true
+				}
+			}
+		}
+	}
+
+	field, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO event_fields (event_id, name) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer field.Close()
+	dirty := make(map[int64]bool)
+	for eid, names := range newFields {
+		for name := range names {
+			res, err := field.ExecContext(ctx, eid, name)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				dirty[eid] = true
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for eid, names := range newFields {
+		if s.fields[eid] == nil {
+			s.fields[eid] = make(map[string]bool)
+		}
+		for name := range names {
+			s.fields[eid][name] = true
+		}
+	}
+	for eid := range dirty {
+		s.dirty[eid] = true
+	}
+	return nil
+}
+
+// updateView (re)creates the view of an event ID out of its known fields
+func (s *SQLite) updateView(ctx context.Context, eid int64) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM event_fields WHERE event_id = ?", eid)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	sort.Strings(names)
+
+	used := make(map[string]bool)
+	columns := make([]string, 0, len(sqliteViewColumns)+len(names))
+	for _, c := range sqliteViewColumns {
+		used[c] = true
+		columns = append(columns, c)
+	}
+	for _, name := range names {
+		alias := name
+		if used[strings.ToLower(alias)] {
+			alias = "EventData_" + name
+		}
+		used[strings.ToLower(alias)] = true
+		path := `$.Event.EventData."` + strings.Replace(name, `"`, `\"`, -1) + `"`
+		columns = append(columns, fmt.Sprintf("json_extract(json, %s) AS %s", sqliteString(path), sqliteIdent(alias)))
+	}
+	view := sqliteIdent(fmt.Sprintf("event_%d", eid))
+	query := fmt.Sprintf("DROP VIEW IF EXISTS %s; CREATE VIEW %s AS SELECT %s FROM events WHERE event_id = %d",
+		view, view, strings.Join(columns, ", "), eid)
+	_, err = s.db.ExecContext(ctx, query)
+	return err
+}
+
+// Flush updates the views of the event IDs having new fields
+func (s *SQLite) Flush(ctx context.Context) error {
+	for eid := range s.dirty {
+		if err := s.updateView(ctx, eid); err != nil {
+			return fmt.Errorf("Failed to update view of event %d in %s: %s", eid, s.Path, err)
+		}
+		delete(s.dirty, eid)
+	}
+	return nil
+}
+
+// Close updates the views and closes the database
+func (s *SQLite) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.Flush(context.Background())
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	s.db = nil
+	return err
+}
@@ -0,0 +1,234 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// Syslog severities
+const (
+	sevEmergency = iota
+	sevAlert
+	sevCritical
+	sevError
+	sevWarning
+	sevNotice
+	sevInformational
+	sevDebug
+)
+
+var (
+	// maps Windows levels to syslog severities
+	syslogSeverities = map[int64]int{
+		levelLogAlways:   sevInformational,
+		levelCritical:    sevCritical,
+		levelError:       sevError,
+		levelWarning:     sevWarning,
+		levelInformation: sevInformational,
+		levelVerbose:     sevDebug,
+	}
+
+	// SyslogFacilities maps facility names to their code
+	SyslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+		"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+		"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+		"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+		"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+)
+
+const (
+	// structured data ID, 32473 is the private enterprise number reserved
+	// for documentation purposes
+	syslogSDID = "evtx@32473"
+)
+
+// Syslog sends events as RFC 5424 messages over UDP, TCP or TLS. On TCP and
+// TLS messages are framed with octet-counting (RFC 6587). The connection is
+// re-established on the next write when it fails.
+type Syslog struct {
+	conn net.Conn
+	tls  *tls.Config
+	// Network is one of udp, tcp or tls
+	Network string
+	Address string
+	// Facility code (see SyslogFacilities)
+	Facility int
+	// AppName of the messages, default is evtx
+	AppName string
+	// Format of the message body, json (default) or kv (key="value")
+	Format string
+	// Hostname used when the event has no Computer
+	Hostname string
+	Tag      string
+	TLS      TLSConfig
+	Timeout  time.Duration
+}
+
+// Open checks the configuration and connects to the server
+func (s *Syslog) Open() (err error) {
+	s.Network = strings.ToLower(s.Network)
+	switch s.Network {
+	case "":
+		s.Network = "udp"
+	case "udp", "tcp":
+	case "tls":
+		if s.tls, err = s.TLS.Config(); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("Unknown syslog network: %s", s.Network)
+	}
+	switch s.Format {
+	case "":
+		s.Format = "json"
+	case "json", "kv":
+	default:
+		return fmt.Errorf("Unknown syslog format: %s", s.Format)
+	}
+	if s.AppName == "" {
+		s.AppName = "evtx"
+	}
+	if s.Hostname == "" {
+		s.Hostname, _ = os.Hostname()
+	}
+	if s.Timeout == 0 {
+		s.Timeout = 10 * time.Second
+	}
+	return s.connect()
+}
+
+func (s *Syslog) connect() (err error) {
+	dialer := &net.Dialer{Timeout: s.Timeout}
+	switch s.Network {
+	case "tls":
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.Address, s.tls)
+	default:
+		s.conn, err = dialer.Dial(s.Network, s.Address)
+	}
+	if err != nil {
+		s.conn = nil
+		return fmt.Errorf("Can't connect to syslog server %s: %s", s.Address, err)
+	}
+	return nil
+}
+
+// header field as defined in RFC 5424: printable ASCII, no space, "-" if
+// empty
+func header(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > 32 && s[i] < 127 {
+			b = append(b, s[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// escapes a structured data parameter value
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// Format5424 formats an event as an RFC 5424 message (without framing)
+func (s *Syslog) Format5424(e *evtx.GoEvtxMap) []byte {
+	m := mark(e, s.Tag)
+	pri := s.Facility*8 + sevInformational
+	if sev, ok := syslogSeverities[eventLevel(e)]; ok {
+		pri = s.Facility*8 + sev
+	}
+	hostname := getString(e, computerPath)
+	if hostname == "" {
+		hostname = s.Hostname
+	}
+	procid := "-"
+	if pid, err := e.GetInt(&executionPIDPath); err == nil {
+		procid = strconv.FormatInt(pid, 10)
+	}
+	msgid := "-"
+	if eid := eventID(e); eid >= 0 {
+		msgid = strconv.FormatInt(eid, 10)
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %s %s ", pri,
+		eventTime(e).UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		header(hostname, 255),
+		header(s.AppName, 48),
+		header(procid, 128),
+		header(msgid, 32))
+
+	// structured data
+	fmt.Fprintf(buf, `[%s channel="%s" provider="%s" recordID="%s"]`, syslogSDID,
+		sdEscaper.Replace(getString(e, evtx.ChannelPath)),
+		sdEscaper.Replace(getString(e, providerPath)),
+		sdEscaper.Replace(getString(e, evtx.EventRecordIDPath)))
+
+	buf.WriteByte(' ')
+	if s.Format == "kv" {
+		buf.WriteString(KeyValue(&m))
+	} else {
+		buf.Write(evtx.ToJSON(m))
+	}
+	return buf.Bytes()
+}
+
+// Write sends the events, one message per event
+func (s *Syslog) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if s.conn != nil && s.Network != "udp" && closed(s.conn) {
+		// closed by the server while idle, the first write would succeed
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(s.Timeout)
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	s.conn.SetWriteDeadline(deadline)
+
+	for _, e := range events {
+		msg := s.Format5424(e)
+		if s.Network != "udp" {
+			// octet-counting framing
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		if _, err := s.conn.Write(msg); err != nil {
+			// reconnect at next write
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("Failed to write to syslog server %s: %s", s.Address, err)
+		}
+	}
+	return nil
+}
+
+// Flush does nothing as messages are written directly on the connection
+func (s *Syslog) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the connection
+func (s *Syslog) Close() error {
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
@@ -1,32 +1,255 @@
 package output
 
 import (
-	"encoding/json"
-	"errors"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/log"
 
 	"github.com/0xrawsec/golang-evtx/evtx"
 )
 
+var (
+	// TcpFramings are the available ways of delimiting events on the stream
+	TcpFramings = []string{"newline", "octet", "length"}
+)
+
+// tcpTarget is one of the addresses of a TcpJSON output
+type tcpTarget struct {
+	address string
+	conn    net.Conn
+	w       *bufio.Writer
+	// consecutive connection failures and time of the next attempt
+	failures int
+	next     time.Time
+}
+
+// TcpJSON sends events as JSON over TCP or TLS connections. The events of a
+// batch go to one of the addresses, in turn, so that the load is spread over
+// them. A broken connection is closed and re-established with backoff (see
+// Reconnect), the batch being sent to the next address available.
 type TcpJSON struct {
-	out *json.Encoder
-	Tag string
+	tls     *tls.Config
+	targets []*tcpTarget
+	current int
+	// Address is a comma separated list of host:port
+	Address string
+	// Network is tcp (default) or tls
+	Network string
+	// Framing is one of TcpFramings: newline (default) delimited JSON,
+	// octet-counting (RFC 6587) or 4 bytes big endian length prefix
+	Framing string
+	Tag     string
+	TLS     TLSConfig
+	// Timeout is the dial timeout, default is 10s
+	Timeout time.Duration
+	// WriteTimeout is the deadline of a batch write when the context has
+	// none, no deadline if zero
+	WriteTimeout time.Duration
+	// KeepAlive is the TCP keepalive period, default is the system one and
+	// negative disables keepalives
+	KeepAlive time.Duration
+	// Reconnect is the backoff between two connection attempts to an address,
+	// default is DefaultRetryPolicy (MaxRetries is not used)
+	Reconnect RetryPolicy
 }
 
-func (tj *TcpJSON) Open(url string) error {
-	conn, err := net.Dial("tcp", url)
+// Open checks the configuration and connects to the addresses, it fails only
+// if none of them is reachable
+func (tj *TcpJSON) Open() (err error) {
+	switch tj.Network {
+	case "":
+		tj.Network = "tcp"
+	case "tcp":
+	case "tls":
+		if tj.tls, err = tj.TLS.Config(); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("Unknown TCP network: %s", tj.Network)
+	}
+	switch tj.Framing {
+	case "":
+		tj.Framing = "newline"
+	case "newline", "octet", "length":
+	default:
+		return fmt.Errorf("Unknown TCP framing: %s", tj.Framing)
+	}
+	if tj.Timeout == 0 {
+		tj.Timeout = 10 * time.Second
+	}
+	if tj.Reconnect.MinBackoff == 0 && tj.Reconnect.MaxBackoff == 0 {
+		tj.Reconnect = DefaultRetryPolicy
+	}
+
+	tj.targets = make([]*tcpTarget, 0)
+	for _, a := range strings.Split(tj.Address, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			tj.targets = append(tj.targets, &tcpTarget{address: a})
+		}
+	}
+	if len(tj.targets) == 0 {
+		return fmt.Errorf("Missing remote tcp log server address")
+	}
+
+	for _, t := range tj.targets {
+		if err = tj.connect(t); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Can't connect to any remote tcp log server: %s", err)
+	}
+	// connections to the other addresses are made when they are used
+	for _, t := range tj.targets {
+		t.failures, t.next = 0, time.Time{}
+	}
+	return nil
+}
+
+func (tj *TcpJSON) connect(t *tcpTarget) (err error) {
+	dialer := &net.Dialer{Timeout: tj.Timeout, KeepAlive: tj.KeepAlive}
+	if tj.Network == "tls" {
+		t.conn, err = tls.DialWithDialer(dialer, "tcp", t.address, tj.tls)
+	} else {
+		t.conn, err = dialer.Dial("tcp", t.address)
+	}
 	if err != nil {
-		return errors.New(fmt.Sprintf("Can't connect to remote tcp log server: %s", url))
+		t.conn = nil
+		tj.failed(t)
+		return fmt.Errorf("Can't connect to remote tcp log server %s: %s", t.address, err)
+	}
+	if t.failures > 0 {
+		log.Infof("Reconnected to remote tcp log server %s", t.address)
 	}
-	tj.out = json.NewEncoder(conn)
+	t.failures, t.next = 0, time.Time{}
+	t.w = bufio.NewWriter(t.conn)
+	return nil
+}
+
+// failed closes the connection of a target and delays the next attempt
+func (tj *TcpJSON) failed(t *tcpTarget) {
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn, t.w = nil, nil
+	}
+	t.next = time.Now().Add(tj.Reconnect.Backoff(t.failures))
+	t.failures++
+}
+
+// closed returns true if the peer closed the connection, the servers are not
+// expected to send anything so that any outcome but a timeout means the
+// connection is unusable. It catches the connections closed while idle which
+// would otherwise accept the first write. The deadline must be in the future
+// for the read to be attempted at all.
+func closed(conn net.Conn) bool {
+	var b [1]byte
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	_, err := conn.Read(b[:])
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return true
+}
+
+// frame writes an event with the configured framing
+func (tj *TcpJSON) frame(w *bufio.Writer, data []byte) (err error) {
+	switch tj.Framing {
+	case "octet":
+		w.WriteString(strconv.Itoa(len(data)))
+		w.WriteByte(' ')
+	case "length":
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+		w.Write(size[:])
+	}
+	if _, err = w.Write(data); err != nil {
+		return
+	}
+	if tj.Framing == "newline" {
+		err = w.WriteByte('\n')
+	}
+	return
+}
+
+func (tj *TcpJSON) send(ctx context.Context, t *tcpTarget, events []*evtx.GoEvtxMap) error {
+	if t.conn == nil {
+		if err := tj.connect(t); err != nil {
+			return err
+		}
+	} else if closed(t.conn) {
+		tj.failed(t)
+		return fmt.Errorf("Connection to remote tcp log server %s was closed", t.address)
+	}
+
+	deadline := time.Time{}
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	} else if tj.WriteTimeout > 0 {
+		deadline = time.Now().Add(tj.WriteTimeout)
+	}
+	t.conn.SetWriteDeadline(deadline)
+
+	for _, e := range events {
+		if err := tj.frame(t.w, evtx.ToJSON(mark(e, tj.Tag))); err != nil {
+			tj.failed(t)
+			return fmt.Errorf("Failed to write to remote tcp log server %s: %s", t.address, err)
+		}
+	}
+	if err := t.w.Flush(); err != nil {
+		tj.failed(t)
+		return fmt.Errorf("Failed to write to remote tcp log server %s: %s", t.address, err)
+	}
+	return nil
+}
+
+// Write sends the events to the next address, the other addresses are tried
+// if it fails. Addresses waiting to be reconnected are skipped.
+func (tj *TcpJSON) Write(ctx context.Context, events []*evtx.GoEvtxMap) error {
+	if len(tj.targets) == 0 {
+		return fmt.Errorf("TCP output is not open")
+	}
+	var err error
+	now := time.Now()
+	for i := 0; i < len(tj.targets); i++ {
+		t := tj.targets[(tj.current+i)%len(tj.targets)]
+		if t.conn == nil && now.Before(t.next) {
+			if err == nil {
+				err = fmt.Errorf("Remote tcp log server %s is unavailable until %s", t.address, t.next.Format(time.RFC3339))
+			}
+			continue
+		}
+		if err = tj.send(ctx, t, events); err == nil {
+			tj.current = (tj.current + i + 1) % len(tj.targets)
+			return nil
+		}
+		log.Warn(err)
+	}
+	return err
+}
+
+// Flush does nothing as events are written at every Write
+func (tj *TcpJSON) Flush(ctx context.Context) error {
 	return nil
 }
 
-func (tj *TcpJSON) Request(message *evtx.GoEvtxMap) {
-	mark := evtx.GoEvtxMap{
-		"tags": tj.Tag,
+// Close closes the connections
+func (tj *TcpJSON) Close() (err error) {
+	for _, t := range tj.targets {
+		if t.conn != nil {
+			if cerr := t.conn.Close(); err == nil {
+				err = cerr
+			}
+			t.conn, t.w = nil, nil
+		}
 	}
-	message.Add(mark)
-	tj.out.Encode(message)
+	return
 }
@@ -0,0 +1,140 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+var (
+	// TemplateAliases are the short names usable in templates
+	TemplateAliases = map[string]evtx.GoEvtxPath{
+		"Channel":  evtx.ChannelPath,
+		"Computer": computerPath,
+		"Provider": providerPath,
+		"RecordID": evtx.EventRecordIDPath,
+		"Level":    levelPath,
+		"UserID":   evtx.UserIDPath,
+	}
+
+	// date layout elements sorted so that longest are replaced first
+	dateLayout = []struct{ from, to string }{
+		{"yyyy", "2006"}, {"yy", "06"}, {"MM", "01"}, {"dd", "02"},
+		{"HH", "15"}, {"mm", "04"}, {"ss", "05"},
+	}
+)
+
+// Template is a string with placeholders replaced by values of the events.
+// Placeholders are enclosed in curly braces and can be:
+//   - an alias (Channel, Computer, Provider, RecordID, Level, UserID)
+//   - EventID
+//   - a path in the event (ex: {EventData/TargetUserName})
+//   - a date layout applied to the event time (ex: {yyyy.MM.dd}, {yyyy-MM-dd/HH})
+type Template struct {
+	src   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	path    evtx.GoEvtxPath
+	date    string
+	eventID bool
+}
+
+func isDateLayout(s string) bool {
+	return strings.Trim(s, "yMdHms.-_/: ") == "" && strings.ContainsAny(s, "yMdHms")
+}
+
+// ParseTemplate parses a template
+// @s : template string
+// return (*Template, error)
+func ParseTemplate(s string) (*Template, error) {
+	t := &Template{src: s}
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			t.parts = append(t.parts, templatePart{literal: s})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, templatePart{literal: s[:i]})
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("Unclosed placeholder in template: %s", t.src)
+		}
+		name := s[i+1 : i+j]
+		s = s[i+j+1:]
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("Empty placeholder in template: %s", t.src)
+		case name == "EventID":
+			t.parts = append(t.parts, templatePart{eventID: true})
+		case TemplateAliases[name] != nil:
+			t.parts = append(t.parts, templatePart{path: TemplateAliases[name]})
+		case isDateLayout(name):
+			layout := name
+			for _, l := range dateLayout {
+				layout = strings.Replace(layout, l.from, l.to, -1)
+			}
+			t.parts = append(t.parts, templatePart{date: layout})
+		default:
+			t.parts = append(t.parts, templatePart{path: append(evtx.GoEvtxPath{"Event"}, evtx.Path(name)...)})
+		}
+	}
+	return t, nil
+}
+
+// MustParseTemplate parses a template and panics on error
+func MustParseTemplate(s string) *Template {
+	t, err := ParseTemplate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the source of the template
+func (t *Template) String() string {
+	return t.src
+}
+
+// Execute builds a string out of the template and an event. Values are passed
+// to clean (if not nil) before being used, a missing value is replaced by
+// "unknown". Dates are not passed to clean.
+// @e : event
+// @clean : function cleaning the values
+// return string
+func (t *Template) Execute(e *evtx.GoEvtxMap, clean func(string) string) string {
+	b := strings.Builder{}
+	for _, p := range t.parts {
+		var v string
+		switch {
+		case p.literal != "":
+			b.WriteString(p.literal)
+			continue
+		case p.eventID:
+			if eid := eventID(e); eid >= 0 {
+				v = fmt.Sprintf("%d", eid)
+			}
+		case p.date != "":
+			// dates are not cleaned as they may contain separators on purpose
+			b.WriteString(eventTime(e).UTC().Format(p.date))
+			continue
+		default:
+			if elt, err := e.Get(&p.path); err == nil {
+				v = ValueString(*elt)
+			}
+		}
+		if clean != nil {
+			v = clean(v)
+		}
+		if v == "" {
+			v = "unknown"
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
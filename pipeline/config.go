@@ -0,0 +1,305 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/0xrawsec/golang-evtx/normalize"
+	"github.com/0xrawsec/golang-evtx/output"
+)
+
+var (
+	// ${NAME} references to environment variables, the $NAME form is not
+	// expanded as $ is common in regular expressions
+	envVarRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// InputConfig lists the files to process
+type InputConfig struct {
+	// Files are paths or glob patterns (ex: logs/*.evtx)
+	Files []string `yaml:"files"`
+	// Directories are walked recursively for .evtx files
+	Directories []string `yaml:"directories"`
+	// Carve are the files (disk images, memory dumps ...) carved for chunks
+	Carve []string `yaml:"carve"`
+	// Merge merges the events of all the files into a single stream ordered
+	// by time
+	Merge bool `yaml:"merge"`
+}
+
+// FilterConfig selects the events processed (see Filter)
+type FilterConfig struct {
+	Query string `yaml:"query"`
+	XPath string `yaml:"xpath"`
+}
+
+// TransformConfig are the transforms applied to all the events
+type TransformConfig struct {
+	// Normalize is the name of a normalize schema (ex: ecs)
+	Normalize string `yaml:"normalize"`
+	// Fields and Provenance configure the enrichment (see Enricher)
+	Fields     map[string]string `yaml:"fields"`
+	Provenance bool              `yaml:"provenance"`
+}
+
+// DeliveryConfig controls how events are delivered to the outputs
+type DeliveryConfig struct {
+	// BatchSize is the number of events sent at once
+	BatchSize int `yaml:"batch"`
+	// Flush is the maximum time events wait before being sent
+	Flush time.Duration `yaml:"flush"`
+	// Retries is the number of retries of a failed batch (-1 retries
+	// forever)
+	Retries *int `yaml:"retries"`
+	// Queue is the directory of the disk queue of the output, routes have
+	// their own (see RouteConfig)
+	Queue string `yaml:"queue"`
+	// QueueMax is the maximum size of the disk queue in MB
+	QueueMax int64 `yaml:"queuemax"`
+	// Overflow policy of the disk queue: block, drop-oldest or drop-newest
+	Overflow string `yaml:"overflow"`
+}
+
+// BatchOptions returns the batch options, fields not set are taken from
+// output.DefaultBatchOptions
+func (d *DeliveryConfig) BatchOptions() output.BatchOptions {
+	opts := output.DefaultBatchOptions
+	if d.BatchSize > 0 {
+		opts.Size = d.BatchSize
+	}
+	if d.Flush > 0 {
+		opts.Interval = d.Flush
+	}
+	if d.Retries != nil {
+		opts.Retry.MaxRetries = *d.Retries
+	}
+	return opts
+}
+
+// Config is the configuration of a pipeline: the inputs, the filters and
+// transforms applied to their events and the outputs the events are sent to,
+// either a single output or routes. String values can reference environment
+// variables as ${NAME}, so that credentials are not stored in the file, they
+// are expanded after parsing and can't be used for numbers or booleans.
+//
+//	inputs:
+//	  files: [/evidence/*.evtx]
+//	filters:
+//	  query: Channel == "Security"
+//	transforms:
+//	  normalize: ecs
+//	  provenance: true
+//	  fields:
+//	    case: IR-2024-042
+//	output:
+//	  type: elasticsearch
+//	  url: https://elastic:9200
+//	  username: evtx
+//	  password: ${ES_PASSWORD}
+type Config struct {
+	Inputs     InputConfig     `yaml:"inputs"`
+	Filters    FilterConfig    `yaml:"filters"`
+	Transforms TransformConfig `yaml:"transforms"`
+	Output     OutputConfig    `yaml:"output"`
+	Routes     Routes          `yaml:"routes"`
+	Delivery   DeliveryConfig  `yaml:"delivery"`
+}
+
+// expandEnv replaces the ${NAME} references by the value of the environment
+// variables in the string values of a YAML document, it fails if some are not
+// set. Values are expanded after parsing so that they can't change the
+// structure of the document.
+func expandEnv(v interface{}, missing map[string]bool) interface{} {
+	switch n := v.(type) {
+	case string:
+		return envVarRE.ReplaceAllStringFunc(n, func(ref string) string {
+			name := envVarRE.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing[name] = true
+			}
+			return value
+		})
+	case map[interface{}]interface{}:
+		for k, c := range n {
+			n[k] = expandEnv(c, missing)
+		}
+	case []interface{}:
+		for i, c := range n {
+			n[i] = expandEnv(c, missing)
+		}
+	}
+	return v
+}
+
+// unmarshal decodes a YAML document into out after having expanded the
+// environment variables, unknown fields are errors
+func unmarshal(data []byte, out interface{}) error {
+	var doc interface{}
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return err
+	}
+	missing := make(map[string]bool)
+	doc = expandEnv(doc, missing)
+	if len(missing) > 0 {
+		return fmt.Errorf("Environment variables not set: %s", strings.Join(sortedKeys(missing), ", "))
+	}
+	if data, err := yaml.Marshal(doc); err != nil {
+		return err
+	} else if err := yaml.UnmarshalStrict(data, out); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadConfig loads and validates a configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("Bad configuration %s: %s", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("Bad configuration %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks the whole configuration, it returns an error listing all
+// the problems found
+func (c *Config) Validate() error {
+	errs := make([]string, 0)
+	fail := func(section string, err error) {
+		errs = append(errs, fmt.Sprintf("%s: %s", section, err))
+	}
+
+	for _, patterns := range [][]string{c.Inputs.Files, c.Inputs.Carve} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				fail("inputs", fmt.Errorf("bad pattern %s: %s", pattern, err))
+			}
+		}
+	}
+	for _, dir := range c.Inputs.Directories {
+		if fi, err := os.Stat(dir); err != nil {
+			fail("inputs", err)
+		} else if !fi.IsDir() {
+			fail("inputs", fmt.Errorf("%s is not a directory", dir))
+		}
+	}
+	if _, err := Filter(c.Filters.Query, c.Filters.XPath); err != nil {
+		fail("filters", err)
+	}
+	if c.Transforms.Normalize != "" {
+		if _, err := normalize.Lookup(c.Transforms.Normalize); err != nil {
+			fail("transforms", err)
+		}
+	}
+	if len(c.Output) > 0 && len(c.Routes) > 0 {
+		fail("output", fmt.Errorf("output and routes are exclusive"))
+	}
+	if len(c.Output) > 0 {
+		if _, err := c.Output.New(); err != nil {
+			fail("output", err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, r := range c.Routes {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		} else if names[name] {
+			fail("routes", fmt.Errorf("duplicate route name %s", name))
+		}
+		names[name] = true
+		if _, err := Filter(r.Query, r.XPath); err != nil {
+			fail("routes", fmt.Errorf("route %s: %s", name, err))
+		}
+		if r.Overflow != "" {
+			if _, err := output.ParseOverflowPolicy(r.Overflow); err != nil {
+				fail("routes", fmt.Errorf("route %s: %s", name, err))
+			}
+		}
+		if _, err := r.Output.New(); err != nil {
+			fail("routes", fmt.Errorf("route %s: %s", name, err))
+		}
+	}
+	if c.Delivery.Overflow != "" {
+		if _, err := output.ParseOverflowPolicy(c.Delivery.Overflow); err != nil {
+			fail("delivery", err)
+		}
+	}
+	if len(c.Routes) > 0 && (c.Delivery.Queue != "" || c.Delivery.QueueMax > 0 || c.Delivery.Overflow != "") {
+		fail("delivery", fmt.Errorf("queue, queuemax and overflow are set on the routes"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// EvtxFiles returns the files matching the patterns and the .evtx files found
+// in the directories, sorted and without duplicates
+func (i *InputConfig) EvtxFiles() ([]string, error) {
+	set := make(map[string]bool)
+	for _, pattern := range i.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			set[m] = true
+		}
+	}
+	for _, dir := range i.Directories {
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() && strings.EqualFold(filepath.Ext(path), ".evtx") {
+				set[path] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sortedKeys(set), nil
+}
+
+// CarveFiles returns the files to carve
+func (i *InputConfig) CarveFiles() ([]string, error) {
+	set := make(map[string]bool)
+	for _, pattern := range i.Carve {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			set[m] = true
+		}
+	}
+	return sortedKeys(set), nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
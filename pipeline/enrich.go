@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+const (
+	// MetaNamespace is the top level key, next to Event, reserved to the
+	// metadata added to the events. It is never merged into Event so that
+	// metadata cannot collide with event fields.
+	MetaNamespace = "Meta"
+)
+
+// Provenance is where an event comes from, unknown fields are left empty
+// (negative for the numbers)
+type Provenance struct {
+	// File is the path of the file the event comes from
+	File string
+	// Chunk is the index of the chunk in the file
+	Chunk int64
+	// ChunkOffset and EventOffset are absolute offsets in the file
+	ChunkOffset int64
+	EventOffset int64
+}
+
+// NoProvenance is the provenance of events which origin is unknown
+var NoProvenance = Provenance{Chunk: -1, ChunkOffset: -1, EventOffset: -1}
+
+// RecordProvenance returns the provenance of a record read from file
+func RecordProvenance(file string, r *evtx.Record) Provenance {
+	return Provenance{
+		File:        file,
+		Chunk:       r.Chunk,
+		ChunkOffset: r.ChunkOffset,
+		EventOffset: r.Offset,
+	}
+}
+
+// Enricher adds static fields and provenance metadata to the events, under
+// MetaNamespace:
+//
+//	Meta:
+//	  File: Security.evtx
+//	  FileSHA256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	  Chunk: 3
+//	  ChunkOffset: 200704
+//	  EventOffset: 201216
+//	  Collector: collector01
+//	  Fields:
+//	    site: paris
+//
+// The file is always added when known as some outputs rely on it (ex: Splunk
+// source), the other provenance fields only if Provenance is set.
+type Enricher struct {
+	sync.Mutex
+	hashes map[string]string
+	// Fields are static fields added to all the events
+	Fields map[string]string
+	// Provenance enables the provenance metadata
+	Provenance bool
+	// Hostname of the collector, default is the hostname of the system
+	Hostname string
+}
+
+// NewEnricher creates a new Enricher
+func NewEnricher(fields map[string]string, provenance bool) *Enricher {
+	en := &Enricher{Fields: fields, Provenance: provenance}
+	en.Hostname, _ = os.Hostname()
+	return en
+}
+
+// fileSHA256 returns the SHA-256 of a file, computed once per file
+func (en *Enricher) fileSHA256(path string) string {
+	en.Lock()
+	defer en.Unlock()
+	if en.hashes == nil {
+		en.hashes = make(map[string]string)
+	}
+	if sum, ok := en.hashes[path]; ok {
+		return sum
+	}
+	sum := ""
+	if fd, err := os.Open(path); err == nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, fd); err == nil {
+			sum = hex.EncodeToString(h.Sum(nil))
+		}
+		fd.Close()
+	}
+	en.hashes[path] = sum
+	return sum
+}
+
+// Enrich returns a shallow copy of the event with the metadata, the event
+// itself is not modified. Metadata already present in the event (ex: event
+// read back from a disk queue) is kept unless overwritten.
+func (en *Enricher) Enrich(e *evtx.GoEvtxMap, p Provenance) *evtx.GoEvtxMap {
+	meta := make(evtx.GoEvtxMap)
+	switch old := (*e)[MetaNamespace].(type) {
+	case evtx.GoEvtxMap:
+		for k, v := range old {
+			meta[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range old {
+			meta[k] = v
+		}
+	}
+	if p.File != "" {
+		meta["File"] = p.File
+	}
+	if en.Provenance {
+		if p.File != "" {
+			if sum := en.fileSHA256(p.File); sum != "" {
+				meta["FileSHA256"] = sum
+			}
+		}
+		if p.Chunk >= 0 {
+			meta["Chunk"] = p.Chunk
+		}
+		if p.ChunkOffset >= 0 {
+			meta["ChunkOffset"] = p.ChunkOffset
+		}
+		if p.EventOffset >= 0 {
+			meta["EventOffset"] = p.EventOffset
+		}
+		if en.Hostname != "" {
+			meta["Collector"] = en.Hostname
+		}
+	}
+	if len(en.Fields) > 0 {
+		fields := make(evtx.GoEvtxMap, len(en.Fields))
+		for k, v := range en.Fields {
+			fields[k] = v
+		}
+		meta["Fields"] = fields
+	}
+
+	m := make(evtx.GoEvtxMap, len(*e)+1)
+	for k, v := range *e {
+		m[k] = v
+	}
+	if len(meta) > 0 {
+		m[MetaNamespace] = meta
+	}
+	return &m
+}
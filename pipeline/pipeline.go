@@ -0,0 +1,146 @@
+/*
+Package pipeline builds the event pipelines of the tools out of YAML
+configurations: the filters selecting the events and the outputs, organized
+in routes, they are sent to.
+
+An output is configured with its type (see Outputs) and the fields of the
+corresponding structure of the output package, given by their lower cased
+names. For instance the Kafka output is configured with:
+
+	type: kafka
+	brokerurls: kafka1:9092,kafka2:9092
+	topic: winlogs
+	key: "{Computer}/{Channel}"
+	tls:
+	  cafile: ca.pem
+*/
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-evtx/output"
+	"github.com/0xrawsec/golang-evtx/query"
+	"github.com/0xrawsec/golang-evtx/xpath"
+)
+
+var (
+	// Outputs maps the output types to a function creating an unconfigured
+	// output
+	Outputs = map[string]func() output.Output{
+		"http":          func() output.Output { return &output.HttpJSON{} },
+		"tcp":           func() output.Output { return &output.TcpJSON{} },
+		"kafka":         func() output.Output { return &output.Kafka{} },
+		"syslog":        func() output.Output { return &output.Syslog{} },
+		"elasticsearch": func() output.Output { return &output.Elasticsearch{} },
+		"splunk":        func() output.Output { return &output.SplunkHEC{} },
+		"gelf":          func() output.Output { return &output.GELF{} },
+		"fluent":        func() output.Output { return &output.Fluent{} },
+		"loki":          func() output.Output { return &output.Loki{} },
+		"file":          func() output.Output { return &output.File{} },
+		"csv":           func() output.Output { return &output.CSV{} },
+		"parquet":       func() output.Output { return &output.Parquet{} },
+		"sqlite":        func() output.Output { return &output.SQLite{} },
+	}
+)
+
+// OutputTypes returns the sorted list of the output types
+func OutputTypes() []string {
+	types := make([]string, 0, len(Outputs))
+	for t := range Outputs {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// OutputConfig is the configuration of an output: its type and the fields of
+// the output structure
+type OutputConfig map[string]interface{}
+
+// Type returns the type of the output
+func (c OutputConfig) Type() string {
+	t, _ := c["type"].(string)
+	return t
+}
+
+// New creates the output, unknown fields are errors
+func (c OutputConfig) New() (output.Output, error) {
+	newOutput, ok := Outputs[c.Type()]
+	if !ok {
+		return nil, fmt.Errorf("Unknown output type %q (expecting one of %s)", c.Type(), strings.Join(OutputTypes(), ", "))
+	}
+	fields := make(map[string]interface{}, len(c))
+	for k, v := range c {
+		if k != "type" {
+			fields[k] = v
+		}
+	}
+	data, err := yaml.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	out := newOutput()
+	if err := yaml.UnmarshalStrict(data, out); err != nil {
+		return nil, fmt.Errorf("Bad %s output configuration: %s", c.Type(), err)
+	}
+	return out, nil
+}
+
+// TypedValues returns true if the output expects the events to be decoded with
+// typed values (see output.TypedOutput)
+func (c OutputConfig) TypedValues() bool {
+	newOutput, ok := Outputs[c.Type()]
+	return ok && output.TypedValues(newOutput())
+}
+
+// all matches the events matched by all its filters
+type all []output.Matcher
+
+func (a all) Match(e *evtx.GoEvtxMap) bool {
+	for _, m := range a {
+		if !m.Match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter compiles a filter out of a query (see query package) and a Windows
+// XPath expression or QueryList, an event must match both. It returns nil if
+// both are empty.
+func Filter(q, x string) (output.Matcher, error) {
+	filters := make(all, 0, 2)
+	if q != "" {
+		compiled, err := query.Compile(q)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, compiled)
+	}
+	if x != "" {
+		var compiled output.Matcher
+		var err error
+		if strings.HasPrefix(strings.TrimSpace(x), "<") {
+			compiled, err = xpath.ParseQueryListString(x)
+		} else {
+			compiled, err = xpath.Compile(x)
+		}
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, compiled)
+	}
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	}
+	return filters, nil
+}
@@ -0,0 +1,275 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-evtx/output"
+)
+
+func event(channel, eid string) *evtx.GoEvtxMap {
+	return &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{
+			"System": evtx.GoEvtxMap{
+				"Channel":     channel,
+				"Computer":    "DC01",
+				"EventID":     eid,
+				"TimeCreated": evtx.GoEvtxMap{"SystemTime": "2020-01-02T03:04:05Z"},
+			},
+			"EventData": evtx.GoEvtxMap{
+				"IpAddress":   "10.0.0.1",
+				"CommandLine": "cmd.exe",
+			},
+		},
+	}
+}
+
+func readLines(t *testing.T, path string) (events []map[string]interface{}) {
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	s := bufio.NewScanner(fd)
+	for s.Scan() {
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, m)
+	}
+	return
+}
+
+func TestRouter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := `routes:
+  - name: siem
+    query: Channel == "Security" and EventID in (4624, 4625)
+    drop: [EventData/CommandLine]
+    rename:
+      EventData/IpAddress: EventData/SourceIp
+    output:
+      type: file
+      path: ` + filepath.Join(dir, "siem.jsonl") + `
+  - name: archive
+    output:
+      type: file
+      path: ` + filepath.Join(dir, "archive.jsonl") + `
+`
+	path := filepath.Join(dir, "routes.yaml")
+	ioutil.WriteFile(path, []byte(conf), 0600)
+	routes, err := LoadRoutes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := routes.Router(output.DefaultBatchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Open(); err != nil {
+		t.Fatal(err)
+	}
+	events := []*evtx.GoEvtxMap{event("Security", "4624"), event("Security", "4688"), event("System", "7045")}
+	if err := router.Write(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	siem := readLines(t, filepath.Join(dir, "siem.jsonl"))
+	if len(siem) != 1 {
+		t.Fatalf("Expected 1 event routed to siem, got %d", len(siem))
+	}
+	data := siem[0]["Event"].(map[string]interface{})["EventData"].(map[string]interface{})
+	if _, ok := data["CommandLine"]; ok || data["SourceIp"] != "10.0.0.1" || data["IpAddress"] != nil {
+		t.Errorf("Route transforms not applied: %v", data)
+	}
+	archive := readLines(t, filepath.Join(dir, "archive.jsonl"))
+	if len(archive) != 3 {
+		t.Errorf("Expected 3 events routed to archive, got %d", len(archive))
+	}
+	// transforms must not modify the original events
+	if _, err := events[0].Get(&evtx.GoEvtxPath{"Event", "EventData", "CommandLine"}); err != nil {
+		t.Error("Original event modified by route transforms")
+	}
+
+	stats := router.Stats()
+	if stats[0].Matched != 1 || stats[0].Delivered != 1 || stats[1].Delivered != 3 {
+		t.Errorf("Unexpected route stats: %+v", stats)
+	}
+}
+
+func TestOutputConfig(t *testing.T) {
+	for _, c := range []OutputConfig{
+		{"type": "nope"},
+		{"type": "kafka", "brokers": "kafka:9092"},
+	} {
+		if _, err := c.New(); err == nil {
+			t.Errorf("Bad output configuration accepted: %v", c)
+		}
+	}
+	out, err := OutputConfig{"type": "tcp", "address": "logstash:5000", "framing": "octet", "writetimeout": "5s"}.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tj := out.(*output.TcpJSON); tj.Framing != "octet" || tj.WriteTimeout.String() != "5s" {
+		t.Errorf("Unexpected configuration: %+v", tj)
+	}
+	routes := Routes{{Output: OutputConfig{"type": "tcp"}}}
+	if routes.TypedValues() {
+		t.Error("TCP output should not expect typed values")
+	}
+	if routes = append(routes, RouteConfig{Output: OutputConfig{"type": "parquet"}}); !routes.TypedValues() {
+		t.Error("Parquet output should expect typed values")
+	}
+	if _, err := Filter(`EventID ==`, ""); err == nil {
+		t.Error("Bad query accepted")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "logs", "dc01"), 0755)
+	for _, f := range []string{"Security.evtx", "dc01/System.EVTX", "dc01/notes.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, "logs", f), nil, 0600)
+	}
+
+	path := filepath.Join(dir, "pipeline.yaml")
+	conf := `inputs:
+  files: [` + filepath.Join(dir, "logs", "*.evtx") + `]
+  directories: [` + filepath.Join(dir, "logs") + `]
+filters:
+  query: EventID in (4624, 4625)
+transforms:
+  normalize: ecs
+output:
+  type: elasticsearch
+  url: https://elastic:9200
+  username: evtx
+  password: ${EVTX_TEST_PASSWORD}
+delivery:
+  batch: 500
+  flush: 5s
+  retries: -1
+`
+	ioutil.WriteFile(path, []byte(conf), 0600)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "EVTX_TEST_PASSWORD") {
+		t.Errorf("Missing environment variable not reported: %v", err)
+	}
+	// values are not YAML, they can't change the configuration
+	secret := "s3cr#t\n  url: http://evil:9200"
+	os.Setenv("EVTX_TEST_PASSWORD", secret)
+	defer os.Unsetenv("EVTX_TEST_PASSWORD")
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := c.Inputs.EvtxFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Unexpected input files: %v", files)
+	}
+	out, _ := c.Output.New()
+	if es := out.(*output.Elasticsearch); es.Password != secret || es.URL != "https://elastic:9200" {
+		t.Errorf("Password not taken from environment: %q", es.Password)
+	}
+	if opts := c.Delivery.BatchOptions(); opts.Size != 500 || opts.Interval != 5*time.Second || opts.Retry.MaxRetries != -1 {
+		t.Errorf("Unexpected batch options: %+v", opts)
+	}
+
+	// all the errors are reported at once
+	bad := `inputs:
+  directories: [` + filepath.Join(dir, "nope") + `]
+filters:
+  query: EventID ==
+transforms:
+  normalize: nope
+output:
+  type: kafka
+  brokers: kafka:9092
+`
+	ioutil.WriteFile(path, []byte(bad), 0600)
+	_, err = LoadConfig(path)
+	if err == nil {
+		t.Fatal("Bad configuration accepted")
+	}
+	for _, section := range []string{"inputs:", "filters:", "transforms:", "output:"} {
+		if !strings.Contains(err.Error(), section) {
+			t.Errorf("Error of section %s not reported: %s", section, err)
+		}
+	}
+
+	// settings which would be ignored are errors
+	ignored := `routes:
+  - output:
+      type: file
+      path: archive.jsonl
+delivery:
+  queue: /var/spool/evtx
+`
+	ioutil.WriteFile(path, []byte(ignored), 0600)
+	if _, err = LoadConfig(path); err == nil || !strings.Contains(err.Error(), "delivery:") {
+		t.Errorf("Disk queue of routes not reported: %v", err)
+	}
+}
+
+func TestEnricher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Security.evtx")
+	ioutil.WriteFile(file, []byte("test"), 0600)
+
+	en := NewEnricher(map[string]string{"case": "IR-42"}, true)
+	en.Hostname = "collector01"
+	e := event("Security", "4624")
+	p := Provenance{File: file, Chunk: 1, ChunkOffset: 69632, EventOffset: 70144}
+	enriched := en.Enrich(e, p)
+	if _, ok := (*e)[MetaNamespace]; ok {
+		t.Error("Original event modified by enrichment")
+	}
+	meta := (*enriched)[MetaNamespace].(evtx.GoEvtxMap)
+	// SHA-256 of "test"
+	if meta["FileSHA256"] != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Errorf("Bad file hash: %v", meta["FileSHA256"])
+	}
+	if meta["File"] != file || meta["Chunk"] != int64(1) || meta["EventOffset"] != int64(70144) || meta["Collector"] != "collector01" {
+		t.Errorf("Bad provenance: %v", meta)
+	}
+	if meta["Fields"].(evtx.GoEvtxMap)["case"] != "IR-42" {
+		t.Errorf("Static fields not added: %v", meta)
+	}
+	if _, err := enriched.Get(&evtx.GoEvtxPath{"Event", "System", "EventID"}); err != nil {
+		t.Error("Event fields lost by enrichment")
+	}
+
+	// unknown provenance and provenance disabled
+	en.Provenance = false
+	meta = (*en.Enrich(e, NoProvenance))[MetaNamespace].(evtx.GoEvtxMap)
+	if len(meta) != 1 || meta["Fields"] == nil {
+		t.Errorf("Unexpected metadata: %v", meta)
+	}
+}
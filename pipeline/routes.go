@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/0xrawsec/golang-evtx/output"
+)
+
+// RouteConfig is the configuration of a route
+type RouteConfig struct {
+	Name string `yaml:"name"`
+	// Query and XPath select the events of the route, all the events go
+	// through the route if both are empty
+	Query string `yaml:"query"`
+	XPath string `yaml:"xpath"`
+	// Drop and Rename transforms (see output.Route)
+	Drop   []string          `yaml:"drop"`
+	Rename map[string]string `yaml:"rename"`
+	// Queue is the directory of the disk queue of the route, events are
+	// only batched in memory if empty
+	Queue string `yaml:"queue"`
+	// QueueMax and Overflow are the maximum size in MB and the overflow
+	// policy of the disk queue (see DeliveryConfig)
+	QueueMax int64        `yaml:"queuemax"`
+	Overflow string       `yaml:"overflow"`
+	Output   OutputConfig `yaml:"output"`
+}
+
+// Route builds the route, its output is wrapped into a Batcher configured
+// with opts or into a DiskQueue if Queue is set
+func (c *RouteConfig) Route(opts output.BatchOptions) (*output.Route, error) {
+	filter, err := Filter(c.Query, c.XPath)
+	if err != nil {
+		return nil, fmt.Errorf("Bad filter: %s", err)
+	}
+	out, err := c.Output.New()
+	if err != nil {
+		return nil, err
+	}
+	if c.Queue != "" {
+		qopts := output.DefaultQueueOptions
+		qopts.Dir = c.Queue
+		qopts.BatchSize = opts.Size
+		qopts.MaxSize = c.QueueMax << 20
+		if c.Overflow != "" {
+			if qopts.Overflow, err = output.ParseOverflowPolicy(c.Overflow); err != nil {
+				return nil, err
+			}
+		}
+		out = output.NewDiskQueue(out, qopts)
+	} else {
+		out = output.NewBatcher(out, opts)
+	}
+	return &output.Route{
+		Name:   c.Name,
+		Filter: filter,
+		Drop:   c.Drop,
+		Rename: c.Rename,
+		Output: out,
+	}, nil
+}
+
+// Routes is a list of route configurations
+type Routes []RouteConfig
+
+// Router builds a router out of the routes
+func (rs Routes) Router(opts output.BatchOptions) (*output.Router, error) {
+	if len(rs) == 0 {
+		return nil, fmt.Errorf("No route defined")
+	}
+	router := output.NewRouter()
+	for i := range rs {
+		route, err := rs[i].Route(opts)
+		if err != nil {
+			name := rs[i].Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+			return nil, fmt.Errorf("Route %s: %s", name, err)
+		}
+		router.Routes = append(router.Routes, route)
+	}
+	return router, nil
+}
+
+// TypedValues returns true if an output of the routes expects the events to be
+// decoded with typed values, the other outputs convert them into strings
+func (rs Routes) TypedValues() bool {
+	for _, r := range rs {
+		if r.Output.TypedValues() {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadRoutes loads the routes of a YAML file, environment variables are
+// referenced as ${NAME} (see Config). The file is of the form:
+//
+//	routes:
+//	  - name: siem
+//	    query: Channel == "Security" and EventID in (4624, 4625)
+//	    output:
+//	      type: splunk
+//	      url: https://splunk:8088
+//	      token: ${SPLUNK_HEC_TOKEN}
+//	  - name: archive
+//	    output:
+//	      type: file
+//	      path: archive/{Computer}/{Channel}/{yyyy-MM-dd}.jsonl.gz
+func LoadRoutes(path string) (Routes, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := struct {
+		Routes Routes `yaml:"routes"`
+	}{}
+	if err := unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("Bad routes file %s: %s", path, err)
+	}
+	return conf.Routes, nil
+}
@@ -0,0 +1,144 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+////////////////////////////////// Tokens //////////////////////////////////////
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOperator
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case tokEOF:
+		return "end of query"
+	case tokIdent:
+		return "identifier"
+	case tokString:
+		return "string"
+	case tokNumber:
+		return "number"
+	case tokOperator:
+		return "operator"
+	case tokLParen:
+		return "("
+	case tokRParen:
+		return ")"
+	case tokComma:
+		return ","
+	}
+	return "unknown"
+}
+
+type token struct {
+	typ   tokenType
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.typ == tokEOF {
+		return t.typ.String()
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+// is returns true if the token is the keyword kw (case insensitive)
+func (t token) is(kw string) bool {
+	return t.typ == tokIdent && strings.EqualFold(t.value, kw)
+}
+
+/////////////////////////////////// Lexer //////////////////////////////////////
+
+// ErrSyntax is returned when a query cannot be parsed
+type ErrSyntax struct {
+	Pos int
+	Msg string
+}
+
+func (e *ErrSyntax) Error() string {
+	return fmt.Sprintf("Syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '/' || r == '-' || r == '@'
+}
+
+// lex splits a query into tokens
+func lex(q string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			quote := r
+			sb := strings.Builder{}
+			for i++; i < len(runes) && runes[i] != quote; i++ {
+				// only quotes and backslashes are escaped so that regexp
+				// escapes can be written as is
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == quote || runes[i+1] == '\\') {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, &ErrSyntax{start, "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, sb.String(), start})
+			i++
+		case strings.ContainsRune("=!<>~", r):
+			start := i
+			for i < len(runes) && strings.ContainsRune("=!<>~", runes[i]) {
+				i++
+			}
+			op := string(runes[start:i])
+			switch op {
+			case "=", "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+			default:
+				return nil, &ErrSyntax{start, fmt.Sprintf("unknown operator %q", op)}
+			}
+			tokens = append(tokens, token{tokOperator, op, start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i++; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start})
+		case isIdentRune(r):
+			start := i
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[start:i]), start})
+		default:
+			return nil, &ErrSyntax{i, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(runes)})
+	return tokens, nil
+}
@@ -0,0 +1,349 @@
+/*
+Package query implements a small query language used to filter events.
+
+A query is made of comparisons combined with and, or, not and parenthesis:
+
+	EventID in (4624,4625) and EventData.LogonType == 10 and EventData.IpAddress !~ "^10\."
+
+Supported comparisons are:
+
+	field == value, field != value         equality (= is an alias of ==)
+	field < value, <=, >, >=               ordering
+	field =~ "regexp", field !~ "regexp"   regular expressions
+	field like "wild*card?"                case insensitive wildcards
+	field in (v1, v2 ...)                  membership
+	field between v1 and v2                inclusive ranges
+	field exists                           existence check
+
+Comparisons are typed: times are compared as times, numbers as numbers and
+anything else as strings. Negated operators (!=, !~, not like, not in) are the
+negation of their positive counterpart, so they match events where the field
+is missing.
+
+Fields are either aliases (EventID, Channel, Computer, Provider, Level, Time
+...), dotted names relative to the Event node (EventData.TargetUserName) or
+absolute paths (/Event/System/EventID).
+*/
+package query
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// Predicate is a function telling if an event matches
+type Predicate func(e *evtx.GoEvtxMap) bool
+
+// Query structure definition
+type Query struct {
+	src  string
+	pred Predicate
+}
+
+// Compile parses a query and compiles it into a Query
+// @q : query string
+// return (*Query, error)
+func Compile(q string) (*Query, error) {
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens}
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return &Query{src: q, pred: pred}, nil
+}
+
+// MustCompile is like Compile but panics if the query cannot be compiled
+func MustCompile(q string) *Query {
+	c, err := Compile(q)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Match returns true if the event matches the query
+func (q *Query) Match(e *evtx.GoEvtxMap) bool {
+	if e == nil {
+		return false
+	}
+	return q.pred(e)
+}
+
+// Predicate returns the compiled predicate of the query
+func (q *Query) Predicate() Predicate {
+	return q.pred
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+/////////////////////////////////// Parser /////////////////////////////////////
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &ErrSyntax{t.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(typ tokenType) (token, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, p.errorf(t, "expecting %s got %s", typ, t)
+	}
+	return t, nil
+}
+
+// parseOr : and ("or" and)*
+func (p *parser) parseOr() (Predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *evtx.GoEvtxMap) bool { return l(e) || right(e) }
+	}
+	return left, nil
+}
+
+// parseAnd : not ("and" not)*
+func (p *parser) parseAnd() (Predicate, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *evtx.GoEvtxMap) bool { return l(e) && right(e) }
+	}
+	return left, nil
+}
+
+// parseNot : "not" not | primary
+func (p *parser) parseNot() (Predicate, error) {
+	if p.peek().is("not") {
+		p.next()
+		pred, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(e *evtx.GoEvtxMap) bool { return !pred(e) }, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary : "(" or ")" | comparison
+func (p *parser) parsePrimary() (Predicate, error) {
+	if p.peek().typ == tokLParen {
+		p.next()
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return pred, nil
+	}
+	return p.parseComparison()
+}
+
+// parseValue parses a literal value
+func (p *parser) parseValue() (literal, error) {
+	t := p.next()
+	switch t.typ {
+	case tokString, tokNumber, tokIdent:
+		return newLiteral(t.value), nil
+	}
+	return literal{}, p.errorf(t, "expecting value got %s", t)
+}
+
+// parseList : "(" value ("," value)* ")"
+func (p *parser) parseList() ([]literal, error) {
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	list := make([]literal, 0)
+	for {
+		l, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+		t := p.next()
+		if t.typ == tokRParen {
+			return list, nil
+		}
+		if t.typ != tokComma {
+			return nil, p.errorf(t, "expecting , or ) got %s", t)
+		}
+	}
+}
+
+func negate(pred Predicate, neg bool) Predicate {
+	if neg {
+		return func(e *evtx.GoEvtxMap) bool { return !pred(e) }
+	}
+	return pred
+}
+
+// parseComparison : field operator value | field [not] in list |
+// field [not] like value | field between value and value | field exists
+func (p *parser) parseComparison() (Predicate, error) {
+	t, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	f := newField(t.value)
+
+	neg := false
+	op := p.next()
+	if op.is("not") {
+		neg = true
+		op = p.next()
+		if !op.is("in") && !op.is("like") && !op.is("between") && !op.is("exists") {
+			return nil, p.errorf(op, "expecting in, like, between or exists after not got %s", op)
+		}
+	}
+
+	switch {
+	case op.is("exists"):
+		return negate(func(e *evtx.GoEvtxMap) bool {
+			_, ok := f.get(e)
+			return ok
+		}, neg), nil
+
+	case op.is("in"):
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return negate(matchAny(f, func(v interface{}) bool {
+			for _, l := range list {
+				if c, ok := compare(v, l); ok && c == 0 {
+					return true
+				}
+			}
+			return false
+		}), neg), nil
+
+	case op.is("like"):
+		l, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		re := wildcard(l.raw)
+		return negate(matchAny(f, func(v interface{}) bool {
+			return re.MatchString(toString(v))
+		}), neg), nil
+
+	case op.is("between"):
+		low, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); !t.is("and") {
+			return nil, p.errorf(t, "expecting and got %s", t)
+		}
+		high, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return negate(matchAny(f, func(v interface{}) bool {
+			c1, ok1 := compare(v, low)
+			c2, ok2 := compare(v, high)
+			return ok1 && ok2 && c1 >= 0 && c2 <= 0
+		}), neg), nil
+
+	case op.typ == tokOperator:
+		l, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		switch op.value {
+		case "=~", "!~":
+			re, err := regexp.Compile(l.raw)
+			if err != nil {
+				return nil, p.errorf(op, "bad regexp: %s", err)
+			}
+			return negate(matchAny(f, func(v interface{}) bool {
+				return re.MatchString(toString(v))
+			}), op.value == "!~"), nil
+		case "=", "==", "!=":
+			return negate(matchAny(f, func(v interface{}) bool {
+				c, ok := compare(v, l)
+				return ok && c == 0
+			}), op.value == "!="), nil
+		default:
+			cmp := op.value
+			return matchAny(f, func(v interface{}) bool {
+				c, ok := compare(v, l)
+				if !ok {
+					return false
+				}
+				switch cmp {
+				case "<":
+					return c < 0
+				case "<=":
+					return c <= 0
+				case ">":
+					return c > 0
+				}
+				return c >= 0
+			}), nil
+		}
+	}
+	return nil, p.errorf(op, "expecting operator got %s", op)
+}
+
+// matchAny returns a predicate true if any of the values of the field
+// satisfies test
+func matchAny(f field, test func(v interface{}) bool) Predicate {
+	return func(e *evtx.GoEvtxMap) bool {
+		v, ok := f.get(e)
+		if !ok {
+			return false
+		}
+		for _, sv := range values(v) {
+			if test(sv) {
+				return true
+			}
+		}
+		return false
+	}
+}
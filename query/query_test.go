@@ -0,0 +1,69 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+func logonEvent() *evtx.GoEvtxMap {
+	return &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{
+			"System": evtx.GoEvtxMap{
+				"Channel":       "Security",
+				"Computer":      "DC01",
+				"EventID":       "4624",
+				"EventRecordID": "1234",
+				"Provider":      evtx.GoEvtxMap{"Name": "Microsoft-Windows-Security-Auditing"},
+				"TimeCreated": evtx.GoEvtxMap{
+					"SystemTime": evtx.UTCTime(time.Date(2019, 5, 12, 10, 0, 0, 0, time.UTC)),
+				},
+			},
+			"EventData": evtx.GoEvtxMap{
+				"LogonType":      "10",
+				"IpAddress":      "192.168.1.10",
+				"TargetUserName": "Administrator",
+			},
+		},
+	}
+}
+
+func TestQuery(t *testing.T) {
+	e := logonEvent()
+	for q, expect := range map[string]bool{
+		`EventID in (4624,4625) and EventData.LogonType == 10 and EventData.IpAddress !~ "^10\."`: true,
+		`EventID == 4625`:                                    false,
+		`EventID > 4000 and EventID <= 4624`:                 true,
+		`Channel = Security and not Computer == "DC02"`:      true,
+		`EventData.TargetUserName like "admin*"`:             true,
+		`EventData.TargetUserName not like "admin*"`:         false,
+		`EventData.Missing exists`:                           false,
+		`EventData.Missing not exists`:                       true,
+		`EventData.Missing != "foo"`:                         true,
+		`Provider =~ "Security-Auditing$"`:                   true,
+		`Time between "2019-05-12" and "2019-05-13"`:         true,
+		`Time > "2019-05-12T10:00:01Z"`:                      false,
+		`/Event/System/EventRecordID >= 0x4d2`:               true,
+		`(EventID == 1 or EventID == 4624) and Level exists`: false,
+	} {
+		if m := MustCompile(q).Match(e); m != expect {
+			t.Errorf("%s: expected %t got %t", q, expect, m)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, q := range []string{
+		`EventID ==`,
+		`EventID in (4624`,
+		`(EventID == 1`,
+		`EventID === 1`,
+		`EventData.IpAddress =~ "("`,
+		`EventID == "1`,
+	} {
+		if _, err := Compile(q); err == nil {
+			t.Errorf("%s: expected syntax error", q)
+		}
+	}
+}
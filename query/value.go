@@ -0,0 +1,226 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+/////////////////////////////////// Fields /////////////////////////////////////
+
+var (
+	// aliases of commonly used fields
+	aliases = map[string]evtx.GoEvtxPath{
+		"eventid":       evtx.EventIDPath,
+		"channel":       evtx.ChannelPath,
+		"eventrecordid": evtx.EventRecordIDPath,
+		"recordid":      evtx.EventRecordIDPath,
+		"time":          evtx.SystemTimePath,
+		"timecreated":   evtx.SystemTimePath,
+		"userid":        evtx.UserIDPath,
+		"computer":      evtx.Path("/Event/System/Computer"),
+		"provider":      evtx.Path("/Event/System/Provider/Name"),
+		"level":         evtx.Path("/Event/System/Level"),
+		"task":          evtx.Path("/Event/System/Task"),
+		"opcode":        evtx.Path("/Event/System/Opcode"),
+		"keywords":      evtx.Path("/Event/System/Keywords"),
+	}
+
+	// layouts used to parse time literals
+	timeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+)
+
+// FieldPath converts a field name as written in a query into a GoEvtxPath.
+// Fields can be aliases (EventID, Channel, Computer ...), dotted names relative
+// to the Event node (EventData.TargetUserName) or absolute paths
+// (/Event/System/EventID)
+func FieldPath(name string) evtx.GoEvtxPath {
+	if p, ok := aliases[strings.ToLower(name)]; ok {
+		return p
+	}
+	p := evtx.GoEvtxPath(strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '/' }))
+	if len(p) == 0 || p[0] != "Event" {
+		p = append(evtx.GoEvtxPath{"Event"}, p...)
+	}
+	return p
+}
+
+type field struct {
+	name string
+	path evtx.GoEvtxPath
+}
+
+func newField(name string) field {
+	return field{name, FieldPath(name)}
+}
+
+// get returns the value of the field in the event
+func (f field) get(e *evtx.GoEvtxMap) (interface{}, bool) {
+	v, err := e.Get(&f.path)
+	if err != nil {
+		return nil, false
+	}
+	return unwrap(*v), true
+}
+
+// unwrap returns the Value of nodes having attributes (like EventID having
+// a Qualifiers attribute)
+func unwrap(v interface{}) interface{} {
+	switch m := v.(type) {
+	case evtx.GoEvtxMap:
+		if val, ok := m["Value"]; ok {
+			return val
+		}
+	case map[string]interface{}:
+		if val, ok := m["Value"]; ok {
+			return val
+		}
+	}
+	return v
+}
+
+////////////////////////////////// Literals ////////////////////////////////////
+
+type literal struct {
+	raw    string
+	isNum  bool
+	num    float64
+	isTime bool
+	time   time.Time
+}
+
+func newLiteral(raw string) literal {
+	l := literal{raw: raw}
+	if f, ok := toNumber(raw); ok {
+		l.isNum, l.num = true, f
+	}
+	if t, ok := toTime(raw); ok {
+		l.isTime, l.time = true, t
+	}
+	return l
+}
+
+func toNumber(s string) (float64, bool) {
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return float64(i), true
+	}
+	if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return float64(u), true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true
+	}
+	return 0, false
+}
+
+func toTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case nil:
+		return ""
+	case time.Time:
+		return s.UTC().Format(time.RFC3339Nano)
+	case evtx.UTCTime:
+		return time.Time(s).UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// values returns the scalar values of v, a field may contain a list of values
+func values(v interface{}) []interface{} {
+	switch l := v.(type) {
+	case []string:
+		out := make([]interface{}, len(l))
+		for i := range l {
+			out[i] = l[i]
+		}
+		return out
+	case []interface{}:
+		return l
+	}
+	return []interface{}{v}
+}
+
+// compare compares a value found in an event with a literal, the comparison
+// is done on times, numbers or strings depending on what both sides can be
+// converted to. It returns false if the values are not comparable.
+func compare(v interface{}, l literal) (int, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		if l.isTime {
+			return compareTime(t, l.time), true
+		}
+		return 0, false
+	case evtx.UTCTime:
+		if l.isTime {
+			return compareTime(time.Time(t), l.time), true
+		}
+		return 0, false
+	}
+	s := toString(v)
+	if l.isNum {
+		if f, ok := toNumber(s); ok {
+			switch {
+			case f < l.num:
+				return -1, true
+			case f > l.num:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if l.isTime {
+		if t, ok := toTime(s); ok {
+			return compareTime(t, l.time), true
+		}
+	}
+	return strings.Compare(s, l.raw), true
+}
+
+func compareTime(t1, t2 time.Time) int {
+	switch {
+	case t1.Before(t2):
+		return -1
+	case t1.After(t2):
+		return 1
+	}
+	return 0
+}
+
+// wildcard compiles a wildcard pattern (using * and ?) into a case
+// insensitive regexp
+func wildcard(pattern string) *regexp.Regexp {
+	sb := strings.Builder{}
+	sb.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
@@ -0,0 +1,209 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// condition is a compiled Sigma condition, it evaluates the detections by
+// names through the test callback so that results can be cached
+type condition func(test func(name string) bool) bool
+
+// ErrCondition is returned when a condition cannot be parsed
+type ErrCondition struct {
+	Condition string
+	Msg       string
+}
+
+func (e *ErrCondition) Error() string {
+	return fmt.Sprintf("Bad condition %q: %s", e.Condition, e.Msg)
+}
+
+// tokenize splits a condition into words and parenthesis
+func tokenize(cond string) []string {
+	tokens := make([]string, 0)
+	cur := strings.Builder{}
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range cond {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')' || r == '|':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type condParser struct {
+	cond   string
+	tokens []string
+	pos    int
+	names  []string
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *condParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *condParser) errorf(format string, args ...interface{}) error {
+	return &ErrCondition{p.cond, fmt.Sprintf(format, args...)}
+}
+
+// compileCondition compiles a condition given the names of the detections
+// defined in the rule
+func compileCondition(cond string, names []string) (condition, error) {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	p := condParser{cond: cond, tokens: tokenize(cond), names: sorted}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t == "|" {
+		return nil, p.errorf("aggregations are not supported")
+	} else if t != "" {
+		return nil, p.errorf("unexpected %q", t)
+	}
+	return c, nil
+}
+
+func (p *condParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(test func(string) bool) bool { return l(test) || right(test) }
+	}
+	return left, nil
+}
+
+func (p *condParser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(test func(string) bool) bool { return l(test) && right(test) }
+	}
+	return left, nil
+}
+
+func (p *condParser) parseNot() (condition, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(test func(string) bool) bool { return !c(test) }, nil
+	}
+	return p.parsePrimary()
+}
+
+// matching returns the detection names matching a pattern (or all the names
+// not starting with _ for them)
+func (p *condParser) matching(pattern string) ([]string, error) {
+	out := make([]string, 0)
+	for _, n := range p.names {
+		if pattern == "them" {
+			if !strings.HasPrefix(n, "_") {
+				out = append(out, n)
+			}
+			continue
+		}
+		ok, err := path.Match(pattern, n)
+		if err != nil {
+			return nil, p.errorf("bad pattern %q", pattern)
+		}
+		if ok {
+			out = append(out, n)
+		}
+	}
+	if len(out) == 0 {
+		return nil, p.errorf("no detection matching %q", pattern)
+	}
+	return out, nil
+}
+
+func (p *condParser) parsePrimary() (condition, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, p.errorf("unexpected end of condition")
+	case t == "(":
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, p.errorf("missing )")
+		}
+		return c, nil
+	case (t == "1" || strings.EqualFold(t, "any") || strings.EqualFold(t, "all")) && strings.EqualFold(p.peek(), "of"):
+		p.next()
+		names, err := p.matching(p.next())
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(t, "all") {
+			return func(test func(string) bool) bool {
+				for _, n := range names {
+					if !test(n) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		}
+		return func(test func(string) bool) bool {
+			for _, n := range names {
+				if test(n) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case t == ")" || t == "|" || strings.EqualFold(t, "and") || strings.EqualFold(t, "or"):
+		return nil, p.errorf("unexpected %q", t)
+	}
+	i := sort.SearchStrings(p.names, t)
+	if i == len(p.names) || p.names[i] != t {
+		return nil, p.errorf("unknown detection %q", t)
+	}
+	return func(test func(string) bool) bool { return test(t) }, nil
+}
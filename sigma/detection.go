@@ -0,0 +1,301 @@
+package sigma
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// detection is a compiled detection (named search identifier of a rule)
+type detection func(e *evtx.GoEvtxMap) bool
+
+var (
+	// FieldMapping maps Sigma field names to event paths, fields not found
+	// in this map are searched in EventData, UserData and System
+	FieldMapping = map[string]evtx.GoEvtxPath{
+		"EventID":       evtx.EventIDPath,
+		"Channel":       evtx.ChannelPath,
+		"EventRecordID": evtx.EventRecordIDPath,
+		"Provider_Name": evtx.Path("/Event/System/Provider/Name"),
+		"Computer":      evtx.Path("/Event/System/Computer"),
+		"Level":         evtx.Path("/Event/System/Level"),
+		"Task":          evtx.Path("/Event/System/Task"),
+		"Opcode":        evtx.Path("/Event/System/Opcode"),
+		"Keywords":      evtx.Path("/Event/System/Keywords"),
+		"UserID":        evtx.UserIDPath,
+	}
+
+	eventDataPath = evtx.Path("/Event/EventData")
+	userDataPath  = evtx.Path("/Event/UserData")
+	systemPath    = evtx.Path("/Event/System")
+)
+
+// unwrap returns the Value of nodes having attributes (like EventID having
+// a Qualifiers attribute)
+func unwrap(v interface{}) interface{} {
+	switch m := v.(type) {
+	case evtx.GoEvtxMap:
+		if val, ok := m["Value"]; ok {
+			return val
+		}
+	case map[string]interface{}:
+		if val, ok := m["Value"]; ok {
+			return val
+		}
+	}
+	return v
+}
+
+func asMap(v interface{}) (evtx.GoEvtxMap, bool) {
+	switch m := v.(type) {
+	case evtx.GoEvtxMap:
+		return m, true
+	case map[string]interface{}:
+		return evtx.GoEvtxMap(m), true
+	}
+	return nil, false
+}
+
+// fieldGetter returns a function retrieving the value of a Sigma field
+func fieldGetter(field string) func(e *evtx.GoEvtxMap) (interface{}, bool) {
+	if p, ok := FieldMapping[field]; ok {
+		return func(e *evtx.GoEvtxMap) (interface{}, bool) {
+			v, err := e.Get(&p)
+			if err != nil {
+				return nil, false
+			}
+			return unwrap(*v), true
+		}
+	}
+	return func(e *evtx.GoEvtxMap) (interface{}, bool) {
+		for _, p := range []evtx.GoEvtxPath{eventDataPath, userDataPath, systemPath} {
+			m, err := e.Get(&p)
+			if err != nil {
+				continue
+			}
+			node, ok := asMap(*m)
+			if !ok {
+				continue
+			}
+			if v, ok := node[field]; ok {
+				return unwrap(v), true
+			}
+			// UserData content is wrapped into an element named after the event
+			if p[len(p)-1] == "UserData" {
+				for _, child := range node {
+					if cm, ok := asMap(child); ok {
+						if v, ok := cm[field]; ok {
+							return unwrap(v), true
+						}
+					}
+				}
+			}
+		}
+		return nil, false
+	}
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case nil:
+		return ""
+	case evtx.UTCTime:
+		return time.Time(s).UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return s.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// stringsOf returns the string values of v
+func stringsOf(v interface{}) []string {
+	switch l := v.(type) {
+	case []string:
+		return l
+	case []interface{}:
+		out := make([]string, len(l))
+		for i := range l {
+			out[i] = toString(l[i])
+		}
+		return out
+	}
+	return []string{toString(v)}
+}
+
+// leaves returns all the string values found in a node
+func leaves(v interface{}, out []string) []string {
+	if m, ok := asMap(v); ok {
+		for _, c := range m {
+			out = leaves(c, out)
+		}
+		return out
+	}
+	return append(out, stringsOf(v)...)
+}
+
+// compileField compiles a field search like "CommandLine|contains|all: [a, b]"
+func compileField(key string, value interface{}) (detection, error) {
+	parts := strings.Split(key, "|")
+	field, modifiers := parts[0], parts[1:]
+	all := false
+	for _, m := range modifiers {
+		if m == "all" {
+			all = true
+		}
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	allowNull := false
+	matchers := make([]stringMatcher, 0, len(values))
+	for _, v := range values {
+		if v == nil {
+			allowNull = true
+			continue
+		}
+		sm, err := compileValue(toString(v), modifiers)
+		if err != nil {
+			return nil, err
+		}
+		// a value may result in several alternatives (base64offset)
+		if len(sm) == 1 {
+			matchers = append(matchers, sm[0])
+		} else {
+			alternatives := sm
+			matchers = append(matchers, func(s string) bool {
+				for _, a := range alternatives {
+					if a(s) {
+						return true
+					}
+				}
+				return false
+			})
+		}
+	}
+
+	get := fieldGetter(field)
+	return func(e *evtx.GoEvtxMap) bool {
+		v, ok := get(e)
+		if !ok || toString(v) == "" {
+			if allowNull {
+				return true
+			}
+			if !ok {
+				return false
+			}
+		}
+		strs := stringsOf(v)
+		matchOne := func(m stringMatcher) bool {
+			for _, s := range strs {
+				if m(s) {
+					return true
+				}
+			}
+			return false
+		}
+		if all {
+			for _, m := range matchers {
+				if !matchOne(m) {
+					return false
+				}
+			}
+			return len(matchers) > 0
+		}
+		for _, m := range matchers {
+			if matchOne(m) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// compileKeywords compiles a list of keywords searched in all the values of
+// the event
+func compileKeywords(keywords []interface{}) (detection, error) {
+	matchers := make([]stringMatcher, 0, len(keywords))
+	for _, k := range keywords {
+		sm, err := compileValue(toString(k), []string{"contains"})
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, sm...)
+	}
+	return func(e *evtx.GoEvtxMap) bool {
+		for _, s := range leaves(*e, nil) {
+			for _, m := range matchers {
+				if m(s) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// compileFieldMap compiles a map of field searches, all of them must match
+func compileFieldMap(m map[interface{}]interface{}) (detection, error) {
+	fields := make([]detection, 0, len(m))
+	for k, v := range m {
+		d, err := compileField(toString(k), v)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, d)
+	}
+	return func(e *evtx.GoEvtxMap) bool {
+		for _, f := range fields {
+			if !f(e) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// compileDetection compiles a search identifier of the detection section
+func compileDetection(v interface{}) (detection, error) {
+	switch d := v.(type) {
+	case map[interface{}]interface{}:
+		return compileFieldMap(d)
+	case []interface{}:
+		alternatives := make([]detection, 0, len(d))
+		keywords := make([]interface{}, 0)
+		for _, item := range d {
+			if m, ok := item.(map[interface{}]interface{}); ok {
+				fm, err := compileFieldMap(m)
+				if err != nil {
+					return nil, err
+				}
+				alternatives = append(alternatives, fm)
+			} else {
+				keywords = append(keywords, item)
+			}
+		}
+		if len(keywords) > 0 {
+			kw, err := compileKeywords(keywords)
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, kw)
+		}
+		return func(e *evtx.GoEvtxMap) bool {
+			for _, a := range alternatives {
+				if a(e) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case string, int, int64, float64, bool:
+		return compileKeywords([]interface{}{d})
+	}
+	return nil, fmt.Errorf("Unsupported detection type %T", v)
+}
@@ -0,0 +1,96 @@
+package sigma
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// Engine holds a set of rules indexed by channel so that only the rules
+// applying to an event are evaluated
+type Engine struct {
+	byChannel map[string][]*Rule
+	generic   []*Rule
+	count     int
+}
+
+// NewEngine creates a new empty Engine
+func NewEngine() *Engine {
+	return &Engine{byChannel: make(map[string][]*Rule)}
+}
+
+// AddRule adds a compiled rule to the engine
+func (en *Engine) AddRule(r *Rule) {
+	en.count++
+	if r.channels == nil {
+		en.generic = append(en.generic, r)
+		return
+	}
+	for c := range r.channels {
+		en.byChannel[c] = append(en.byChannel[c], r)
+	}
+}
+
+// Len returns the number of rules loaded in the engine
+func (en *Engine) Len() int {
+	return en.count
+}
+
+// Load loads the rules of a YAML file into the engine
+// @path : path to the rule file
+func (en *Engine) Load(path string) error {
+	rules, err := LoadRules(path)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		en.AddRule(r)
+	}
+	return nil
+}
+
+// LoadDirectory loads recursively all the YAML rules found in a directory.
+// Rules failing to load do not stop the loading, the errors are returned.
+// @dir : directory to load the rules from
+// return []error : errors encountered
+func (en *Engine) LoadDirectory(dir string) (errs []error) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if info.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			return nil
+		}
+		if err := en.Load(path); err != nil {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return
+}
+
+// Match returns the rules matching the event ordered by decreasing level
+// @e : event to match
+// return []*Rule
+func (en *Engine) Match(e *evtx.GoEvtxMap) (matches []*Rule) {
+	if e == nil {
+		return
+	}
+	channel, _ := e.GetString(&evtx.ChannelPath)
+	for _, rules := range [][]*Rule{en.byChannel[strings.ToLower(channel)], en.generic} {
+		for _, r := range rules {
+			if r.Match(e) {
+				matches = append(matches, r)
+			}
+		}
+	}
+	sortRules(matches)
+	return
+}
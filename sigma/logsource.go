@@ -0,0 +1,104 @@
+package sigma
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Logsource structure as defined in Sigma rules
+type Logsource struct {
+	Product    string `yaml:"product"`
+	Service    string `yaml:"service"`
+	Category   string `yaml:"category"`
+	Definition string `yaml:"definition"`
+}
+
+// mapping of a logsource to channels and event ids
+type sourceMapping struct {
+	Channels []string
+	EventIDs []int64
+}
+
+var (
+	sysmonChannel     = "Microsoft-Windows-Sysmon/Operational"
+	powershellChannel = "Microsoft-Windows-PowerShell/Operational"
+
+	// ServiceMapping maps Sigma windows services to event channels
+	ServiceMapping = map[string][]string{
+		"security":           {"Security"},
+		"system":             {"System"},
+		"application":        {"Application"},
+		"sysmon":             {sysmonChannel},
+		"powershell":         {powershellChannel},
+		"powershell-classic": {"Windows PowerShell"},
+		"taskscheduler":      {"Microsoft-Windows-TaskScheduler/Operational"},
+		"wmi":                {"Microsoft-Windows-WMI-Activity/Operational"},
+		"dns-server":         {"DNS Server"},
+		"driver-framework":   {"Microsoft-Windows-DriverFrameworks-UserMode/Operational"},
+		"windefend":          {"Microsoft-Windows-Windows Defender/Operational"},
+		"bits-client":        {"Microsoft-Windows-Bits-Client/Operational"},
+		"firewall-as":        {"Microsoft-Windows-Windows Firewall With Advanced Security/Firewall"},
+	}
+
+	// CategoryMapping maps Sigma windows categories to Sysmon and PowerShell
+	// events. Only Sysmon is covered for the process categories, the Security
+	// events (ex: 4688) use other field names (ex: NewProcessName instead of
+	// Image) which rules written for Sysmon would not match.
+	CategoryMapping = map[string]sourceMapping{
+		"process_creation":          {[]string{sysmonChannel}, []int64{1}},
+		"file_change":               {[]string{sysmonChannel}, []int64{2}},
+		"network_connection":        {[]string{sysmonChannel}, []int64{3}},
+		"sysmon_status":             {[]string{sysmonChannel}, []int64{4, 16}},
+		"process_termination":       {[]string{sysmonChannel}, []int64{5}},
+		"driver_load":               {[]string{sysmonChannel}, []int64{6}},
+		"image_load":                {[]string{sysmonChannel}, []int64{7}},
+		"create_remote_thread":      {[]string{sysmonChannel}, []int64{8}},
+		"raw_access_thread":         {[]string{sysmonChannel}, []int64{9}},
+		"process_access":            {[]string{sysmonChannel}, []int64{10}},
+		"file_event":                {[]string{sysmonChannel}, []int64{11}},
+		"registry_event":            {[]string{sysmonChannel}, []int64{12, 13, 14}},
+		"registry_add":              {[]string{sysmonChannel}, []int64{12}},
+		"registry_delete":           {[]string{sysmonChannel}, []int64{12}},
+		"registry_set":              {[]string{sysmonChannel}, []int64{13}},
+		"registry_rename":           {[]string{sysmonChannel}, []int64{14}},
+		"create_stream_hash":        {[]string{sysmonChannel}, []int64{15}},
+		"pipe_created":              {[]string{sysmonChannel}, []int64{17, 18}},
+		"wmi_event":                 {[]string{sysmonChannel}, []int64{19, 20, 21}},
+		"dns_query":                 {[]string{sysmonChannel}, []int64{22}},
+		"file_delete":               {[]string{sysmonChannel}, []int64{23, 26}},
+		"clipboard_capture":         {[]string{sysmonChannel}, []int64{24}},
+		"process_tampering":         {[]string{sysmonChannel}, []int64{25}},
+		"ps_module":                 {[]string{powershellChannel}, []int64{4103}},
+		"ps_script":                 {[]string{powershellChannel}, []int64{4104}},
+		"ps_classic_start":          {[]string{"Windows PowerShell"}, []int64{400}},
+		"ps_classic_provider_start": {[]string{"Windows PowerShell"}, []int64{600}},
+	}
+)
+
+// mapping returns the channels and event ids the logsource applies to, nil
+// slices mean no restriction. An error is returned if the logsource is not
+// about Windows events or if its service or category is unknown, as the rule
+// would otherwise apply to every event.
+func (l *Logsource) mapping() (m sourceMapping, err error) {
+	if l.Product != "" && !strings.EqualFold(l.Product, "windows") {
+		return m, fmt.Errorf("Unsupported logsource product: %s", l.Product)
+	}
+	if l.Service != "" {
+		channels, found := ServiceMapping[strings.ToLower(l.Service)]
+		if !found {
+			return m, fmt.Errorf("Unsupported logsource service: %s", l.Service)
+		}
+		m.Channels = channels
+	}
+	if l.Category != "" {
+		cm, found := CategoryMapping[strings.ToLower(l.Category)]
+		if !found {
+			return m, fmt.Errorf("Unsupported logsource category: %s", l.Category)
+		}
+		if m.Channels == nil {
+			m.Channels = cm.Channels
+		}
+		m.EventIDs = cm.EventIDs
+	}
+	return
+}
@@ -0,0 +1,186 @@
+package sigma
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// stringMatcher tests a single string value
+type stringMatcher func(s string) bool
+
+// ErrUnsupportedModifier is returned when a rule uses an unknown modifier
+type ErrUnsupportedModifier struct {
+	Modifier string
+}
+
+func (e *ErrUnsupportedModifier) Error() string {
+	return fmt.Sprintf("Unsupported modifier: %s", e.Modifier)
+}
+
+// pattern is a Sigma string value splitted into literals and wildcards
+type pattern struct {
+	raw      string
+	literal  string
+	wildcard bool
+}
+
+// parsePattern handles Sigma wildcards (* and ?) and their escaping
+func parsePattern(s string) pattern {
+	p := pattern{raw: s}
+	sb := strings.Builder{}
+	re := strings.Builder{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && (runes[i+1] == '*' || runes[i+1] == '?' || runes[i+1] == '\\'):
+			i++
+			sb.WriteRune(runes[i])
+			re.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case r == '*':
+			p.wildcard = true
+			re.WriteString(".*")
+		case r == '?':
+			p.wildcard = true
+			re.WriteString(".")
+		default:
+			sb.WriteRune(r)
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if p.wildcard {
+		p.raw = re.String()
+	} else {
+		p.literal = sb.String()
+	}
+	return p
+}
+
+// compileString compiles a string matcher for a value according to the
+// position modifier (contains, startswith, endswith or empty for equality).
+// Matching is case insensitive.
+func compileString(value, position string) (stringMatcher, error) {
+	p := parsePattern(value)
+	if !p.wildcard {
+		lit := strings.ToLower(p.literal)
+		switch position {
+		case "contains":
+			return func(s string) bool { return strings.Contains(strings.ToLower(s), lit) }, nil
+		case "startswith":
+			return func(s string) bool { return strings.HasPrefix(strings.ToLower(s), lit) }, nil
+		case "endswith":
+			return func(s string) bool { return strings.HasSuffix(strings.ToLower(s), lit) }, nil
+		}
+		return func(s string) bool { return strings.EqualFold(s, lit) }, nil
+	}
+	expr := p.raw
+	switch position {
+	case "contains":
+		expr = ".*" + expr + ".*"
+	case "startswith":
+		expr = expr + ".*"
+	case "endswith":
+		expr = ".*" + expr
+	}
+	re, err := regexp.Compile("(?is)^" + expr + "$")
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
+
+// base64Offsets returns the three base64 encodings of value depending on its
+// offset in the encoded data, stripped from the characters depending on the
+// surrounding data
+func base64Offsets(value string) []string {
+	out := make([]string, 0, 3)
+	start := []int{0, 2, 3}
+	for i := 0; i < 3; i++ {
+		encoded := base64.StdEncoding.EncodeToString(append(make([]byte, i), value...))
+		end := len(encoded) - []int{0, 3, 2}[(len(value)+i)%3]
+		if start[i] < end {
+			out = append(out, encoded[start[i]:end])
+		}
+	}
+	return out
+}
+
+// compileValue compiles the matchers for a value according to the modifiers
+func compileValue(value string, modifiers []string) ([]stringMatcher, error) {
+	position := ""
+	values := []string{value}
+	isRegexp, isCIDR := false, false
+	for _, m := range modifiers {
+		switch m {
+		case "contains", "startswith", "endswith":
+			position = m
+		case "all":
+			// handled by the caller
+		case "base64":
+			for i := range values {
+				values[i] = base64.StdEncoding.EncodeToString([]byte(values[i]))
+			}
+		case "base64offset":
+			encoded := make([]string, 0)
+			for _, v := range values {
+				encoded = append(encoded, base64Offsets(v)...)
+			}
+			values = encoded
+			// encoded values are always searched in the field
+			if position == "" {
+				position = "contains"
+			}
+		case "wide", "utf16le":
+			for i := range values {
+				values[i] = toUTF16LE(values[i])
+			}
+		case "re":
+			isRegexp = true
+		case "cidr":
+			isCIDR = true
+		default:
+			return nil, &ErrUnsupportedModifier{m}
+		}
+	}
+
+	matchers := make([]stringMatcher, 0, len(values))
+	for _, v := range values {
+		switch {
+		case isRegexp:
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, re.MatchString)
+		case isCIDR:
+			_, network, err := net.ParseCIDR(v)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, func(s string) bool {
+				ip := net.ParseIP(strings.TrimSpace(s))
+				return ip != nil && network.Contains(ip)
+			})
+		default:
+			sm, err := compileString(v, position)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, sm)
+		}
+	}
+	return matchers, nil
+}
+
+func toUTF16LE(s string) string {
+	sb := strings.Builder{}
+	for _, u := range utf16.Encode([]rune(s)) {
+		sb.WriteByte(byte(u))
+		sb.WriteByte(byte(u >> 8))
+	}
+	return sb.String()
+}
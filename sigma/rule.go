@@ -0,0 +1,273 @@
+/*
+Package sigma implements a Sigma rule engine working on GoEvtxMap events.
+
+Rules are compiled once when loaded: values are turned into specialized string
+matchers (regexp are only used for wildcards and the re modifier) and the
+logsource is turned into a channel and event id pre-filter used by the Engine
+to only evaluate the rules applying to a given event.
+
+Supported field modifiers are contains, startswith, endswith, all, re, cidr,
+base64, base64offset and wide. Conditions support and, or, not, parenthesis,
+"1 of", "any of" and "all of" selections. Aggregations are not supported.
+*/
+package sigma
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"gopkg.in/yaml.v2"
+)
+
+// Levels of the rules ordered by severity
+var Levels = map[string]int{
+	"informational": 0,
+	"low":           1,
+	"medium":        2,
+	"high":          3,
+	"critical":      4,
+}
+
+// Rule structure definition
+type Rule struct {
+	Title       string                 `yaml:"title"`
+	ID          string                 `yaml:"id"`
+	Status      string                 `yaml:"status"`
+	Description string                 `yaml:"description"`
+	Author      string                 `yaml:"author"`
+	Level       string                 `yaml:"level"`
+	Tags        []string               `yaml:"tags"`
+	Logsource   Logsource              `yaml:"logsource"`
+	Detection   map[string]interface{} `yaml:"detection"`
+	// Path of the file the rule was loaded from
+	Path string `yaml:"-"`
+
+	channels   map[string]bool
+	eventIDs   map[int64]bool
+	detections map[string]detection
+	condition  condition
+}
+
+// ErrRule is returned when a rule cannot be compiled
+type ErrRule struct {
+	Rule string
+	Err  error
+}
+
+func (e *ErrRule) Error() string {
+	return fmt.Sprintf("Rule %q: %s", e.Rule, e.Err)
+}
+
+// compile compiles the rule detection and logsource
+func (r *Rule) compile() error {
+	m, err := r.Logsource.mapping()
+	if err != nil {
+		return err
+	}
+	if len(m.Channels) > 0 {
+		r.channels = make(map[string]bool)
+		for _, c := range m.Channels {
+			r.channels[strings.ToLower(c)] = true
+		}
+	}
+	if len(m.EventIDs) > 0 {
+		r.eventIDs = make(map[int64]bool)
+		for _, eid := range m.EventIDs {
+			r.eventIDs[eid] = true
+		}
+	}
+
+	conditions := make([]string, 0)
+	switch c := r.Detection["condition"].(type) {
+	case string:
+		conditions = append(conditions, c)
+	case []interface{}:
+		for _, s := range c {
+			conditions = append(conditions, toString(s))
+		}
+	default:
+		return fmt.Errorf("Missing condition")
+	}
+
+	r.detections = make(map[string]detection)
+	names := make([]string, 0, len(r.Detection))
+	for name, v := range r.Detection {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+		d, err := compileDetection(v)
+		if err != nil {
+			return fmt.Errorf("Detection %s: %s", name, err)
+		}
+		r.detections[name] = d
+		names = append(names, name)
+	}
+
+	// several conditions are alternatives
+	compiled := make([]condition, 0, len(conditions))
+	for _, c := range conditions {
+		cc, err := compileCondition(c, names)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, cc)
+	}
+	r.condition = func(test func(string) bool) bool {
+		for _, c := range compiled {
+			if c(test) {
+				return true
+			}
+		}
+		return false
+	}
+	return nil
+}
+
+// applies returns true if the logsource of the rule applies to the event
+func (r *Rule) applies(e *evtx.GoEvtxMap) bool {
+	if r.eventIDs != nil {
+		eid, err := e.GetInt(&evtx.EventIDPath)
+		if err != nil {
+			if eid, err = e.GetInt(&evtx.EventIDPath2); err != nil {
+				return false
+			}
+		}
+		if !r.eventIDs[eid] {
+			return false
+		}
+	}
+	if r.channels != nil {
+		channel, err := e.GetString(&evtx.ChannelPath)
+		if err != nil || !r.channels[strings.ToLower(channel)] {
+			return false
+		}
+	}
+	return true
+}
+
+// Match returns true if the event matches the rule
+func (r *Rule) Match(e *evtx.GoEvtxMap) bool {
+	if e == nil || !r.applies(e) {
+		return false
+	}
+	cache := make(map[string]bool, len(r.detections))
+	return r.condition(func(name string) bool {
+		if res, ok := cache[name]; ok {
+			return res
+		}
+		res := r.detections[name](e)
+		cache[name] = res
+		return res
+	})
+}
+
+// LevelValue returns the numerical value of the rule level
+func (r *Rule) LevelValue() int {
+	return Levels[strings.ToLower(r.Level)]
+}
+
+// merge merges a global rule document into a rule document
+func merge(global, doc map[interface{}]interface{}) map[interface{}]interface{} {
+	out := make(map[interface{}]interface{})
+	for k, v := range global {
+		out[k] = v
+	}
+	for k, v := range doc {
+		gm, gok := out[k].(map[interface{}]interface{})
+		dm, dok := v.(map[interface{}]interface{})
+		if gok && dok {
+			out[k] = merge(gm, dm)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// ParseRules parses the Sigma rules contained in YAML data. Rule collections
+// (multiple documents with an "action: global" document) are supported.
+// @data : YAML data
+// return ([]*Rule, error)
+func ParseRules(data []byte) ([]*Rule, error) {
+	rules := make([]*Rule, 0, 1)
+	global := make(map[interface{}]interface{})
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := make(map[interface{}]interface{})
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		switch doc["action"] {
+		case "global":
+			delete(doc, "action")
+			global = doc
+			continue
+		case "reset":
+			global = make(map[interface{}]interface{})
+			continue
+		case "repeat":
+			delete(doc, "action")
+			if len(rules) > 0 {
+				prev := rules[len(rules)-1]
+				b, _ := yaml.Marshal(prev)
+				base := make(map[interface{}]interface{})
+				yaml.Unmarshal(b, &base)
+				doc = merge(base, doc)
+			}
+		}
+		// we go through YAML again to decode the merged document
+		b, err := yaml.Marshal(merge(global, doc))
+		if err != nil {
+			return nil, err
+		}
+		r := Rule{}
+		if err := yaml.Unmarshal(b, &r); err != nil {
+			return nil, err
+		}
+		if err := r.compile(); err != nil {
+			return nil, &ErrRule{r.Title, err}
+		}
+		rules = append(rules, &r)
+	}
+	return rules, nil
+}
+
+// LoadRules loads the rules defined in a file
+// @path : path to the YAML file
+// return ([]*Rule, error)
+func LoadRules(path string) ([]*Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ParseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, r := range rules {
+		r.Path = path
+	}
+	return rules, nil
+}
+
+// sortRules sorts rules by decreasing level and then by title
+func sortRules(rules []*Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].LevelValue() != rules[j].LevelValue() {
+			return rules[i].LevelValue() > rules[j].LevelValue()
+		}
+		return rules[i].Title < rules[j].Title
+	})
+}
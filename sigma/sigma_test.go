@@ -0,0 +1,105 @@
+package sigma
+
+import (
+	"testing"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+var (
+	sysmonRule = `
+title: Suspicious Encoded PowerShell
+id: 1c9e2b2a-7d0f-4a43-a0a6-000000000001
+level: high
+logsource:
+  product: windows
+  category: process_creation
+detection:
+  image:
+    Image|endswith: '\powershell.exe'
+  selection_cli:
+    CommandLine|contains|all:
+      - ' -enc'
+      - 'bypass'
+  selection_b64:
+    CommandLine|base64offset|contains: 'IEX'
+  filter:
+    ParentImage|startswith: 'C:\Program Files\'
+  filter_ip:
+    SourceIp|cidr: '10.0.0.0/8'
+  condition: image and 1 of selection_* and not 1 of filter*
+`
+	securityRule = `
+title: RDP Logon
+level: medium
+logsource:
+  product: windows
+  service: security
+detection:
+  selection:
+    EventID:
+      - 4624
+      - 4625
+    LogonType: 10
+  keywords:
+    - '*admin*'
+  condition: all of them
+`
+)
+
+func sysmonEvent(cmdline, parent string) *evtx.GoEvtxMap {
+	return &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{
+		"System": evtx.GoEvtxMap{
+			"Channel": "Microsoft-Windows-Sysmon/Operational",
+			"EventID": "1",
+		},
+		"EventData": evtx.GoEvtxMap{
+			"Image":       `C:\Windows\System32\WindowsPowerShell\v1.0\PowerShell.exe`,
+			"CommandLine": cmdline,
+			"ParentImage": parent,
+		},
+	}}
+}
+
+func TestRules(t *testing.T) {
+	en := NewEngine()
+	for _, data := range []string{sysmonRule, securityRule} {
+		rules, err := ParseRules([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rules {
+			en.AddRule(r)
+		}
+	}
+
+	for e, expect := range map[*evtx.GoEvtxMap]int{
+		sysmonEvent("powershell -ENC abc -ep Bypass", `C:\Windows\explorer.exe`):        1,
+		sysmonEvent("powershell -ENC abc -ep Bypass", `C:\Program Files\Tool\tool.exe`): 0,
+		sysmonEvent("powershell -nop", `C:\Windows\explorer.exe`):                       0,
+		sysmonEvent("powershell -e SUVYIChOZXctT2JqZWN0", `C:\Windows\explorer.exe`):    1,
+		{"Event": evtx.GoEvtxMap{"System": evtx.GoEvtxMap{"Channel": "Security", "EventID": "4624"},
+			"EventData": evtx.GoEvtxMap{"LogonType": "10", "TargetUserName": "Administrator"}}}: 1,
+		{"Event": evtx.GoEvtxMap{"System": evtx.GoEvtxMap{"Channel": "Security", "EventID": "4624"},
+			"EventData": evtx.GoEvtxMap{"LogonType": "3", "TargetUserName": "Administrator"}}}: 0,
+	} {
+		if m := en.Match(e); len(m) != expect {
+			t.Errorf("%s: expected %d matches got %d", evtx.ToJSON(e), expect, len(m))
+		}
+	}
+}
+
+func TestBadRules(t *testing.T) {
+	for _, data := range []string{
+		"title: t\nlogsource: {product: windows}\ndetection: {sel: {a: b}, condition: sel | count() > 5}",
+		"title: t\nlogsource: {product: windows}\ndetection: {sel: {a|foo: b}, condition: sel}",
+		"title: t\nlogsource: {product: windows}\ndetection: {sel: {a: b}, condition: other}",
+		"title: t\nlogsource: {product: linux}\ndetection: {sel: {a: b}, condition: sel}",
+		"title: t\nlogsource: {product: windows, service: msexchange-management}\ndetection: {sel: {a: b}, condition: sel}",
+		"title: t\nlogsource: {product: windows, category: ps_classic_script}\ndetection: {sel: {a: b}, condition: sel}",
+	} {
+		if _, err := ParseRules([]byte(data)); err == nil {
+			t.Errorf("expected error for: %s", data)
+		}
+	}
+}
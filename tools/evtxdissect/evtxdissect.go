@@ -0,0 +1,105 @@
+/*
+EVTX dissecting utility, it prints the annotated BinXML of a record to debug
+the parser
+
+Copyright (C) 2017  RawSec SARL (0xrawsec)
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-utils/log"
+)
+
+const (
+	// ExitSuccess RC
+	ExitSuccess = 0
+	// ExitFail RC
+	ExitFail  = 1
+	Copyright = "Evtxdissect Copyright (C) 2017 RawSec SARL (@0xrawsec)"
+	License   = `License GPLv3: This program comes with ABSOLUTELY NO WARRANTY.
+This is free software, and you are welcome to redistribute it under certain
+conditions;`
+)
+
+var (
+	debug   bool
+	version bool
+	id      int64 = -1
+	offset  string
+)
+
+func main() {
+	flag.BoolVar(&debug, "d", debug, "Enable debug mode")
+	flag.BoolVar(&version, "V", version, "Show version and exit")
+	flag.Int64Var(&id, "id", id, "ID of the record to dissect (EventRecordID)")
+	flag.StringVar(&offset, "o", offset, "Offset of the record to dissect in the file (ex: 0x1200)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s (commit: %s)\n%s\n%s\n\n", Version, CommitID, Copyright, License)
+		fmt.Fprintf(os.Stderr, "Usage of %s: %[1]s [OPTIONS] -id ID|-o OFFSET FILE\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	// Debug mode
+	if debug {
+		log.InitLogger(log.LDebug)
+	}
+
+	// version
+	if version {
+		fmt.Fprintf(os.Stderr, "%s (commit: %s)\n%s\n%s\n", Version, CommitID, Copyright, License)
+		return
+	}
+
+	if flag.NArg() != 1 || (id < 0) == (offset == "") {
+		flag.Usage()
+		os.Exit(ExitFail)
+	}
+
+	ef, err := evtx.OpenDirty(flag.Arg(0))
+	if err != nil {
+		log.Abort(ExitFail, err)
+	}
+	defer ef.Close()
+
+	var d *evtx.Dissection
+	if offset != "" {
+		o, err := strconv.ParseInt(offset, 0, 64)
+		if err != nil {
+			log.Abort(ExitFail, fmt.Errorf("Bad offset %s: %s", offset, err))
+		}
+		if d, err = ef.DissectAt(o); err != nil {
+			log.Abort(ExitFail, err)
+		}
+	} else if d, err = ef.DissectID(id); err != nil {
+		log.Abort(ExitFail, err)
+	}
+
+	d.Print(os.Stdout)
+	if d.Err != nil || d.DecodeErr != nil {
+		os.Exit(ExitFail)
+	}
+}
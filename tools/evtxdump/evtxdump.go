@@ -22,20 +22,29 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime/pprof"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-evtx/normalize"
 	"github.com/0xrawsec/golang-evtx/output"
+	"github.com/0xrawsec/golang-evtx/pipeline"
+	"github.com/0xrawsec/golang-evtx/query"
+	"github.com/0xrawsec/golang-evtx/xpath"
 	"github.com/0xrawsec/golang-utils/args"
 	"github.com/0xrawsec/golang-utils/log"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -50,27 +59,144 @@ conditions;`
 )
 
 var (
-	debug         bool
-	carve         bool
-	timestamp     bool
-	version       bool
-	unordered     bool
-	statflag      bool
-	header        bool
-	offset        int64
-	limit         int
-	tag           string
-	outTcp        string
-	outHttp       string
-	outType       string
-	brURL         string
-	cID           string
-	topic         string
-	start, stop   args.DateVar
-	chunkHeaderRE = regexp.MustCompile(evtx.ChunkMagic)
-	defaultTime   = time.Time{}
+	debug           bool
+	carve           bool
+	timestamp       bool
+	version         bool
+	unordered       bool
+	merge           bool
+	statflag        bool
+	header          bool
+	offset          int64
+	limit           int
+	tag             string
+	outTcp          string
+	tcpNet          string
+	tcpFraming      string
+	tcpWriteTimeout time.Duration
+	tcpKeepAlive    time.Duration
+	outHttp         string
+	httpFormat      string
+	httpCompression string
+	httpHeaders     = headers{}
+	httpUser        string
+	httpProxy       string
+	httpTimeout     time.Duration
+	outType         string
+	brURL           string
+	cID             string
+	topic           string
+	kafkaKey        string
+	kafkaCodec      string
+	kafkaAcks       string
+	kafkaAsync      bool
+	kafkaTLS        bool
+	kafkaSASL       string
+	kafkaUser       string
+	batchSize       int
+	retries         int
+	flushInterval   time.Duration
+	queueDir        string
+	outSyslog       string
+	syslogNet       string
+	syslogFacility  string
+	syslogFormat    string
+	tlsConfig       output.TLSConfig
+	outES           string
+	esIndex         string
+	esUser          string
+	outHEC          string
+	hecIndex        string
+	hecSourcetype   string
+	hecAck          bool
+	outGELF         string
+	gelfNet         string
+	gelfCompression string
+	outFluent       string
+	fluentNet       string
+	fluentTag       string
+	fluentMode      string
+	fluentAck       bool
+	outLoki         string
+	lokiLabels      string
+	lokiEncoding    string
+	lokiTenant      string
+	lokiUser        string
+	outFile         string
+	fileEncoding    string
+	fileCompression string
+	fileMaxSize     int64
+	fileRotate      time.Duration
+	outParquet      string
+	parquetPart     string
+	parquetRowGroup int
+	parquetSchema   string
+	parquetComp     string
+	outSQLite       string
+	routesFile      string
+	configFile      string
+	metaFields      = fields{}
+	provenance      bool
+	enricher        *pipeline.Enricher
+	typedValues     bool
+	queueMax        int64
+	overflowstr     string
+	querystr        string
+	xpathstr        string
+	schema          string
+	format          string
+	columns         string
+	csvDir          string
+	filters         []matcher
+	normalizer      *normalize.Schema
+	printer         *output.CSV
+	start, stop     args.DateVar
+	chunkHeaderRE   = regexp.MustCompile(evtx.ChunkMagic)
+	defaultTime     = time.Time{}
 )
 
+// headers is a repeatable flag of HTTP headers given as "Name: value"
+type headers map[string]string
+
+func (h headers) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+": "+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func (h headers) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return fmt.Errorf("Bad header, expecting \"Name: value\": %s", s)
+	}
+	h[strings.TrimSpace(s[:i])] = strings.TrimSpace(s[i+1:])
+	return nil
+}
+
+// fields is a repeatable flag of fields given as name=value
+type fields map[string]string
+
+func (f fields) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f fields) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("Bad field, expecting name=value: %s", s)
+	}
+	f[s[:i]] = s[i+1:]
+	return nil
+}
+
 //////////////////////////// stat structure ////////////////////////////////////
 
 type eventIDStat map[int64]uint
@@ -134,6 +260,7 @@ func fetchChunkFromReader(r io.ReadSeeker, offset int64) (evtx.Chunk, error) {
 	c := evtx.NewChunk()
 	evtx.GoToSeeker(r, offset)
 	c.Offset = offset
+	c.TypedValues = typedValues
 	c.Data = make([]byte, evtx.ChunkSize)
 	if _, err = r.Read(c.Data); err != nil {
 		return c, err
@@ -157,8 +284,9 @@ func fetchChunkFromReader(r io.ReadSeeker, offset int64) (evtx.Chunk, error) {
 	return c, nil
 }
 
-// main routine to carve a file
-func carveFile(datafile string, offset int64, limit int) {
+// main routine to carve a file, carved events are passed to handle along with
+// their position
+func carveFile(datafile string, offset int64, limit int, handle func(pipeline.Provenance, *evtx.GoEvtxMap)) {
 	chunkCnt := 0
 	f, err := os.Open(datafile)
 	if err != nil {
@@ -179,8 +307,8 @@ func carveFile(datafile string, offset int64, limit int) {
 		if err != nil {
 			log.Error(err)
 		}
-		for e := range chunk.Events() {
-			printEvent(e)
+		for r := range chunk.Records(false) {
+			handle(pipeline.RecordProvenance(datafile, r), r.Event)
 		}
 		chunkCnt++
 
@@ -191,8 +319,88 @@ func carveFile(datafile string, offset int64, limit int) {
 	}
 }
 
+// applyConfig sets the options not given on the command line after the
+// configuration
+func applyConfig(c *pipeline.Config) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	override := func(name string, value string, v *string) {
+		if !set[name] && value != "" {
+			*v = value
+		}
+	}
+	override("q", c.Filters.Query, &querystr)
+	override("xpath", c.Filters.XPath, &xpathstr)
+	override("normalize", c.Transforms.Normalize, &schema)
+	override("queue", c.Delivery.Queue, &queueDir)
+	override("overflow", c.Delivery.Overflow, &overflowstr)
+	if !set["provenance"] && c.Transforms.Provenance {
+		provenance = true
+	}
+	for k, v := range c.Transforms.Fields {
+		// fields given on the command line take precedence
+		if _, ok := metaFields[k]; !ok {
+			metaFields[k] = v
+		}
+	}
+	if !set["merge"] && c.Inputs.Merge {
+		merge = true
+	}
+	if !set["batch"] && c.Delivery.BatchSize > 0 {
+		batchSize = c.Delivery.BatchSize
+	}
+	if !set["flush"] && c.Delivery.Flush > 0 {
+		flushInterval = c.Delivery.Flush
+	}
+	if !set["retries"] && c.Delivery.Retries != nil {
+		retries = *c.Delivery.Retries
+	}
+	if !set["queueMax"] && c.Delivery.QueueMax > 0 {
+		queueMax = c.Delivery.QueueMax
+	}
+}
+
+// matcher is implemented by all the filters
+type matcher interface {
+	Match(e *evtx.GoEvtxMap) bool
+}
+
+// returns true if the event matches all the filters
+func match(e *evtx.GoEvtxMap) bool {
+	for _, f := range filters {
+		if !f.Match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// builds a filter out of an XPath expression or a QueryList. The argument can
+// either be a file or the filter itself.
+func xpathFilter(arg string) (matcher, error) {
+	if data, err := ioutil.ReadFile(arg); err == nil {
+		arg = string(data)
+	}
+	if strings.HasPrefix(strings.TrimSpace(arg), "<") {
+		return xpath.ParseQueryListString(arg)
+	}
+	return xpath.Compile(arg)
+}
+
+// returns the event normalized according to the schema selected if any and
+// enriched with its metadata
+func transform(e *evtx.GoEvtxMap, p pipeline.Provenance) *evtx.GoEvtxMap {
+	if normalizer != nil {
+		e = normalizer.Normalize(e)
+	}
+	if enricher != nil {
+		e = enricher.Enrich(e, p)
+	}
+	return e
+}
+
 // small routine that prints the EVTX event
-func printEvent(e *evtx.GoEvtxMap) {
+func printEvent(p pipeline.Provenance, e *evtx.GoEvtxMap) {
 	if e != nil {
 		t, err := e.GetTime(&evtx.SystemTimePath)
 
@@ -217,6 +425,15 @@ func printEvent(e *evtx.GoEvtxMap) {
 			}
 		}
 
+		e = transform(e, p)
+
+		if printer != nil {
+			if err := printer.Write(context.Background(), []*evtx.GoEvtxMap{e}); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
 		if timestamp {
 			if err == nil {
 				fmt.Printf("%d: %s\n", t.UnixNano(), string(evtx.ToJSON(e)))
@@ -240,22 +457,95 @@ func main() {
 	flag.BoolVar(&version, "V", version, "Show version and exit")
 	flag.BoolVar(&timestamp, "t", timestamp, "Prints event timestamp (as int) at the beginning of line to make sorting easier")
 	flag.BoolVar(&unordered, "u", unordered, "Does not care about ordering the events before printing (faster for large files)")
+	flag.BoolVar(&merge, "merge", merge, "Merges the events of all the files into a single stream ordered by time")
 	flag.BoolVar(&statflag, "s", statflag, "Prints stats about events in files")
 	flag.Int64Var(&offset, "o", offset, "Offset to start from (carving mode only)")
 	flag.IntVar(&limit, "l", limit, "Limit the number of chunks to parse (carving mode only)")
 	flag.Var(&start, "start", "Print logs starting from start")
 	flag.Var(&stop, "stop", "Print logs before stop")
+	flag.StringVar(&querystr, "q", querystr, "Query used to filter events (ex: EventID in (4624,4625) and EventData.LogonType == 10)")
+	flag.StringVar(&xpathstr, "xpath", xpathstr, "Windows XPath expression or QueryList (inline or file) used to filter events")
+	flag.StringVar(&format, "format", "json", "Format of the events printed: json, csv or tsv")
+	flag.StringVar(&columns, "columns", columns, "Comma separated list of the paths of the CSV columns (ex: System/TimeCreated/SystemTime,System/EventID,EventData/TargetUserName), all the fields are flattened into one table per event ID if empty")
+	flag.StringVar(&csvDir, "csvDir", csvDir, "Directory where the tables are written as <EventID>.csv files when no CSV column is given")
+	flag.StringVar(&schema, "normalize", schema, fmt.Sprintf("Normalizes the events to a common schema (%s)", strings.Join(normalize.Names(), ", ")))
 
 	flag.StringVar(&memprofile, "memprofile", "", "write memory profile to this file")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to this file")
 
-	flag.StringVar(&outType, "type", "", "Type of remote log collector. JSON-over-HTTP, JSON-over-TCP, Kafka")
-	flag.StringVar(&outHttp, "http", "", "url for sending output to remote site over HTTP")
-	flag.StringVar(&outTcp, "tcp", "", "tcp socket address for sending output to remote site over TCP")
-	flag.StringVar(&brURL, "brURL", "", "Kafka Broker URL")
+	flag.StringVar(&outType, "type", "", "Type of remote log collector. JSON-over-HTTP, JSON-over-TCP, Kafka, syslog, elasticsearch, splunk, gelf, fluent, loki, file, parquet, sqlite")
+	flag.StringVar(&outHttp, "http", "", "url for sending output to remote site over HTTP (password, bearer token and HMAC key are read from EVTXDUMP_HTTP_PASSWORD, EVTXDUMP_HTTP_TOKEN and EVTXDUMP_HTTP_HMAC_KEY)")
+	flag.StringVar(&httpFormat, "httpFormat", "json", "HTTP output format: json (one request per event) or ndjson (one request per batch)")
+	flag.StringVar(&httpCompression, "httpCompression", "none", "HTTP output compression: gzip or none")
+	flag.Var(httpHeaders, "httpHeader", "Header added to HTTP output requests as \"Name: value\" (can be repeated)")
+	flag.StringVar(&httpUser, "httpUser", "", "HTTP output basic authentication user")
+	flag.StringVar(&httpProxy, "httpProxy", "", "HTTP output proxy URL (taken from HTTP_PROXY/HTTPS_PROXY if empty)")
+	flag.DurationVar(&httpTimeout, "httpTimeout", 30*time.Second, "HTTP output request timeout")
+	flag.StringVar(&outTcp, "tcp", "", "Comma separated list of tcp socket addresses for sending output to remote site over TCP (batches are spread over the addresses)")
+	flag.StringVar(&tcpNet, "tcpNet", "tcp", "TCP output transport: tcp or tls")
+	flag.StringVar(&tcpFraming, "tcpFraming", "newline", "TCP output framing: newline, octet (octet-counting) or length (4 bytes big endian prefix)")
+	flag.DurationVar(&tcpWriteTimeout, "tcpWriteTimeout", 0, "Deadline of TCP output writes (0 means no deadline)")
+	flag.DurationVar(&tcpKeepAlive, "tcpKeepAlive", 0, "TCP keepalive period (0 means system default, negative disables keepalives)")
+	flag.StringVar(&brURL, "brURL", "", "Comma separated list of Kafka brokers (host:port)")
 	flag.StringVar(&topic, "topic", "", "Kafka topic")
 	flag.StringVar(&cID, "cID", "", "Kafka client ID")
+	flag.StringVar(&kafkaKey, "kafkaKey", "", "Template of Kafka message keys (ex: {Computer}/{Channel} keeps per host ordering)")
+	flag.StringVar(&kafkaCodec, "kafkaCompression", "snappy", "Kafka compression: none, gzip, snappy, lz4 or zstd")
+	flag.StringVar(&kafkaAcks, "kafkaAcks", "all", "Kafka required acks: none, leader or all")
+	flag.BoolVar(&kafkaAsync, "kafkaAsync", false, "Does not wait for Kafka messages to be delivered, delivery errors are logged")
+	flag.BoolVar(&kafkaTLS, "kafkaTLS", false, "Connects to Kafka brokers with TLS")
+	flag.StringVar(&kafkaSASL, "kafkaSASL", "", "Kafka SASL mechanism: plain, scram-sha-256 or scram-sha-512 (password is read from EVTXDUMP_KAFKA_PASSWORD)")
+	flag.StringVar(&kafkaUser, "kafkaUser", "", "Kafka SASL user")
 	flag.StringVar(&tag, "tag", "", "special tag for matching purpose on remote collector")
+	flag.StringVar(&outSyslog, "syslog", "", "syslog server address (host:port)")
+	flag.StringVar(&syslogNet, "syslogNet", "udp", "syslog transport: udp, tcp or tls")
+	flag.StringVar(&syslogFacility, "syslogFacility", "user", "syslog facility")
+	flag.StringVar(&syslogFormat, "syslogFormat", "json", "syslog message body format: json or kv (key=value)")
+	flag.StringVar(&outES, "es", "", "Elasticsearch/OpenSearch URL (password and API key are read from EVTXDUMP_ES_PASSWORD and EVTXDUMP_ES_APIKEY)")
+	flag.StringVar(&esIndex, "esIndex", output.DefaultIndexTemplate, "Elasticsearch index template")
+	flag.StringVar(&esUser, "esUser", "", "Elasticsearch user")
+	flag.StringVar(&outHEC, "hec", "", "Splunk HTTP Event Collector URL (token is read from EVTXDUMP_HEC_TOKEN)")
+	flag.StringVar(&hecIndex, "hecIndex", "", "Splunk index")
+	flag.StringVar(&hecSourcetype, "hecSourcetype", "_json", "Splunk sourcetype")
+	flag.BoolVar(&hecAck, "hecAck", false, "Waits for Splunk indexer acknowledgment")
+	flag.StringVar(&outGELF, "gelf", "", "Graylog GELF input address (host:port or URL for http)")
+	flag.StringVar(&gelfNet, "gelfNet", "udp", "GELF transport: udp, tcp, tls or http")
+	flag.StringVar(&gelfCompression, "gelfCompression", "", "GELF compression (udp and http): gzip, zlib or none")
+	flag.StringVar(&outFluent, "fluent", "", "Fluentd/Fluent Bit forward input address (shared key is read from EVTXDUMP_FLUENT_SHARED_KEY)")
+	flag.StringVar(&fluentNet, "fluentNet", "tcp", "Fluent forward transport: tcp or tls")
+	flag.StringVar(&fluentTag, "fluentTag", output.DefaultFluentTag, "Fluent tag template")
+	flag.StringVar(&fluentMode, "fluentMode", "packed", "Fluent forward mode: forward or packed")
+	flag.BoolVar(&fluentAck, "fluentAck", false, "Requires Fluent server to acknowledge messages")
+	flag.StringVar(&outLoki, "loki", "", "Grafana Loki URL (password or bearer token is read from EVTXDUMP_LOKI_PASSWORD or EVTXDUMP_LOKI_TOKEN)")
+	flag.StringVar(&lokiLabels, "lokiLabels", strings.Join(output.DefaultLokiLabels, ","), "Comma separated list of Loki stream labels: computer, channel, provider, level")
+	flag.StringVar(&lokiEncoding, "lokiEncoding", "protobuf", "Loki push encoding: protobuf or json")
+	flag.StringVar(&lokiTenant, "lokiTenant", "", "Loki tenant ID (X-Scope-OrgID)")
+	flag.StringVar(&lokiUser, "lokiUser", "", "Loki user")
+	flag.StringVar(&outFile, "file", "", "Path template of the output files (ex: out/{Computer}/{Channel}/{yyyy-MM-dd}/{EventID}.jsonl.gz)")
+	flag.StringVar(&fileEncoding, "fileEncoding", "json", "Encoding of the events written to files: json or kv")
+	flag.StringVar(&fileCompression, "fileCompression", "", "Compression of the output files: gzip, zstd or none (guessed from extension by default)")
+	flag.Int64Var(&fileMaxSize, "fileMaxSize", 0, "Size in MB at which output files are rotated (0 means no limit)")
+	flag.DurationVar(&fileRotate, "fileRotate", 0, "Duration after which output files are rotated (0 means no limit)")
+	flag.StringVar(&outParquet, "parquet", "", "Directory where Parquet files are written")
+	flag.StringVar(&parquetPart, "parquetPartition", output.DefaultParquetPartition, "Template of the partition directories of Parquet files")
+	flag.IntVar(&parquetRowGroup, "parquetRowGroup", output.DefaultRowGroupSize, "Number of rows of Parquet row groups")
+	flag.StringVar(&parquetSchema, "parquetSchema", "", "YAML file mapping Parquet column names to types (bool, int32, int64, uint64, float, double, timestamp, string, binary), schema is inferred per channel and event ID if empty")
+	flag.StringVar(&parquetComp, "parquetCompression", "snappy", "Compression of Parquet files: snappy, gzip or none")
+	flag.StringVar(&outSQLite, "sqlite", "", "SQLite database events are loaded into (implies -type sqlite)")
+	flag.Var(metaFields, "field", "Static field added to the events under Meta/Fields as name=value (can be repeated)")
+	flag.BoolVar(&provenance, "provenance", provenance, "Adds the provenance of the events under Meta: file, file SHA-256, chunk, chunk and event offsets, collector hostname")
+	flag.StringVar(&configFile, "config", "", "YAML configuration of the pipeline (inputs, filters, transforms and outputs), options given on the command line override it")
+	flag.StringVar(&routesFile, "routes", "", "YAML file of the routes sending events to several outputs according to filters (exclusive with -type)")
+	flag.StringVar(&tlsConfig.CAFile, "tlsCA", "", "PEM file of the CAs used to verify remote collector")
+	flag.StringVar(&tlsConfig.CertFile, "tlsCert", "", "Client certificate used to authenticate to remote collector")
+	flag.StringVar(&tlsConfig.KeyFile, "tlsKey", "", "Client key used to authenticate to remote collector")
+	flag.BoolVar(&tlsConfig.InsecureSkipVerify, "tlsInsecure", false, "Does not verify remote collector certificate")
+	flag.IntVar(&batchSize, "batch", output.DefaultBatchOptions.Size, "Number of events sent at once to remote collector")
+	flag.DurationVar(&flushInterval, "flush", output.DefaultBatchOptions.Interval, "Maximum time events wait before being sent to remote collector")
+	flag.StringVar(&queueDir, "queue", queueDir, "Directory where events are queued on disk before being sent to remote collector (at-least-once delivery)")
+	flag.Int64Var(&queueMax, "queueMax", queueMax, "Maximum size of the disk queue in MB (0 means no limit)")
+	flag.StringVar(&overflowstr, "overflow", "block", "Policy applied when the disk queue is full: block, drop-oldest, drop-newest")
+	flag.IntVar(&retries, "retries", output.DefaultRetryPolicy.MaxRetries, "Number of retries (with exponential backoff) when remote collector fails (-1 retries forever)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s (commit: %s)\n%s\n%s\n\n", Version, CommitID, Copyright, License)
@@ -277,6 +567,61 @@ func main() {
 		return
 	}
 
+	var config *pipeline.Config
+	if configFile != "" {
+		var err error
+		if config, err = pipeline.LoadConfig(configFile); err != nil {
+			log.Abort(ExitFail, err)
+		}
+		applyConfig(config)
+	}
+
+	// compile the filters
+	if querystr != "" {
+		q, err := query.Compile(querystr)
+		if err != nil {
+			log.Abort(ExitFail, err)
+		}
+		filters = append(filters, q)
+	}
+
+	if xpathstr != "" {
+		x, err := xpathFilter(xpathstr)
+		if err != nil {
+			log.Abort(ExitFail, err)
+		}
+		filters = append(filters, x)
+	}
+
+	if schema != "" {
+		var err error
+		if normalizer, err = normalize.Lookup(schema); err != nil {
+			log.Abort(ExitFail, err)
+		}
+	}
+
+	switch format {
+	case "json":
+	case "csv", "tsv":
+		printer = &output.CSV{Dir: csvDir}
+		if format == "tsv" {
+			printer.Comma = '\t'
+		}
+		if columns != "" {
+			printer.Columns = strings.Split(columns, ",")
+		}
+		if err := printer.Open(); err != nil {
+			log.Abort(ExitFail, err)
+		}
+		defer func() {
+			if err := printer.Close(); err != nil {
+				log.Error(err)
+			}
+		}()
+	default:
+		log.Abort(ExitFail, fmt.Errorf("Unknown format: %s", format))
+	}
+
 	// Handle profiling functions
 	if memprofile != "" {
 		defer func() {
@@ -307,41 +652,283 @@ func main() {
 	// init stats in case needed
 	s := newStats()
 
-	// init tcp sender if exists
+	// init remote output if needed
 	var out output.Output
+	if outType == "" && outSQLite != "" {
+		outType = "sqlite"
+	}
 	switch outType {
+	case "":
 	case "http":
-		httpOut := &output.HttpJSON{
-			Url: outHttp,
-			Tag: tag,
-		}
-		if err := httpOut.Open(outHttp); err != nil {
-			log.Errorf("Can't init http conn", err)
+		out = &output.HttpJSON{
+			Url:         outHttp,
+			Format:      httpFormat,
+			Compression: httpCompression,
+			Headers:     httpHeaders,
+			Username:    httpUser,
+			Password:    os.Getenv("EVTXDUMP_HTTP_PASSWORD"),
+			BearerToken: os.Getenv("EVTXDUMP_HTTP_TOKEN"),
+			HMACKey:     os.Getenv("EVTXDUMP_HTTP_HMAC_KEY"),
+			Proxy:       httpProxy,
+			Tag:         tag,
+			TLS:         tlsConfig,
+			Timeout:     httpTimeout,
 		}
-		out = httpOut
 	case "tcp":
-		tcpOut := &output.TcpJSON{
-			Tag: tag,
+		out = &output.TcpJSON{
+			Address:      outTcp,
+			Network:      tcpNet,
+			Framing:      tcpFraming,
+			Tag:          tag,
+			TLS:          tlsConfig,
+			WriteTimeout: tcpWriteTimeout,
+			KeepAlive:    tcpKeepAlive,
 		}
-		if err := tcpOut.Open(outTcp); err != nil {
-			log.Errorf("Can't init tcp conn", err)
-		}
-		out = tcpOut
 	case "kafka":
-		kafkaOut := &output.Kafka{
-			BrokerURLs: brURL,
-			Topic:      topic,
-			ClientID:   cID,
+		out = &output.Kafka{
+			BrokerURLs:   brURL,
+			Topic:        topic,
+			ClientID:     cID,
+			Key:          kafkaKey,
+			Compression:  kafkaCodec,
+			RequiredAcks: kafkaAcks,
+			Async:        kafkaAsync,
+			UseTLS:       kafkaTLS,
+			TLS:          tlsConfig,
+			SASL:         kafkaSASL,
+			Username:     kafkaUser,
+			Password:     os.Getenv("EVTXDUMP_KAFKA_PASSWORD"),
+			Tag:          tag,
+		}
+	case "syslog":
+		facility, ok := output.SyslogFacilities[syslogFacility]
+		if !ok {
+			log.Abort(ExitFail, fmt.Errorf("Unknown syslog facility: %s", syslogFacility))
+		}
+		out = &output.Syslog{
+			Network:  syslogNet,
+			Address:  outSyslog,
+			Facility: facility,
+			AppName:  "evtxdump",
+			Format:   syslogFormat,
+			Tag:      tag,
+			TLS:      tlsConfig,
+		}
+	case "elasticsearch":
+		out = &output.Elasticsearch{
+			URL:      outES,
+			Index:    esIndex,
+			Username: esUser,
+			Password: os.Getenv("EVTXDUMP_ES_PASSWORD"),
+			APIKey:   os.Getenv("EVTXDUMP_ES_APIKEY"),
+			Tag:      tag,
+			TLS:      tlsConfig,
+		}
+	case "splunk":
+		out = &output.SplunkHEC{
+			URL:        outHEC,
+			Token:      os.Getenv("EVTXDUMP_HEC_TOKEN"),
+			Sourcetype: hecSourcetype,
+			Index:      hecIndex,
+			UseAck:     hecAck,
 			Tag:        tag,
+			TLS:        tlsConfig,
+		}
+	case "gelf":
+		out = &output.GELF{
+			Network:     gelfNet,
+			Address:     outGELF,
+			Compression: gelfCompression,
+			Tag:         tag,
+			TLS:         tlsConfig,
+		}
+	case "fluent":
+		out = &output.Fluent{
+			Network:    fluentNet,
+			Address:    outFluent,
+			Tag:        fluentTag,
+			Mode:       fluentMode,
+			RequireAck: fluentAck,
+			SharedKey:  os.Getenv("EVTXDUMP_FLUENT_SHARED_KEY"),
+			TLS:        tlsConfig,
+		}
+	case "loki":
+		out = &output.Loki{
+			URL:         outLoki,
+			Labels:      strings.Split(lokiLabels, ","),
+			Encoding:    lokiEncoding,
+			TenantID:    lokiTenant,
+			Username:    lokiUser,
+			Password:    os.Getenv("EVTXDUMP_LOKI_PASSWORD"),
+			BearerToken: os.Getenv("EVTXDUMP_LOKI_TOKEN"),
+			Tag:         tag,
+			TLS:         tlsConfig,
+		}
+	case "file":
+		out = &output.File{
+			Path:        outFile,
+			Encoding:    fileEncoding,
+			Compression: fileCompression,
+			MaxSize:     fileMaxSize << 20,
+			MaxAge:      fileRotate,
+			Tag:         tag,
+		}
+	case "parquet":
+		pq := &output.Parquet{
+			Dir:          outParquet,
+			Partition:    parquetPart,
+			RowGroupSize: parquetRowGroup,
+			Compression:  parquetComp,
+		}
+		if parquetSchema != "" {
+			data, err := ioutil.ReadFile(parquetSchema)
+			if err != nil {
+				log.Abort(ExitFail, err)
+			}
+			if err := yaml.Unmarshal(data, &pq.Schema); err != nil {
+				log.Abort(ExitFail, fmt.Errorf("Bad Parquet schema %s: %s", parquetSchema, err))
+			}
+		}
+		out = pq
+	case "sqlite":
+		out = &output.SQLite{Path: outSQLite}
+	default:
+		log.Abort(ExitFail, fmt.Errorf("Unknown output type: %s", outType))
+	}
+
+	batchOpts := output.DefaultBatchOptions
+	batchOpts.Size = batchSize
+	batchOpts.Interval = flushInterval
+	batchOpts.Retry.MaxRetries = retries
+
+	var router *output.Router
+	var routes pipeline.Routes
+	switch {
+	case routesFile != "":
+		if out != nil {
+			log.Abort(ExitFail, fmt.Errorf("-routes and -type are exclusive"))
+		}
+		var err error
+		if routes, err = pipeline.LoadRoutes(routesFile); err != nil {
+			log.Abort(ExitFail, err)
+		}
+	case out == nil && config != nil && len(config.Routes) > 0:
+		routes = config.Routes
+	case out == nil && config != nil && len(config.Output) > 0:
+		var err error
+		if out, err = config.Output.New(); err != nil {
+			log.Abort(ExitFail, err)
+		}
+		outType = config.Output.Type()
+	}
+	// events are decoded with typed values if an output expects them
+	typedValues = output.TypedValues(out) || routes.TypedValues()
+	if routes != nil {
+		var err error
+		// outputs of the routes are batched and retried independently
+		if router, err = routes.Router(batchOpts); err != nil {
+			log.Abort(ExitFail, err)
+		}
+		out, outType = router, "routes"
+	}
+
+	if out != nil {
+		switch {
+		case router != nil:
+			// outputs of the routes are wrapped by the router
+		case queueDir != "":
+			// events are persisted before being sent
+			opts := output.DefaultQueueOptions
+			opts.Dir = queueDir
+			opts.MaxSize = queueMax << 20
+			opts.BatchSize = batchSize
+			overflow, err := output.ParseOverflowPolicy(overflowstr)
+			if err != nil {
+				log.Abort(ExitFail, err)
+			}
+			opts.Overflow = overflow
+			out = output.NewDiskQueue(out, opts)
+		default:
+			out = output.NewBatcher(out, batchOpts)
+		}
+		if err := out.Open(); err != nil {
+			log.Abort(ExitFail, fmt.Errorf("Can't init %s output: %s", outType, err))
+		}
+		// makes sure the last events are delivered before exiting
+		defer func() {
+			if err := out.Close(); err != nil {
+				log.Error(err)
+			}
+			if router != nil {
+				for _, rs := range router.Stats() {
+					log.Infof("Route %s: %d events matched, %d delivered, %d failed", rs.Name, rs.Matched, rs.Delivered, rs.Failed)
+				}
+			}
+		}()
+	}
+
+	// processes an event according to the options
+	if provenance || len(metaFields) > 0 || outType == "splunk" || router != nil {
+		// the file is always added as some outputs use it (ex: Splunk source)
+		enricher = pipeline.NewEnricher(metaFields, provenance)
+	}
+	handleEvent := func(p pipeline.Provenance, e *evtx.GoEvtxMap) {
+		if !match(e) {
+			return
+		}
+		if statflag {
+			// We update the stats
+			s.update(e.Channel(), e.EventID())
+		} else {
+			// We print events
+			if out != nil {
+				// blocks until the batch is delivered if full
+				t := transform(e, p)
+				if err := out.Write(context.Background(), []*evtx.GoEvtxMap{t}); err != nil {
+					log.Error(err)
+				}
+			} else {
+				printEvent(p, e)
+			}
+		}
+	}
+
+	// files given on the command line replace the inputs of the configuration
+	files, carved := flag.Args(), []string{}
+	if carve {
+		files, carved = carved, files
+	}
+	if flag.NArg() == 0 && config != nil {
+		var err error
+		if files, err = config.Inputs.EvtxFiles(); err != nil {
+			log.Abort(ExitFail, err)
 		}
-		if err := kafkaOut.Open(outHttp); err != nil {
-			log.Errorf("Can't init Kafka conn", err)
+		if carved, err = config.Inputs.CarveFiles(); err != nil {
+			log.Abort(ExitFail, err)
 		}
-		out = kafkaOut
 	}
 
-	for _, evtxFile := range flag.Args() {
-		if !carve {
+	if merge && !header {
+		evtxFiles := make([]*evtx.File, 0, len(files))
+		names := make([]string, 0, len(files))
+		for _, evtxFile := range files {
+			ef, err := evtx.OpenDirty(evtxFile)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			defer ef.Close()
+			ef.SetTypedValues(typedValues)
+			evtxFiles = append(evtxFiles, &ef)
+			names = append(names, evtxFile)
+		}
+
+		// records keep track of the file the merged events come from
+		for r := range evtx.MergeRecords(false, evtxFiles...) {
+			handleEvent(pipeline.RecordProvenance(names[r.Input], r.Record), r.Event)
+		}
+	} else {
+		for _, evtxFile := range files {
 			// Regular EVTX file, we use OpenDirty because
 			// the file might be in a dirty state
 			ef, err := evtx.OpenDirty(evtxFile)
@@ -358,24 +945,27 @@ func main() {
 				log.Error(err)
 				continue
 			}
+			ef.SetTypedValues(typedValues)
 
-			for e := range ef.FastEvents() {
-				if statflag {
-					// We update the stats
-					s.update(e.Channel(), e.EventID())
-				} else {
-					// We print events
-					if outType != "" {
-						out.Request(e)
-					} else {
-						printEvent(e)
-					}
+			if provenance {
+				for r := range ef.Records(false) {
+					handleEvent(pipeline.RecordProvenance(evtxFile, r), r.Event)
 				}
+				continue
 			}
-		} else {
-			evtx.SetModeCarving(true)
+			p := pipeline.NoProvenance
+			p.File = evtxFile
+			for e := range ef.FastEvents() {
+				handleEvent(p, e)
+			}
+		}
+	}
+
+	if len(carved) > 0 && !header {
+		evtx.SetModeCarving(true)
+		for _, datafile := range carved {
 			// We have to carve the file
-			carveFile(evtxFile, offset, limit)
+			carveFile(datafile, offset, limit, handleEvent)
 		}
 	}
 
@@ -0,0 +1,176 @@
+/*
+EVTX hunting utility, it can be used to run Sigma rules against EVTX files
+
+Copyright (C) 2017  RawSec SARL (0xrawsec)
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-evtx/sigma"
+	"github.com/0xrawsec/golang-utils/args"
+	"github.com/0xrawsec/golang-utils/log"
+)
+
+const (
+	// ExitSuccess RC
+	ExitSuccess = 0
+	// ExitFail RC
+	ExitFail  = 1
+	Copyright = "Evtxhunt Copyright (C) 2017 RawSec SARL (@0xrawsec)"
+	License   = `License GPLv3: This program comes with ABSOLUTELY NO WARRANTY.
+This is free software, and you are welcome to redistribute it under certain
+conditions;`
+)
+
+var (
+	debug     bool
+	version   bool
+	unordered bool
+	statflag  bool
+	level     string
+	rules     args.ListVar
+)
+
+// detection information added to the matching events
+type detection struct {
+	Title string   `json:"Title"`
+	ID    string   `json:"ID,omitempty"`
+	Level string   `json:"Level,omitempty"`
+	Tags  []string `json:"Tags,omitempty"`
+	Path  string   `json:"Path"`
+}
+
+// printMatch prints a matching event along with the rules it matched
+func printMatch(file string, e *evtx.GoEvtxMap, matches []*sigma.Rule) {
+	detections := make([]detection, 0, len(matches))
+	for _, r := range matches {
+		detections = append(detections, detection{r.Title, r.ID, r.Level, r.Tags, r.Path})
+	}
+	out := evtx.GoEvtxMap{
+		"Event": (*e)["Event"],
+		"Detection": evtx.GoEvtxMap{
+			"File":  file,
+			"Rules": detections,
+		},
+	}
+	fmt.Println(string(evtx.ToJSON(out)))
+}
+
+func main() {
+	flag.BoolVar(&debug, "d", debug, "Enable debug mode")
+	flag.BoolVar(&version, "V", version, "Show version and exit")
+	flag.BoolVar(&unordered, "u", unordered, "Does not care about ordering the events (faster for large files)")
+	flag.BoolVar(&statflag, "s", statflag, "Prints the number of matches per rule at the end")
+	flag.StringVar(&level, "level", "informational", "Minimum level of the rules to report (informational, low, medium, high, critical)")
+	flag.Var(&rules, "r", "Sigma rule file or directory (can be used several times)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s (commit: %s)\n%s\n%s\n\n", Version, CommitID, Copyright, License)
+		fmt.Fprintf(os.Stderr, "Usage of %s: %[1]s [OPTIONS] -r RULES FILES...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	// Debug mode
+	if debug {
+		log.InitLogger(log.LDebug)
+	}
+
+	// version
+	if version {
+		fmt.Fprintf(os.Stderr, "%s (commit: %s)\n%s\n%s\n", Version, CommitID, Copyright, License)
+		return
+	}
+
+	minLevel, ok := sigma.Levels[level]
+	if !ok {
+		log.Abort(ExitFail, fmt.Errorf("Unknown level: %s", level))
+	}
+
+	if len(rules) == 0 || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(ExitFail)
+	}
+
+	// loading rules
+	engine := sigma.NewEngine()
+	for _, r := range rules {
+		fi, err := os.Stat(r)
+		if err != nil {
+			log.Abort(ExitFail, err)
+		}
+		if fi.IsDir() {
+			for _, err := range engine.LoadDirectory(r) {
+				log.Warn(err)
+			}
+		} else if err := engine.Load(r); err != nil {
+			log.Warn(err)
+		}
+	}
+	log.Infof("Rules loaded: %d", engine.Len())
+
+	counts := make(map[string]uint)
+	for _, evtxFile := range flag.Args() {
+		ef, err := evtx.OpenDirty(evtxFile)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		var events chan *evtx.GoEvtxMap
+		if unordered {
+			events = ef.UnorderedEvents()
+		} else {
+			events = ef.FastEvents()
+		}
+
+		for e := range events {
+			matches := make([]*sigma.Rule, 0)
+			for _, r := range engine.Match(e) {
+				if r.LevelValue() >= minLevel {
+					matches = append(matches, r)
+					counts[r.Title]++
+				}
+			}
+			if len(matches) > 0 {
+				printMatch(evtxFile, e, matches)
+			}
+		}
+		ef.Close()
+	}
+
+	// We print the stats if needed
+	if statflag {
+		titles := make([]string, 0, len(counts))
+		for t := range counts {
+			titles = append(titles, t)
+		}
+		sort.Strings(titles)
+		fmt.Fprintf(os.Stderr, "Rule,Count\n")
+		for _, t := range titles {
+			fmt.Fprintf(os.Stderr, "%q,%d\n", t, counts[t])
+		}
+	}
+}
@@ -22,6 +22,7 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -33,6 +34,9 @@ import (
 	"time"
 
 	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-evtx/normalize"
+	outputs "github.com/0xrawsec/golang-evtx/output"
+	"github.com/0xrawsec/golang-evtx/pipeline"
 	"github.com/0xrawsec/golang-utils/args"
 	"github.com/0xrawsec/golang-utils/datastructs"
 	"github.com/0xrawsec/golang-utils/log"
@@ -59,6 +63,10 @@ var (
 	filters         args.ListIntVar
 	duration        DurationArg
 	output          string
+	routesFile      string
+	configFile      string
+	filter          outputs.Matcher
+	router          *outputs.Router
 	utctime         = evtx.Path("/Event/EventData/UtcTime")
 )
 
@@ -174,6 +182,20 @@ func XMLEventToGoEvtxMap(xe *wevtapi.XMLEvent) (*evtx.GoEvtxMap, error) {
 	return &ge, nil
 }
 
+// closeRouter delivers the pending events of the routes and prints their stats
+func closeRouter() {
+	if router == nil {
+		return
+	}
+	if err := router.Close(); err != nil {
+		log.Error(err)
+	}
+	for _, rs := range router.Stats() {
+		fmt.Fprintf(os.Stderr, "Route %s: %d events matched, %d delivered, %d failed\n", rs.Name, rs.Matched, rs.Delivered, rs.Failed)
+	}
+	router = nil
+}
+
 func main() {
 	var err error
 	var ofile *os.File
@@ -191,6 +213,8 @@ func main() {
 	flag.BoolVar(&statsFlag, "s", statsFlag, "Outputs stats about events processed")
 	flag.BoolVar(&debug, "d", debug, "Enable debug messages")
 	flag.BoolVar(&monitorExisting, "e", monitorExisting, "Return also already existing events")
+	flag.StringVar(&configFile, "config", configFile, "YAML configuration of the pipeline (input file, filters, transforms and outputs), options given on the command line override it")
+	flag.StringVar(&routesFile, "routes", routesFile, "YAML file of the routes sending monitored events to outputs according to filters")
 
 	flag.Parse()
 
@@ -210,6 +234,7 @@ func main() {
 		writer.Flush()
 		writer.Close()
 		ofile.Close()
+		closeRouter()
 		if statsFlag {
 			stats.Summary()
 		}
@@ -235,6 +260,74 @@ func main() {
 		defer ofile.Close()
 	}
 
+	var routes pipeline.Routes
+	var enricher *pipeline.Enricher
+	var normalizer *normalize.Schema
+	batchOpts := outputs.DefaultBatchOptions
+	if configFile != "" {
+		config, err := pipeline.LoadConfig(configFile)
+		if err != nil {
+			log.Abort(ExitFailure, err)
+		}
+		// a single file is monitored, the inputs it can't process are errors
+		// rather than being ignored
+		switch {
+		case len(config.Inputs.Carve) > 0:
+			log.Abort(ExitFailure, fmt.Errorf("Bad configuration %s: inputs: carve is not supported by evtxmon", configFile))
+		case config.Inputs.Merge:
+			log.Abort(ExitFailure, fmt.Errorf("Bad configuration %s: inputs: merge is not supported by evtxmon", configFile))
+		}
+		if evtxfile == "" {
+			files, err := config.Inputs.EvtxFiles()
+			if err != nil {
+				log.Abort(ExitFailure, err)
+			}
+			if len(files) > 1 {
+				log.Abort(ExitFailure, fmt.Errorf("Bad configuration %s: inputs: evtxmon monitors a single file, %d found", configFile, len(files)))
+			}
+			if len(files) > 0 {
+				evtxfile = files[0]
+			}
+		}
+		if filter, err = pipeline.Filter(config.Filters.Query, config.Filters.XPath); err != nil {
+			log.Abort(ExitFailure, err)
+		}
+		routes = config.Routes
+		if len(config.Output) > 0 {
+			// a single output is a route taking all the events
+			routes = pipeline.Routes{{
+				Name:     config.Output.Type(),
+				Queue:    config.Delivery.Queue,
+				QueueMax: config.Delivery.QueueMax,
+				Overflow: config.Delivery.Overflow,
+				Output:   config.Output,
+			}}
+		}
+		batchOpts = config.Delivery.BatchOptions()
+		if config.Transforms.Normalize != "" {
+			if normalizer, err = normalize.Lookup(config.Transforms.Normalize); err != nil {
+				log.Abort(ExitFailure, err)
+			}
+		}
+		if len(config.Transforms.Fields) > 0 || config.Transforms.Provenance {
+			enricher = pipeline.NewEnricher(config.Transforms.Fields, config.Transforms.Provenance)
+		}
+	}
+	if routesFile != "" {
+		if routes, err = pipeline.LoadRoutes(routesFile); err != nil {
+			log.Abort(ExitFailure, err)
+		}
+	}
+	if len(routes) > 0 {
+		if router, err = routes.Router(batchOpts); err != nil {
+			log.Abort(ExitFailure, err)
+		}
+		if err := router.Open(); err != nil {
+			log.Abort(ExitFailure, err)
+		}
+		defer closeRouter()
+	}
+
 	if evtxfile == "" {
 		flag.Usage()
 		os.Exit(1)
@@ -244,6 +337,8 @@ func main() {
 		if err != nil && err != evtx.ErrDirtyFile {
 			log.Abort(ExitFailure, err)
 		}
+		// events are decoded with typed values if an output expects them
+		ef.SetTypedValues(routes.TypedValues())
 
 		if statsFlag {
 			go func() {
@@ -281,11 +376,30 @@ func main() {
 		}*/
 
 		for e := range ef.MonitorEvents(stop) {
+			if filter != nil && !filter.Match(e) {
+				continue
+			}
+			// stats are computed on the original event
+			t := e
+			if normalizer != nil {
+				t = normalizer.Normalize(t)
+			}
+			if enricher != nil {
+				// offsets are not known when monitoring
+				p := pipeline.NoProvenance
+				p.File = evtxfile
+				t = enricher.Enrich(t, p)
+			}
 			if output != "" {
-				writer.Write(evtx.ToJSON(e))
+				writer.Write(evtx.ToJSON(t))
 				writer.Write([]byte("\n"))
 				writer.Flush()
 			}
+			if router != nil {
+				if err := router.Write(context.Background(), []*evtx.GoEvtxMap{t}); err != nil {
+					log.Error(err)
+				}
+			}
 			if statsFlag {
 				stats.Update(e)
 			} else {
@@ -0,0 +1,128 @@
+package xpath
+
+import (
+	"fmt"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokName
+	tokString
+	tokNumber
+	tokOperator
+	tokAt
+	tokStar
+	tokSlash
+	tokComma
+	tokLBracket
+	tokRBracket
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	typ   tokenType
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.typ == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+func (t token) is(kw string) bool {
+	return t.typ == tokName && t.value == kw
+}
+
+// ErrSyntax is returned when an XPath expression cannot be parsed
+type ErrSyntax struct {
+	Pos int
+	Msg string
+}
+
+func (e *ErrSyntax) Error() string {
+	return fmt.Sprintf("XPath syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' || r == ':'
+}
+
+func isHexRune(r rune) bool {
+	return unicode.IsDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+// lex splits an XPath expression into tokens
+func lex(x string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(x)
+	single := map[rune]tokenType{
+		'@': tokAt,
+		'*': tokStar,
+		'/': tokSlash,
+		',': tokComma,
+		'[': tokLBracket,
+		']': tokRBracket,
+		'(': tokLParen,
+		')': tokRParen,
+	}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if typ, ok := single[r]; ok {
+			tokens = append(tokens, token{typ, string(r), i})
+			i++
+			continue
+		}
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			start := i
+			for i++; i < len(runes) && runes[i] != r; i++ {
+			}
+			if i >= len(runes) {
+				return nil, &ErrSyntax{start, "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, string(runes[start+1 : i]), start})
+			i++
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, &ErrSyntax{start, "unknown operator !"}
+			}
+			tokens = append(tokens, token{tokOperator, op, start})
+		case r == '0' && i+2 < len(runes) && (runes[i+1] == 'x' || runes[i+1] == 'X') && isHexRune(runes[i+2]):
+			// hexadecimal masks (ex: band(Keywords,0x10))
+			start := i
+			for i += 2; i < len(runes) && isHexRune(runes[i]); i++ {
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start})
+		case isNameRune(r):
+			start := i
+			for i < len(runes) && isNameRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokName, string(runes[start:i]), start})
+		default:
+			return nil, &ErrSyntax{i, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(runes)})
+	return tokens, nil
+}
@@ -0,0 +1,136 @@
+package xpath
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+var (
+	dataKeyRE = regexp.MustCompile(`^Data\d*$`)
+)
+
+// node is a view of a GoEvtxMap element as an XML node. Because GoEvtxMap
+// flattens <Data Name="Key">Value</Data> elements into Key: Value entries,
+// virtual Data nodes are created on the fly with a Name attribute so that the
+// usual Windows XPath expressions keep working.
+type node struct {
+	name  string
+	value interface{}
+	attrs map[string]interface{}
+	attr  bool
+}
+
+func mapOf(v interface{}) (evtx.GoEvtxMap, bool) {
+	switch m := v.(type) {
+	case evtx.GoEvtxMap:
+		return m, true
+	case map[string]interface{}:
+		return evtx.GoEvtxMap(m), true
+	case *evtx.GoEvtxMap:
+		return *m, true
+	}
+	return nil, false
+}
+
+// sortedKeys is used to keep node sets in a deterministic order
+func sortedKeys(m evtx.GoEvtxMap) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// children returns the child elements of n named name (* for all)
+func (n node) children(name string) []node {
+	m, ok := mapOf(n.value)
+	if !ok || n.attr {
+		return nil
+	}
+	out := make([]node, 0)
+	switch {
+	case name == "*":
+		for _, k := range sortedKeys(m) {
+			out = append(out, node{name: k, value: m[k]})
+		}
+	case name == "Data":
+		if _, ok := m["Data"]; ok {
+			for _, k := range sortedKeys(m) {
+				if dataKeyRE.MatchString(k) {
+					out = append(out, node{name: "Data", value: m[k]})
+				}
+			}
+			return out
+		}
+		// virtual nodes made out of flattened Name/Value pairs
+		for _, k := range sortedKeys(m) {
+			if _, isMap := mapOf(m[k]); isMap || k == "xmlns" {
+				continue
+			}
+			out = append(out, node{name: "Data", value: m[k], attrs: map[string]interface{}{"Name": k}})
+		}
+	default:
+		if v, ok := m[name]; ok {
+			out = append(out, node{name: name, value: v})
+		}
+	}
+	return out
+}
+
+// attribute returns the attribute named name of n
+func (n node) attribute(name string) []node {
+	if n.attr {
+		return nil
+	}
+	if v, ok := n.attrs[name]; ok {
+		return []node{{name: name, value: v, attr: true}}
+	}
+	m, ok := mapOf(n.value)
+	if !ok {
+		return nil
+	}
+	out := make([]node, 0)
+	for _, k := range sortedKeys(m) {
+		if k != name && name != "*" {
+			continue
+		}
+		// attributes are scalar values
+		if _, isMap := mapOf(m[k]); !isMap && k != "Value" {
+			out = append(out, node{name: k, value: m[k], attr: true})
+		}
+	}
+	return out
+}
+
+// text returns the string value of the node
+func (n node) text() string {
+	if m, ok := mapOf(n.value); ok {
+		if v, ok := m["Value"]; ok {
+			return toString(v)
+		}
+		return ""
+	}
+	return toString(n.value)
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case nil:
+		return ""
+	case time.Time:
+		return s.UTC().Format(time.RFC3339Nano)
+	case evtx.UTCTime:
+		return time.Time(s).UTC().Format(time.RFC3339Nano)
+	case []string:
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,125 @@
+package xpath
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+// Selector is a Select or Suppress element of a Query
+type Selector struct {
+	Path  string `xml:"Path,attr"`
+	XPath string `xml:",chardata"`
+	expr  *Expr
+}
+
+// matchPath returns true if the selector applies to the channel
+func (s *Selector) matchPath(defaultPath, channel string) bool {
+	path := s.Path
+	if path == "" {
+		path = defaultPath
+	}
+	// queries on log files apply to any event
+	if path == "" || strings.HasPrefix(strings.ToLower(path), "file://") {
+		return true
+	}
+	return strings.EqualFold(path, channel)
+}
+
+// Query is a Query element of a QueryList
+type Query struct {
+	ID         string     `xml:"Id,attr"`
+	Path       string     `xml:"Path,attr"`
+	Selects    []Selector `xml:"Select"`
+	Suppresses []Selector `xml:"Suppress"`
+}
+
+// Match returns true if the event is selected by at least one Select
+// element and is not suppressed by any Suppress element
+func (q *Query) Match(e *evtx.GoEvtxMap) bool {
+	channel, _ := e.GetString(&evtx.ChannelPath)
+	selected := false
+	for i := range q.Selects {
+		s := &q.Selects[i]
+		if s.matchPath(q.Path, channel) && s.expr.Match(e) {
+			selected = true
+			break
+		}
+	}
+	if !selected {
+		return false
+	}
+	for i := range q.Suppresses {
+		s := &q.Suppresses[i]
+		if s.matchPath(q.Path, channel) && s.expr.Match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryList structure as used by Event Viewer and WEF subscriptions
+type QueryList struct {
+	XMLName xml.Name `xml:"QueryList"`
+	Queries []Query  `xml:"Query"`
+}
+
+// compile compiles all the XPath expressions of the QueryList
+func (ql *QueryList) compile() (err error) {
+	for i := range ql.Queries {
+		q := &ql.Queries[i]
+		for _, selectors := range [][]Selector{q.Selects, q.Suppresses} {
+			for j := range selectors {
+				if selectors[j].expr, err = Compile(strings.TrimSpace(selectors[j].XPath)); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// ParseQueryList parses a QueryList XML document and compiles its queries
+// @r : reader to read the XML from
+// return (*QueryList, error)
+func ParseQueryList(r io.Reader) (*QueryList, error) {
+	ql := QueryList{}
+	if err := xml.NewDecoder(r).Decode(&ql); err != nil {
+		return nil, err
+	}
+	if err := ql.compile(); err != nil {
+		return nil, err
+	}
+	return &ql, nil
+}
+
+// ParseQueryListString parses a QueryList from a string
+func ParseQueryListString(s string) (*QueryList, error) {
+	return ParseQueryList(strings.NewReader(s))
+}
+
+// OpenQueryList parses a QueryList from a file
+func OpenQueryList(path string) (*QueryList, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return ParseQueryList(fd)
+}
+
+// Match returns true if the event is selected by any of the queries
+func (ql *QueryList) Match(e *evtx.GoEvtxMap) bool {
+	if e == nil {
+		return false
+	}
+	for i := range ql.Queries {
+		if ql.Queries[i].Match(e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,580 @@
+/*
+Package xpath evaluates the subset of XPath 1.0 used by Windows event queries
+(Event Viewer custom views, wevtutil, WEF subscriptions) against GoEvtxMap
+events. It also parses QueryList documents and implements their Select and
+Suppress semantics.
+
+Example of supported expressions:
+
+	*[System[(EventID=4624 or EventID=4625) and Level<=4]]
+	*[System[Provider[@Name='Microsoft-Windows-Sysmon'] and TimeCreated[timediff(@SystemTime) <= 86400000]]]
+	*[EventData[Data[@Name='TargetUserName']='Administrator']]
+	*[System[band(Keywords,4503599627370496)]]
+*/
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+/////////////////////////////////// Results ////////////////////////////////////
+
+type resultKind int
+
+const (
+	kindNodes resultKind = iota
+	kindString
+	kindNumber
+	kindBool
+)
+
+type result struct {
+	kind  resultKind
+	nodes []node
+	str   string
+	num   float64
+	b     bool
+	// integer numbers are kept as uint64 since masks (ex: Keywords) do not
+	// fit in a float64
+	integer bool
+	u       uint64
+}
+
+func integerResult(u uint64) result {
+	return result{kind: kindNumber, num: float64(u), integer: true, u: u}
+}
+
+func (r result) boolean() bool {
+	switch r.kind {
+	case kindNodes:
+		return len(r.nodes) > 0
+	case kindString:
+		return r.str != ""
+	case kindNumber:
+		if r.integer {
+			return r.u != 0
+		}
+		return r.num != 0 && !math.IsNaN(r.num)
+	}
+	return r.b
+}
+
+// strings returns all the string values of the result
+func (r result) strings() []string {
+	switch r.kind {
+	case kindNodes:
+		out := make([]string, len(r.nodes))
+		for i, n := range r.nodes {
+			out[i] = n.text()
+		}
+		return out
+	case kindNumber:
+		if r.integer {
+			return []string{strconv.FormatUint(r.u, 10)}
+		}
+		return []string{strconv.FormatFloat(r.num, 'f', -1, 64)}
+	case kindBool:
+		return []string{strconv.FormatBool(r.b)}
+	}
+	return []string{r.str}
+}
+
+func (r result) number() float64 {
+	if r.kind == kindNumber {
+		return r.num
+	}
+	if s := r.strings(); len(s) > 0 {
+		if f, ok := toNumber(s[0]); ok {
+			return f
+		}
+	}
+	return math.NaN()
+}
+
+// unsigned returns the result as an integer
+func (r result) unsigned() (uint64, bool) {
+	if r.kind == kindNumber {
+		if r.integer {
+			return r.u, true
+		}
+		if r.num >= 0 && r.num < math.MaxUint64 && r.num == math.Trunc(r.num) {
+			return uint64(r.num), true
+		}
+		return 0, false
+	}
+	if s := r.strings(); len(s) > 0 {
+		return toUnsigned(s[0])
+	}
+	return 0, false
+}
+
+// toUnsigned parses an integer, negative values are taken as two's complement
+func toUnsigned(s string) (uint64, bool) {
+	s = strings.TrimSpace(s)
+	if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return u, true
+	}
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return uint64(i), true
+	}
+	return 0, false
+}
+
+func toNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return float64(i), true
+	}
+	if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return float64(u), true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true
+	}
+	return 0, false
+}
+
+func toTime(s string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// compareStrings compares two values as numbers, times or strings
+func compareStrings(op, l, r string) bool {
+	var c int
+	lu, luok := toUnsigned(l)
+	ru, ruok := toUnsigned(r)
+	ln, lok := toNumber(l)
+	rn, rok := toNumber(r)
+	lt, ltok := toTime(l)
+	rt, rtok := toTime(r)
+	switch {
+	case luok && ruok && !strings.HasPrefix(strings.TrimSpace(l), "-") && !strings.HasPrefix(strings.TrimSpace(r), "-"):
+		// exact comparison of integers
+		switch {
+		case lu < ru:
+			c = -1
+		case lu > ru:
+			c = 1
+		}
+	case lok && rok:
+		switch {
+		case ln < rn:
+			c = -1
+		case ln > rn:
+			c = 1
+		}
+	case ltok && rtok:
+		switch {
+		case lt.Before(rt):
+			c = -1
+		case lt.After(rt):
+			c = 1
+		}
+	default:
+		c = strings.Compare(l, r)
+	}
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// compare implements XPath comparison semantics, if one of the operand is a
+// node set the comparison is true if any of the node verifies it
+func compare(op string, l, r result) bool {
+	if l.kind == kindBool || r.kind == kindBool {
+		lb, rb := l.boolean(), r.boolean()
+		switch op {
+		case "=":
+			return lb == rb
+		case "!=":
+			return lb != rb
+		}
+	}
+	for _, ls := range l.strings() {
+		for _, rs := range r.strings() {
+			if compareStrings(op, ls, rs) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+///////////////////////////////// Expressions //////////////////////////////////
+
+type expr interface {
+	eval(ctx node) result
+}
+
+type literalExpr struct {
+	value string
+}
+
+func (e literalExpr) eval(node) result {
+	return result{kind: kindString, str: e.value}
+}
+
+type numberExpr struct {
+	value   float64
+	integer bool
+	u       uint64
+}
+
+func (e numberExpr) eval(node) result {
+	if e.integer {
+		return integerResult(e.u)
+	}
+	return result{kind: kindNumber, num: e.value}
+}
+
+type step struct {
+	attr  bool
+	name  string
+	preds []expr
+}
+
+type pathExpr struct {
+	steps []step
+}
+
+func (e pathExpr) eval(ctx node) result {
+	set := []node{ctx}
+	for _, s := range e.steps {
+		next := make([]node, 0)
+		for _, n := range set {
+			var candidates []node
+			if s.attr {
+				candidates = n.attribute(s.name)
+			} else {
+				candidates = n.children(s.name)
+			}
+		candidate:
+			for _, c := range candidates {
+				for _, p := range s.preds {
+					if !p.eval(c).boolean() {
+						continue candidate
+					}
+				}
+				next = append(next, c)
+			}
+		}
+		set = next
+	}
+	return result{kind: kindNodes, nodes: set}
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e binaryExpr) eval(ctx node) result {
+	switch e.op {
+	case "and":
+		return result{kind: kindBool, b: e.left.eval(ctx).boolean() && e.right.eval(ctx).boolean()}
+	case "or":
+		return result{kind: kindBool, b: e.left.eval(ctx).boolean() || e.right.eval(ctx).boolean()}
+	}
+	return result{kind: kindBool, b: compare(e.op, e.left.eval(ctx), e.right.eval(ctx))}
+}
+
+type funcExpr struct {
+	name string
+	args []expr
+}
+
+// now is used to compute timediff, it is a variable so that it can be mocked
+var now = time.Now
+
+func (e funcExpr) eval(ctx node) result {
+	switch e.name {
+	case "not":
+		return result{kind: kindBool, b: !e.args[0].eval(ctx).boolean()}
+	case "band":
+		l, lok := e.args[0].eval(ctx).unsigned()
+		r, rok := e.args[1].eval(ctx).unsigned()
+		if !lok || !rok {
+			return integerResult(0)
+		}
+		return integerResult(l & r)
+	case "timediff":
+		// difference in milliseconds between two times or with now
+		end := now()
+		if len(e.args) == 2 {
+			t, ok := toTime(firstString(e.args[1].eval(ctx)))
+			if !ok {
+				return result{kind: kindNumber, num: math.NaN()}
+			}
+			end = t
+		}
+		t, ok := toTime(firstString(e.args[0].eval(ctx)))
+		if !ok {
+			return result{kind: kindNumber, num: math.NaN()}
+		}
+		return result{kind: kindNumber, num: float64(end.Sub(t) / time.Millisecond)}
+	}
+	return result{kind: kindBool}
+}
+
+func firstString(r result) string {
+	if s := r.strings(); len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+/////////////////////////////////// Parser /////////////////////////////////////
+
+// arity of the supported functions
+var functions = map[string][2]int{
+	"not":      {1, 1},
+	"band":     {2, 2},
+	"timediff": {1, 2},
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(i int) token {
+	if p.pos+i < len(p.tokens) {
+		return p.tokens[p.pos+i]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &ErrSyntax{t.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(typ tokenType, what string) error {
+	if t := p.next(); t.typ != typ {
+		return p.errorf(t, "expecting %s got %s", what, t)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{"or", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("and") {
+		p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{"and", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ == tokOperator {
+		op := p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{op.value, left, right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.typ == tokLParen:
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(tokRParen, ")")
+	case t.typ == tokString:
+		p.next()
+		return literalExpr{t.value}, nil
+	case t.typ == tokNumber:
+		p.next()
+		f, ok := toNumber(t.value)
+		if !ok {
+			return nil, p.errorf(t, "bad number %s", t)
+		}
+		n := numberExpr{value: f}
+		if u, ok := toUnsigned(t.value); ok && !strings.HasPrefix(t.value, "-") {
+			n.integer, n.u = true, u
+		}
+		return n, nil
+	case t.typ == tokName && p.peekAt(1).typ == tokLParen:
+		return p.parseFunction()
+	}
+	return p.parsePath()
+}
+
+func (p *parser) parseFunction() (expr, error) {
+	t := p.next()
+	arity, ok := functions[t.value]
+	if !ok {
+		return nil, p.errorf(t, "unsupported function %s", t)
+	}
+	p.next()
+	f := funcExpr{name: t.value}
+	if p.peek().typ != tokRParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			f.args = append(f.args, arg)
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+	if len(f.args) < arity[0] || len(f.args) > arity[1] {
+		return nil, p.errorf(t, "bad number of arguments for %s", t)
+	}
+	return f, nil
+}
+
+func (p *parser) parsePath() (expr, error) {
+	path := pathExpr{}
+	for {
+		s := step{}
+		if p.peek().typ == tokAt {
+			p.next()
+			s.attr = true
+		}
+		t := p.next()
+		switch t.typ {
+		case tokName, tokStar:
+			s.name = t.value
+		default:
+			return nil, p.errorf(t, "expecting element or attribute name got %s", t)
+		}
+		for !s.attr && p.peek().typ == tokLBracket {
+			p.next()
+			pred, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokRBracket, "]"); err != nil {
+				return nil, err
+			}
+			s.preds = append(s.preds, pred)
+		}
+		path.steps = append(path.steps, s)
+		if s.attr || p.peek().typ != tokSlash {
+			return path, nil
+		}
+		p.next()
+	}
+}
+
+///////////////////////////////// Expression ///////////////////////////////////
+
+// Expr is a compiled XPath expression
+type Expr struct {
+	src  string
+	root expr
+}
+
+// Compile compiles an XPath expression
+// @x : XPath expression
+// return (*Expr, error)
+func Compile(x string) (*Expr, error) {
+	tokens, err := lex(x)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens}
+	// leading slash is allowed but has no meaning since we always evaluate
+	// from the document root
+	if p.peek().typ == tokSlash {
+		p.next()
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return &Expr{src: x, root: root}, nil
+}
+
+// MustCompile is like Compile but panics on error
+func MustCompile(x string) *Expr {
+	e, err := Compile(x)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// Match returns true if the event is selected by the expression
+func (x *Expr) Match(e *evtx.GoEvtxMap) bool {
+	if e == nil {
+		return false
+	}
+	return x.root.eval(node{value: *e}).boolean()
+}
+
+func (x *Expr) String() string {
+	return x.src
+}
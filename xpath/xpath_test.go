@@ -0,0 +1,117 @@
+package xpath
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/golang-evtx/evtx"
+)
+
+func processEvent() *evtx.GoEvtxMap {
+	return &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{
+			"System": evtx.GoEvtxMap{
+				"Channel":     "Security",
+				"Computer":    "DC01",
+				"EventID":     "4688",
+				"Level":       "0",
+				"Keywords":    "0x4000000000000010",
+				"Provider":    evtx.GoEvtxMap{"Name": "Microsoft-Windows-Security-Auditing"},
+				"TimeCreated": evtx.GoEvtxMap{"SystemTime": evtx.UTCTime(time.Date(2019, 5, 12, 10, 0, 0, 0, time.UTC))},
+			},
+			"EventData": evtx.GoEvtxMap{
+				"NewProcessName":  "C:\\Windows\\System32\\cmd.exe",
+				"SubjectUserName": "Administrator",
+			},
+		},
+	}
+}
+
+func TestXPath(t *testing.T) {
+	now = func() time.Time { return time.Date(2019, 5, 12, 11, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	e := processEvent()
+	for x, expect := range map[string]bool{
+		`*[System[(EventID=4688)]]`:                                                    true,
+		`*[System[(EventID=4624)]]`:                                                    false,
+		`*[System[(EventID=4624 or EventID=4688)]]`:                                    true,
+		`*[System[EventID=4688 and Level<=4]]`:                                         true,
+		`*[System[EventID=4688 and Level>4]]`:                                          false,
+		`*[System[Provider[@Name='Microsoft-Windows-Security-Auditing']]]`:             true,
+		`*[System[band(Keywords,0x10)]]`:                                               true,
+		`*[System[band(Keywords,0x20)]]`:                                               false,
+		`*[System[band(Keywords,4611686018427387904)]]`:                                true,
+		`*[System[band(Keywords,0x4000000000000010)=0x4000000000000010]]`:              true,
+		`*[EventData[Data[@Name='SubjectUserName']='Administrator']]`:                  true,
+		`*[EventData[Data[@Name='SubjectUserName']='Guest']]`:                          false,
+		`*[EventData[Data[@Name='Missing']='Administrator']]`:                          false,
+		`*[System[TimeCreated[timediff(@SystemTime) <= 3600000]]]`:                     true,
+		`*[System[TimeCreated[timediff(@SystemTime) < 3600000]]]`:                      false,
+		`*[System[EventID=4688] and EventData[Data[@Name='SubjectUserName']='Guest']]`: false,
+		`*[System[EventID=4688] or EventData[Data[@Name='SubjectUserName']='Guest']]`:  true,
+		`*[System[not(EventID=4688)]]`:                                                 false,
+		`/Event/System[Computer='DC01']`:                                               true,
+	} {
+		if m := MustCompile(x).Match(e); m != expect {
+			t.Errorf("%s: expected %t got %t", x, expect, m)
+		}
+	}
+}
+
+func TestQueryList(t *testing.T) {
+	e := processEvent()
+	for ql, expect := range map[string]bool{
+		`<QueryList><Query Id="0" Path="Security"><Select Path="Security">*[System[(EventID=4688)]]</Select></Query></QueryList>`: true,
+		// Path is matched against the channel of the event
+		`<QueryList><Query Id="0" Path="System"><Select Path="System">*[System[(EventID=4688)]]</Select></Query></QueryList>`: false,
+		`<QueryList><Query Id="0" Path="Security"><Select>*</Select></Query></QueryList>`:                                     true,
+		`<QueryList><Query Id="0" Path="file://C:\logs\Security.evtx"><Select>*</Select></Query></QueryList>`:                 true,
+		`<QueryList><Query Id="0" Path="Security">
+			<Select Path="Security">*</Select>
+			<Suppress Path="Security">*[EventData[Data[@Name='SubjectUserName']='Administrator']]</Suppress>
+		</Query></QueryList>`: false,
+		// suppression only applies to its channel
+		`<QueryList><Query Id="0" Path="Security">
+			<Select Path="Security">*</Select>
+			<Suppress Path="System">*</Suppress>
+		</Query></QueryList>`: true,
+		`<QueryList>
+			<Query Id="0" Path="System"><Select Path="System">*</Select></Query>
+			<Query Id="1" Path="Security"><Select Path="Security">*[System[Level=0]]</Select></Query>
+		</QueryList>`: true,
+	} {
+		q, err := ParseQueryListString(ql)
+		if err != nil {
+			t.Fatalf("%s: %s", ql, err)
+		}
+		if m := q.Match(e); m != expect {
+			t.Errorf("%s: expected %t got %t", ql, expect, m)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, x := range []string{
+		`*[System[(EventID=4688)]`,
+		`*[System[(EventID=4688]]`,
+		`*[System[EventID=]]`,
+		`*[System[EventID='4688]]`,
+		`*[System[band(Keywords)]]`,
+		`*[System[unknown(EventID)]]`,
+		`*[System[EventID=4688 and]]`,
+		`*[@]`,
+	} {
+		if _, err := Compile(x); err == nil {
+			t.Errorf("%s: expected syntax error", x)
+		}
+	}
+	for _, ql := range []string{
+		`<QueryList><Query Id="0"><Select>*[System[</Select></Query></QueryList>`,
+		`<QueryList><Query Id="0">`,
+	} {
+		if _, err := ParseQueryListString(ql); err == nil {
+			t.Errorf("%s: expected error", ql)
+		}
+	}
+}